@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// annotationsGitHub makes problems found in migration files printed as GitHub Actions workflow commands,
+// so they are shown inline on pull requests.
+const annotationsGitHub = "github"
+
+// annotations is format of annotations chosen by -annotations flag, empty if they are disabled.
+var annotations string
+
+var (
+	annotationMessage  = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	annotationProperty = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// annotate prints annotation of migration file given relative to migrations dir, file may be empty
+// if the problem is not bound to file and line is zero if it is not known.
+func annotate(level, file string, line int, message string) {
+	if annotations != annotationsGitHub {
+		return
+	}
+	var props []string
+	if file != "" {
+		props = append(props, "file="+annotationProperty.Replace(path.Join(migrationsDirName, file)))
+		if line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", line))
+		}
+	}
+	command := "::" + level
+	if len(props) > 0 {
+		command += " " + strings.Join(props, ",")
+	}
+	fmt.Println(command + "::" + annotationMessage.Replace(message))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// runner applies pending migrations one by one.
+type runner struct {
+	db       *gorm.DB
+	store    VersionStore
+	progress *progress
+	verbose  bool // echo executed statements
+	version  int  // server version in server_version_num format
+	skipList map[string]bool
+	env      string // name of the environment like production or staging
+	schema   string // default search_path of migrations
+	// Opens separate connection to the database, i.e. to watch progress of statements, may be nil
+	dial func() (*gorm.DB, error)
+	vcs  *vcsInfo // nil if git metadata is not recorded
+	// Dedicated connection db works on while migration with copy directives is applied
+	conn *sql.Conn
+	// Comment prefixed to executed statements of the current migration
+	comment string
+	// Retries of transactional migrations failed by transient errors, reconnect restores state of lost connection
+	retry     Retry
+	reconnect func() error
+}
+
+// record returns tracking row of migration applied by the runner.
+func (r *runner) record(m migrationFile) *Migration {
+	record := m.Record()
+	if r.vcs != nil {
+		record.GitSHA, record.GitAuthor = r.vcs.SHA(), r.vcs.Author(m)
+	}
+	return record
+}
+
+// newSkipList returns set of migrations to skip, names may be given without extension and qualified by module and track.
+func newSkipList(names []string) map[string]bool {
+	skip := make(map[string]bool, len(names))
+	for _, name := range names {
+		skip[trimMigrationExt(name)] = true
+	}
+	return skip
+}
+
+// Run applies migration or records it as skipped when it is not applicable,
+// returning the reason of skipping.
+func (r *runner) Run(m migrationFile) (string, error) {
+	if r.skipList[trimMigrationExt(m.Name)] || r.skipList[trimMigrationExt(m.Path())] {
+		const reason = "listed in skip config"
+		return reason, r.skip(m, reason)
+	}
+	reason, err := checkEnvironment(m, r.env)
+	if err != nil {
+		return "", err
+	}
+	if reason == "" {
+		if reason, err = checkServerVersion(m, r.version); err != nil {
+			return "", err
+		}
+	}
+	if reason != "" {
+		return reason, r.skip(m, reason)
+	}
+	if err := checkExtensions(r.db, m); err != nil {
+		return "", err
+	}
+	if m.stream != nil {
+		for _, name := range []string{concurrentIndexDirective, batchedDirective, copyDirective} {
+			if _, ok := findDirective(parseDirectives(m.Body), name); ok {
+				return "", fmt.Errorf("%s directive is not supported by streamed migration", name)
+			}
+		}
+		return "", r.applyStreamed(m)
+	}
+	if d, ok := findDirective(parseDirectives(m.Body), concurrentIndexDirective); ok {
+		retries, err := parseIndexRetries(d)
+		if err != nil {
+			return "", err
+		}
+		return "", r.applyConcurrentIndex(retries, m)
+	}
+	if d, ok := findDirective(parseDirectives(m.Body), batchedDirective); ok {
+		opts, err := parseBatchOptions(d)
+		if err != nil {
+			return "", err
+		}
+		return "", r.applyBatched(opts, m)
+	}
+	if _, ok := findDirective(parseDirectives(m.Body), copyDirective); ok && r.conn == nil {
+		return "", r.retrying(m, func() error { return r.applyWithCopies(m) })
+	}
+	return "", r.retrying(m, func() error { return r.apply(m) })
+}
+
+// skip records migration as skipped without its execution.
+func (r *runner) skip(m migrationFile, reason string) error {
+	record := r.record(m)
+	record.SkipReason = reason
+	if err := r.store.Record(r.db, record); err != nil {
+		return fmt.Errorf("can't init migration stat: %w", err)
+	}
+	return nil
+}
+
+// apply executes migration in a transaction together with recording it into the store.
+// When runner itself works in a transaction (test mode) the migration is executed in a savepoint.
+func (r *runner) apply(m migrationFile) error {
+	started := time.Now()
+	record := r.record(m)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Statements are executed one by one to be able to report progress of big migrations,
+		// each one in a savepoint to be able to go on after failure of best-effort ones
+		statements := splitStatements(m.Body)
+		bestEffort := bestEffortStatements(m.Body, statements)
+		copies := copyDirectives(m.Body)
+		restore, err := r.setSession(tx, m)
+		if err != nil {
+			return err
+		}
+		for i, stmt := range statements {
+			r.progress.Statement(i+1, len(statements))
+			if copies, err = r.copyRows(m, copies, stmt.Line); err != nil {
+				return err
+			}
+			if err := tx.SavePoint(statementSavepoint).Error; err != nil {
+				return fmt.Errorf("can't create savepoint: %w", err)
+			}
+			if err := r.exec(tx, stmt.SQL).Error; err != nil {
+				if !bestEffort[stmt.Line] {
+					return fmt.Errorf("can't execute statement at line %d (%s): %w", stmt.Line, snippet(stmt.SQL), err)
+				}
+				if err := tx.RollbackTo(statementSavepoint).Error; err != nil {
+					return fmt.Errorf("can't roll back to savepoint: %w", err)
+				}
+				logrus.WithError(err).Warnf("migration %s: best-effort statement at line %d has failed", m.Path(), stmt.Line)
+				continue
+			}
+			if err := tx.Exec("RELEASE SAVEPOINT " + statementSavepoint).Error; err != nil {
+				return fmt.Errorf("can't release savepoint: %w", err)
+			}
+		}
+		if _, err := r.copyRows(m, copies, math.MaxInt); err != nil {
+			return err
+		}
+		if err := restore(); err != nil {
+			return err
+		}
+		record.DurationMs = time.Since(started).Milliseconds()
+		if err := r.store.Record(tx, record); err != nil {
+			return fmt.Errorf("can't init migration stat: %w", err)
+		}
+		return nil
+	})
+}
+
+// statementSavepoint is created before each statement of migration.
+const statementSavepoint = "migrator_statement"
+
+// continueOnErrorDirective marks the following statement as best-effort, i.e. cleanup one:
+// its failure is rolled back to savepoint and logged, while migration goes on.
+const continueOnErrorDirective = "continue-on-error"
+
+// bestEffortStatements returns lines of statements following continue-on-error directives.
+func bestEffortStatements(body string, statements []statement) map[int]bool {
+	lines := make(map[int]bool)
+	for _, d := range parseDirectives(body) {
+		if d.Name != continueOnErrorDirective {
+			continue
+		}
+		for _, stmt := range statements {
+			if stmt.Line > d.Line {
+				lines[stmt.Line] = true
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// snippet returns the first line of statement shortened to be included into error message.
+func snippet(sql string) string {
+	const maxLen = 60
+	line, _, multiline := strings.Cut(sql, "\n")
+	if len(line) > maxLen {
+		return line[:maxLen] + "..."
+	}
+	if multiline {
+		return line + " ..."
+	}
+	return line
+}
+
+// exec executes statement of migration, in verbose mode it is printed together with rows affected and timing.
+func (r *runner) exec(tx *gorm.DB, sql string) *gorm.DB {
+	if !r.verbose {
+		return tx.Exec(r.comment + sql)
+	}
+	r.progress.Println(sql)
+	started := time.Now()
+	res := tx.Exec(r.comment + sql)
+	if res.Error != nil {
+		r.progress.Println(fmt.Sprintf("-- failed after %s", time.Since(started).Round(time.Millisecond)))
+		return res
+	}
+	r.progress.Println(fmt.Sprintf("-- %d rows affected in %s", res.RowsAffected, time.Since(started).Round(time.Millisecond)))
+	return res
+}
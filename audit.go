@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Audit configures recording of every run of migrator, not only of applied migrations.
+type Audit struct {
+	// Runs of up, down, redo, squash, import and repair commands are recorded into migration_runs table
+	// of the target database, including no-op and failed ones
+	Table bool `yaml:"table"`
+	// Events of up command are posted to the webhook, i.e. of change-management system
+	Webhook Webhook `yaml:"webhook"`
+}
+
+// migrationRun is a row of migration_runs table.
+type migrationRun struct {
+	ID         int
+	Command    string
+	Status     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Actor      string // who has triggered the run
+	Host       string // where the run was triggered from
+	Args       string
+	Report     string // run report in JSON with statuses of migrations
+}
+
+func (migrationRun) TableName() string {
+	return "migration_runs"
+}
+
+// recordRun inserts audit record of the run by a separate connection,
+// as the main one may be in aborted transaction after failure.
+func recordRun(config Config, r *runReport, report []byte) error {
+	db, err := dial(config)
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	if err := db.AutoMigrate(&migrationRun{}); err != nil {
+		return fmt.Errorf("can't prepare audit table: %w", err)
+	}
+	host, _ := os.Hostname()
+	args := make([]string, len(os.Args)-1)
+	for i, arg := range os.Args[1:] {
+		args[i] = redactURL(arg)
+	}
+	run := migrationRun{
+		Command:    r.Command,
+		Status:     r.Status,
+		Error:      r.Error,
+		StartedAt:  r.StartedAt,
+		FinishedAt: r.FinishedAt,
+		Actor:      actor(),
+		Host:       host,
+		Args:       strings.Join(args, " "),
+		Report:     string(report),
+	}
+	if err := db.Create(&run).Error; err != nil {
+		return fmt.Errorf("can't record run: %w", err)
+	}
+	return nil
+}
+
+// actor returns who has triggered the run: MIGRATOR_ACTOR environment variable, user of CI job or OS user.
+func actor() string {
+	for _, name := range []string{"MIGRATOR_ACTOR", "GITHUB_ACTOR", "GITLAB_USER_LOGIN", "BUILD_USER_ID"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backup modes, schema-only backup is taken by default.
+const (
+	backupSchema = "schema"
+	backupFull   = "full"
+)
+
+// Backup configures pg_dump executed before pending migrations are applied.
+type Backup struct {
+	// Local directory or s3://bucket/prefix, backup is disabled when it is empty
+	Output string `yaml:"output"`
+	Mode   string `yaml:"mode" binding:"omitempty,oneof=schema full"`
+}
+
+// backup dumps configured database into output location and returns location of the dump. Owners and privileges
+// are kept, so the database may be restored as it was. Dump is uploaded to S3 while pg_dump writes it.
+func backup(config Config, now time.Time) (string, error) {
+	var args []string
+	if config.Backup.Mode != backupFull {
+		args = append(args, "--schema-only")
+	}
+	name := fmt.Sprintf("%s-%s.sql", config.Database.Name, now.UTC().Format("20060102T150405Z"))
+
+	if strings.HasPrefix(config.Backup.Output, "s3://") || strings.HasPrefix(config.Backup.Output, "gs://") {
+		s3, err := newS3Source(config.Backup.Output)
+		if err != nil {
+			return "", err
+		}
+		dump, err := pgDumpStream(config, args...)
+		if err != nil {
+			return "", err
+		}
+		defer dump.Close()
+		if err := s3.upload(name, dump); err != nil {
+			return "", fmt.Errorf("can't upload backup: %w", err)
+		}
+		return strings.TrimSuffix(config.Backup.Output, "/") + "/" + name, nil
+	}
+
+	if err := os.MkdirAll(config.Backup.Output, 0o755); err != nil {
+		return "", err
+	}
+	file := filepath.Join(config.Backup.Output, name)
+	if _, err := pgDump(config, append(args, "--file", file)...); err != nil {
+		return "", err
+	}
+	return file, nil
+}
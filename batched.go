@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	batchedDirective = "batched"
+	defaultBatchRows = 1000
+)
+
+// batchRowsPlaceholder is replaced in statements of batched migration by batch size.
+var batchRowsPlaceholder = regexp.MustCompile(`(^|[^:]):rows\b`)
+
+// batchOptions are parameters of "-- migrator:batched rows=10000 sleep=500ms" directive.
+type batchOptions struct {
+	Rows  int
+	Sleep time.Duration
+}
+
+func parseBatchOptions(d directive) (batchOptions, error) {
+	opts := batchOptions{Rows: defaultBatchRows}
+	for key, value := range d.Params() {
+		var err error
+		switch key {
+		case "rows":
+			opts.Rows, err = strconv.Atoi(value)
+			if err == nil && opts.Rows <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "sleep":
+			opts.Sleep, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+		if err != nil {
+			return opts, fmt.Errorf("invalid %q parameter of %s directive at line %d: %w", key, batchedDirective, d.Line, err)
+		}
+	}
+	return opts, nil
+}
+
+// applyBatched executes migration statements in bounded-size loops to avoid long locks and
+// huge transactions. Every statement containing :rows placeholder is executed repeatedly,
+// each time in its own transaction, until it affects no rows. Other statements are executed once.
+// Migration is recorded as applied only after all statements are done, so batched
+// statements must be written the way they can be safely restarted.
+func (r *runner) applyBatched(opts batchOptions, m migrationFile) error {
+	started := time.Now()
+	statements := splitStatements(m.Body)
+	bestEffort := bestEffortStatements(m.Body, statements)
+	restore, err := r.setSession(r.db, m)
+	if err != nil {
+		return err
+	}
+	// Batches are committed one by one, so session settings are restored on failure too
+	defer func() { _ = restore() }()
+	for i := range statements {
+		r.progress.Statement(i+1, len(statements))
+		if !batchRowsPlaceholder.MatchString(statements[i].SQL) {
+			if err := r.exec(r.db, statements[i].SQL).Error; err != nil {
+				if !bestEffort[statements[i].Line] {
+					return fmt.Errorf("can't execute statement at line %d (%s): %w",
+						statements[i].Line, snippet(statements[i].SQL), err)
+				}
+				logrus.WithError(err).Warnf("migration %s: best-effort statement at line %d has failed",
+					m.Path(), statements[i].Line)
+			}
+			continue
+		}
+
+		sql := batchRowsPlaceholder.ReplaceAllString(statements[i].SQL, "${1}"+strconv.Itoa(opts.Rows))
+		var total int64
+		for batch := 1; ; batch++ {
+			res := r.exec(r.db, sql)
+			if res.Error != nil {
+				return fmt.Errorf("can't execute batch %d of statement at line %d: %w", batch, statements[i].Line, res.Error)
+			}
+			total += res.RowsAffected
+			logrus.Debugf("migration %s: batch %d affected %d rows, %d in total", m.Path(), batch, res.RowsAffected, total)
+			if res.RowsAffected == 0 {
+				break
+			}
+			time.Sleep(opts.Sleep)
+		}
+		logrus.Infof("migration %s: statement at line %d affected %d rows", m.Path(), statements[i].Line, total)
+	}
+
+	if err := restore(); err != nil {
+		return err
+	}
+	record := r.record(m)
+	record.DurationMs = time.Since(started).Milliseconds()
+	if err := r.store.Record(r.db, record); err != nil {
+		return fmt.Errorf("can't init migration stat: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings of bodies in tracking table. Compressed bodies are kept base64 encoded in the same text column.
+const (
+	bodyPlain = ""
+	bodyZstd  = "zstd"
+	bodyGzip  = "gzip"
+)
+
+// BodyStorage configures how bodies of applied migrations are kept in tracking table.
+type BodyStorage struct {
+	Compression string `yaml:"compression" binding:"omitempty,oneof=zstd gzip"`
+	// Only name, checksum and metadata are stored, applied body to diff is taken from git history of the file
+	Omit bool `yaml:"omit"`
+}
+
+// bodyStorage is body storage of the run, it is set by initConfig.
+var bodyStorage BodyStorage
+
+// encodeBody returns body of migration to store by encoding of the policy.
+func encodeBody(body, encoding string) (string, error) {
+	if body == "" || encoding == bodyPlain {
+		return body, nil
+	}
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case bodyZstd:
+		zw, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		if err != nil {
+			return "", err
+		}
+		w = zw
+	case bodyGzip:
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	default:
+		return "", fmt.Errorf("unknown body encoding %q", encoding)
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeBody returns body of migration read from tracking table.
+func decodeBody(body, encoding string) (string, error) {
+	if body == "" || encoding == bodyPlain {
+		return body, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("can't decode %s body: %w", encoding, err)
+	}
+	var r io.ReadCloser
+	switch encoding {
+	case bodyZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		r = zr.IOReadCloser()
+	case bodyGzip:
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return "", fmt.Errorf("can't decompress body: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("unknown body encoding %q", encoding)
+	}
+	defer r.Close()
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("can't decompress %s body: %w", encoding, err)
+	}
+	return string(decoded), nil
+}
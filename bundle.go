@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runBundle packages migrations to distribute them separately from the binary.
+func runBundle(args []string) {
+	if len(args) == 0 {
+		logrus.Fatal("bundle subcommand is required, available subcommands: create, push")
+	}
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("bundle create", flag.ExitOnError)
+		dir := flags.String("dir", migrationsDirName, "migrations dir to package")
+		bundleVersion := flags.String("version", version, "version of the bundle")
+		output := flags.String("output", "", "file to write the bundle to (migrations-<version>.tgz by default)")
+		opts := addCommonFlags(flags)
+		_ = flags.Parse(args[1:])
+		opts.Setup()
+		if *output == "" {
+			*output = fmt.Sprintf("migrations-%s.tgz", *bundleVersion)
+		}
+		manifest, err := json.MarshalIndent(bundleManifest{Version: *bundleVersion, CreatedAt: time.Now().UTC(),
+			Migrator: version}, "", "  ")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		bundle, err := createTarGz(*dir, map[string][]byte{bundleManifestFile: manifest})
+		if err != nil {
+			logrus.WithError(err).Fatal("can't package migrations")
+		}
+		if err := os.WriteFile(*output, bundle, 0644); err != nil {
+			logrus.WithError(err).Fatal("can't write migrations bundle")
+		}
+		fmt.Printf("Has written bundle %s of version %s\n", *output, *bundleVersion)
+	case "push":
+		flags := flag.NewFlagSet("bundle push", flag.ExitOnError)
+		dir := flags.String("dir", migrationsDirName, "migrations dir to package")
+		opts := addCommonFlags(flags)
+		_ = flags.Parse(args[1:])
+		opts.Setup()
+		if flags.NArg() != 1 {
+			logrus.Fatal("usage: migrator bundle push [-dir ./migrations] oci://registry/app/migrations:1.4.0")
+		}
+		registry, err := newOCIRegistry(flags.Arg(0))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		layer, err := createTarGz(*dir, nil)
+		if err != nil {
+			logrus.WithError(err).Fatal("can't package migrations")
+		}
+		manifestDigest, err := registry.Push(layer)
+		if err != nil {
+			logrus.WithError(err).Fatal("can't push migrations bundle")
+		}
+		fmt.Printf("Has pushed %s@%s\n", flags.Arg(0), manifestDigest)
+	default:
+		logrus.Fatalf("unknown bundle subcommand %q, available subcommands: create, push", args[0])
+	}
+}
+
+// bundleManifestFile describes bundle written by "bundle create", it is placed into root of the tarball.
+const bundleManifestFile = "bundle.json"
+
+type bundleManifest struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Migrator  string    `json:"migrator"` // version of migrator binary created the bundle
+}
+
+// createTarGz packages files of the directory and extra files into gzipped tarball
+// together with SHA256SUMS file used to verify them on extraction.
+func createTarGz(dir string, extra map[string][]byte) ([]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = os.ReadFile(p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, data := range extra {
+		files[name] = data
+	}
+	delete(files, checksumsFile)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sums bytes.Buffer
+	for _, name := range names {
+		sum := sha256.Sum256(files[name])
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	files[checksumsFile] = sums.Bytes()
+	names = append(names, checksumsFile)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := archive.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := archive.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const defaultCanarySample = 1
+
+// Canary configures budgets pending migrations have to stay within on a sampled clone of the database.
+type Canary struct {
+	// Percent of rows of each table copied into the clone, 1 by default
+	SamplePercent float64 `yaml:"samplePercent" binding:"omitempty,gt=0,lte=100"`
+	// Budgets of durations estimated for full data
+	MaxDuration          time.Duration `yaml:"maxDuration"`
+	MaxMigrationDuration time.Duration `yaml:"maxMigrationDuration"`
+	// Budget of migrations taking locks which block writes or reads of tables
+	MaxLockDuration time.Duration `yaml:"maxLockDuration"`
+}
+
+// foreignKeyConstraint matches statements of dump adding foreign keys, they are added without validation
+// as sampled rows don't have to reference sampled ones.
+var foreignKeyConstraint = regexp.MustCompile(`(?is)^ALTER TABLE .* ADD CONSTRAINT .* FOREIGN KEY .*`)
+
+// canaryRun clones schema and sampled data of the target database into a temporary database, times pending
+// migrations there and fails if durations estimated for full data exceed budgets. Estimate is the canary duration
+// scaled by the sample, so it is rough for migrations not proportional to table sizes.
+func canaryRun(db *gorm.DB, config Config, pgVersion int, pending []migrationFile) (err error) {
+	c := config.Canary
+	if c.SamplePercent <= 0 {
+		c.SamplePercent = defaultCanarySample
+	}
+	dumps := make(map[string][]byte)
+	for _, section := range []string{"pre-data", "post-data"} {
+		if dumps[section], err = pgDump(config, "--schema-only", "--no-owner", "--no-privileges", "--section", section); err != nil {
+			return fmt.Errorf("can't dump schema: %w", err)
+		}
+	}
+	tracking, err := pgDump(config, "--data-only", "--inserts", "--table", "migrations", "--table", "migrations_id_seq")
+	if err != nil {
+		return fmt.Errorf("can't dump tracking table: %w", err)
+	}
+
+	canary, drop, err := cloneDatabase(db, config, "canary")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if dropErr := drop(); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+	if err := restoreDump(canary, dumps["pre-data"]); err != nil {
+		return err
+	}
+	if err := restoreDump(canary, tracking); err != nil {
+		return err
+	}
+	if err := copySample(db, canary, c.SamplePercent); err != nil {
+		return err
+	}
+	for _, stmt := range splitStatements(cleanDump(string(dumps["post-data"]))) {
+		query := stmt.SQL
+		if foreignKeyConstraint.MatchString(query) {
+			query += " NOT VALID"
+		}
+		if err := canary.Exec(query).Error; err != nil {
+			return fmt.Errorf("can't clone schema at line %d of dump: %w", stmt.Line, err)
+		}
+	}
+	logrus.Infof("canary database is filled by %g%% of rows", c.SamplePercent)
+
+	r := &runner{
+		db:       canary,
+		store:    &tableStore{db: canary}, // tracking table is cloned into canary database
+		progress: newProgress(os.Stdout, len(pending), true),
+		version:  pgVersion,
+		skipList: newSkipList(config.Skip),
+		env:      config.Environment,
+		schema:   config.Schema,
+	}
+	scale := 100 / c.SamplePercent
+	var total time.Duration
+	var exceeded []string
+	for _, m := range pending {
+		if m, err = m.loaded(); err != nil {
+			return err
+		}
+		started := time.Now()
+		if _, err := r.Run(m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Path(), err)
+		}
+		took := time.Since(started)
+		estimate := time.Duration(float64(took) * scale)
+		total += estimate
+		logrus.Infof("canary: %s took %s, estimated %s", m.Path(), took.Round(time.Millisecond), estimate.Round(time.Millisecond))
+		if c.MaxMigrationDuration > 0 && estimate > c.MaxMigrationDuration {
+			exceeded = append(exceeded, fmt.Sprintf("%s is estimated to take %s, budget is %s",
+				m.Path(), estimate.Round(time.Second), c.MaxMigrationDuration))
+		}
+		if c.MaxLockDuration > 0 && estimate > c.MaxLockDuration && blocksTables(m) {
+			exceeded = append(exceeded, fmt.Sprintf("%s is estimated to block tables for %s, budget is %s",
+				m.Path(), estimate.Round(time.Second), c.MaxLockDuration))
+		}
+	}
+	if c.MaxDuration > 0 && total > c.MaxDuration {
+		exceeded = append(exceeded, fmt.Sprintf("the run is estimated to take %s, budget is %s", total.Round(time.Second), c.MaxDuration))
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("canary has exceeded budgets: %s", strings.Join(exceeded, "; "))
+	}
+	return nil
+}
+
+// blocksTables reports whether migration takes locks blocking writes or reads of tables for its duration.
+func blocksTables(m migrationFile) bool {
+	for _, l := range migrationLocks(m) {
+		if l.Mode != lockShareUpdateExclusive {
+			return true
+		}
+	}
+	return false
+}
+
+// copySample copies the percent of rows of each user table of the source database into the same table of target.
+// Triggers of target are fired as usual, foreign keys are not checked as they are added later.
+func copySample(source, target *gorm.DB, percent float64) error {
+	// Partitions are copied instead of partitioned tables, generated columns are computed by target
+	var tables []struct {
+		Name    string
+		Columns string
+	}
+	err := source.Raw("SELECT format('%I.%I', n.nspname, c.relname) AS name, " +
+		"(SELECT coalesce(string_agg(quote_ident(column_name), ', ' ORDER BY ordinal_position), '') FROM information_schema.columns " +
+		"WHERE table_schema = n.nspname AND table_name = c.relname AND is_generated = 'NEVER') AS columns " +
+		"FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace " +
+		"WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\\_toast%' " +
+		"AND NOT (n.nspname = current_schema() AND c.relname = 'migrations') ORDER BY 1").Scan(&tables).Error
+	if err != nil {
+		return fmt.Errorf("can't list tables: %w", err)
+	}
+	ctx := context.Background()
+	from, err := rawConn(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := rawConn(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+	for _, t := range tables {
+		if t.Columns == "" {
+			continue
+		}
+		if err := copyTable(ctx, from, to, t.Name, t.Columns, percent); err != nil {
+			return fmt.Errorf("can't copy sample of %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// copyTable streams sampled rows of the table from one connection into another by COPY protocol.
+func copyTable(ctx context.Context, from, to *sql.Conn, table, columns string, percent float64) error {
+	return from.Raw(func(fromConn any) error {
+		return to.Raw(func(toConn any) error {
+			src := fromConn.(*stdlib.Conn).Conn().PgConn()
+			dst := toConn.(*stdlib.Conn).Conn().PgConn()
+			pr, pw := io.Pipe()
+			done := make(chan error, 1)
+			go func() {
+				_, err := src.CopyTo(ctx, pw, fmt.Sprintf("COPY (SELECT %s FROM ONLY %s TABLESAMPLE BERNOULLI (%g)) TO STDOUT", columns, table, percent))
+				pw.CloseWithError(err)
+				done <- err
+			}()
+			_, err := dst.CopyFrom(ctx, pr, fmt.Sprintf("COPY %s (%s) FROM STDIN", table, columns))
+			pr.CloseWithError(err)
+			if copyErr := <-done; err == nil {
+				err = copyErr
+			}
+			return err
+		})
+	})
+}
+
+// rawConn returns dedicated connection of the pool of db.
+func rawConn(ctx context.Context, db *gorm.DB) (*sql.Conn, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return sqlDB.Conn(ctx)
+}
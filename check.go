@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runCheck verifies applied migrations against files and exits with exitPending code if there are pending ones,
+// so deploy pipelines may block rollout of application until migrations are applied. Changed or removed
+// applied migrations fail it as any other error.
+func runCheck(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	o := upOptions{Tracks: allTracks, Check: true}
+	track := flags.String("track", "", "check migrations of the only track: schema or data (all tracks by default)")
+	flags.StringVar(&o.From, "from", "", "read migrations only from the given source")
+	o.cliOptions = addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if *track != "" {
+		if *track != trackSchema && *track != trackData {
+			logrus.Fatalf("unknown track %q, available values: %s, %s", *track, trackSchema, trackData)
+		}
+		o.Tracks = []string{*track}
+	}
+
+	path := configPath()
+	config := initConfig(path)
+	o.Setup()
+	if multiTarget(config) {
+		runTargets(path, config, "check", targetArgs(flags))
+		return
+	}
+	up(config, o)
+}
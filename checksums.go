@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Checksum algorithms of migration bodies. Identifier of the scheme checksum is computed by is stored
+// in each tracking row, rows of different schemes are verified by recomputing checksum of file the same way.
+const (
+	checksumSHA256 = "sha256"
+	checksumXXHash = "xxhash" // xxh64, much faster and enough to detect accidental edits
+	// normalizedSuffix marks scheme of checksums computed after normalization of body, i.e. "sha256+normalized"
+	normalizedSuffix = "+normalized"
+)
+
+// ChecksumConfig configures how checksums of migration bodies are computed.
+type ChecksumConfig struct {
+	Algorithm string `yaml:"algorithm" binding:"omitempty,oneof=sha256 xxhash"`
+	// Body is normalized by configured normalization before hashing, so normalization-only changes keep checksum
+	Normalize bool `yaml:"normalize"`
+}
+
+// checksummer computes checksums of migration bodies by scheme like "xxhash+normalized".
+// Zero value computes sha256 of raw body.
+type checksummer struct {
+	scheme        string
+	normalization Normalization
+}
+
+func newChecksummer(c ChecksumConfig, n Normalization) checksummer {
+	scheme := c.Algorithm
+	if scheme == "" {
+		scheme = checksumSHA256
+	}
+	if c.Normalize {
+		scheme += normalizedSuffix
+	}
+	return checksummer{scheme: scheme, normalization: n}
+}
+
+// As returns checksummer of the scheme, empty scheme of rows recorded by older versions is sha256 of raw body.
+func (c checksummer) As(scheme string) checksummer {
+	return checksummer{scheme: scheme, normalization: c.normalization}
+}
+
+// Scheme returns identifier of the scheme stored together with checksums.
+func (c checksummer) Scheme() string {
+	if c.scheme == "" {
+		return checksumSHA256
+	}
+	return c.scheme
+}
+
+// Normalized reports whether body is normalized before hashing.
+func (c checksummer) Normalized() bool {
+	return strings.HasSuffix(c.scheme, normalizedSuffix)
+}
+
+// New returns hash of the scheme algorithm.
+func (c checksummer) New() (hash.Hash, error) {
+	switch algorithm := strings.TrimSuffix(c.Scheme(), normalizedSuffix); algorithm {
+	case checksumSHA256:
+		return sha256.New(), nil
+	case checksumXXHash:
+		return xxhash.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %q", algorithm)
+	}
+}
+
+// Sum returns hex encoded checksum of body.
+func (c checksummer) Sum(body string) (string, error) {
+	h, err := c.New()
+	if err != nil {
+		return "", err
+	}
+	if c.Normalized() {
+		body = c.normalization.Apply(body)
+	}
+	_, _ = io.WriteString(h, body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Writer returns writer hashing streamed body the same way as Sum does and function returning its checksum
+// after the whole body has been written.
+func (c checksummer) Writer() (io.Writer, func() (string, error), error) {
+	h, err := c.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.Normalized() {
+		return h, func() (string, error) { return hex.EncodeToString(h.Sum(nil)), nil }, nil
+	}
+	w := c.normalization.Writer(h)
+	return w, func() (string, error) {
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cliOptions are flags common for all commands.
+type cliOptions struct {
+	Color   string
+	Quiet   bool
+	Verbose bool
+	// Checksum mismatches are resolved on terminal instead of failing
+	Interactive bool
+	// Format of annotations of problems in migration files, i.e. github
+	Annotations string
+}
+
+// addCommonFlags registers common flags in the command flag set.
+func addCommonFlags(flags *flag.FlagSet) *cliOptions {
+	opts := &cliOptions{}
+	flags.StringVar(&opts.Color, "color", "auto", "colorize output: auto, always or never")
+	flags.BoolVar(&opts.Quiet, "quiet", false, "print only errors and a single summary line")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "print executed statements with rows affected and timing")
+	flags.BoolVar(&opts.Interactive, "interactive", false, "ask on terminal what to do with changed applied migrations instead of failing")
+	flags.StringVar(&opts.Annotations, "annotations", "", "also print problems in migration files as annotations: github")
+	return opts
+}
+
+// Setup configures output according to parsed flags, it overrides log level of config.
+func (o *cliOptions) Setup() {
+	colors := o.colors()
+	logrus.SetFormatter(&logrus.TextFormatter{ForceColors: colors, DisableColors: !colors})
+	if o.Quiet {
+		logrus.SetLevel(logrus.ErrorLevel)
+	}
+	if o.Annotations != "" && o.Annotations != annotationsGitHub {
+		logrus.Fatalf("invalid annotations format %q, available values: %s", o.Annotations, annotationsGitHub)
+	}
+	annotations = o.Annotations
+	interactive = o.Interactive
+	installLogHandler()
+}
+
+// colors reports whether output should be colorized. In auto mode colors are disabled
+// by not empty NO_COLOR environment variable and when stderr is not a terminal.
+func (o *cliOptions) colors() bool {
+	switch o.Color {
+	case "always":
+		return true
+	case "never":
+		return false
+	case "auto":
+	default:
+		logrus.Fatalf("invalid color mode %q, available values: auto, always, never", o.Color)
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(os.Stderr)
+}
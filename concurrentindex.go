@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// concurrentIndexDirective marks migration creating indexes concurrently, i.e. "-- migrator:concurrent-index retries=3".
+	// Its statements are executed outside of transaction, failed CREATE INDEX CONCURRENTLY leaves INVALID index,
+	// which is dropped before the statement is retried.
+	concurrentIndexDirective = "concurrent-index"
+	defaultIndexRetries      = 2
+	indexProgressInterval    = 10 * time.Second
+)
+
+// createIndexConcurrently matches canonical form of CREATE INDEX CONCURRENTLY statement capturing index name.
+var createIndexConcurrently = regexp.MustCompile(`(?i)^CREATE (?:UNIQUE )?INDEX CONCURRENTLY (?:IF NOT EXISTS )?("[^"]+"|[\w$]+) ON\b`)
+
+func parseIndexRetries(d directive) (int, error) {
+	retries := defaultIndexRetries
+	for key, value := range d.Params() {
+		var err error
+		switch key {
+		case "retries":
+			retries, err = strconv.Atoi(value)
+			if err == nil && retries < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid %q parameter of %s directive at line %d: %w", key, concurrentIndexDirective, d.Line, err)
+		}
+	}
+	return retries, nil
+}
+
+// applyConcurrentIndex executes statements of migration one by one outside of transaction.
+// Migration is recorded as applied only after all statements are done, so it must be written the way
+// it can be safely restarted, i.e. with IF NOT EXISTS.
+func (r *runner) applyConcurrentIndex(retries int, m migrationFile) error {
+	started := time.Now()
+	statements := splitStatements(m.Body)
+	bestEffort := bestEffortStatements(m.Body, statements)
+	restore, err := r.setSession(r.db, m)
+	if err != nil {
+		return err
+	}
+	// Statements are committed one by one, so session settings are restored on failure too
+	defer func() { _ = restore() }()
+	for i, stmt := range statements {
+		r.progress.Statement(i+1, len(statements))
+		if match := createIndexConcurrently.FindStringSubmatch(canonicalSQL(stmt.SQL)); match != nil {
+			if err := r.createIndex(m, stmt, strings.Trim(match[1], `"`), retries); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.exec(r.db, stmt.SQL).Error; err != nil {
+			if !bestEffort[stmt.Line] {
+				return fmt.Errorf("can't execute statement at line %d (%s): %w", stmt.Line, snippet(stmt.SQL), err)
+			}
+			logrus.WithError(err).Warnf("migration %s: best-effort statement at line %d has failed", m.Path(), stmt.Line)
+		}
+	}
+
+	if err := restore(); err != nil {
+		return err
+	}
+	record := r.record(m)
+	record.DurationMs = time.Since(started).Milliseconds()
+	if err := r.store.Record(r.db, record); err != nil {
+		return fmt.Errorf("can't init migration stat: %w", err)
+	}
+	return nil
+}
+
+// createIndex executes CREATE INDEX CONCURRENTLY statement reporting its progress,
+// on failure INVALID index left by it is dropped and the statement is retried.
+func (r *runner) createIndex(m migrationFile, stmt statement, index string, retries int) error {
+	var pid int
+	if err := r.db.Raw("SELECT pg_backend_pid()").Scan(&pid).Error; err != nil {
+		return fmt.Errorf("can't get backend pid: %w", err)
+	}
+	for attempt := 1; ; attempt++ {
+		done := make(chan struct{})
+		if r.dial != nil {
+			go watchIndexProgress(r.dial, pid, m.Path()+": index "+index, done)
+		}
+		err := r.exec(r.db, stmt.SQL).Error
+		close(done)
+		if err == nil {
+			return nil
+		}
+		if dropErr := dropInvalidIndex(r.db, index); dropErr != nil {
+			return fmt.Errorf("can't execute statement at line %d (%s): %w, invalid index is left: %v",
+				stmt.Line, snippet(stmt.SQL), err, dropErr)
+		}
+		if attempt > retries {
+			return fmt.Errorf("can't execute statement at line %d (%s) in %d attempts: %w", stmt.Line, snippet(stmt.SQL), attempt, err)
+		}
+		logrus.WithError(err).Warnf("migration %s: creation of index %s has failed, retrying (%d of %d)",
+			m.Path(), index, attempt, retries)
+	}
+}
+
+// dropInvalidIndex drops index left INVALID by failed concurrent creation, valid index is kept.
+func dropInvalidIndex(db *gorm.DB, index string) error {
+	var invalid bool
+	err := db.Raw("SELECT EXISTS (SELECT FROM pg_index WHERE indexrelid = to_regclass(?) AND NOT indisvalid)",
+		index).Scan(&invalid).Error
+	if err != nil || !invalid {
+		return err
+	}
+	logrus.Warnf("dropping invalid index %s left by failed creation", index)
+	return db.Exec(fmt.Sprintf("DROP INDEX CONCURRENTLY IF EXISTS %q", index)).Error
+}
+
+// watchIndexProgress logs progress of index creation by the backend until done is closed.
+// Progress is read by separate connection, as the main one is busy with the statement.
+func watchIndexProgress(dial func() (*gorm.DB, error), pid int, what string, done <-chan struct{}) {
+	ticker := time.NewTicker(indexProgressInterval)
+	defer ticker.Stop()
+	var db *gorm.DB
+	for {
+		select {
+		case <-done:
+			if db != nil {
+				if sqlDB, err := db.DB(); err == nil {
+					_ = sqlDB.Close()
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+		if db == nil {
+			var err error
+			if db, err = dial(); err != nil {
+				logrus.WithError(err).Debug("can't connect to watch progress of index creation")
+				return
+			}
+		}
+		var p struct {
+			Phase       string
+			BlocksDone  int64
+			BlocksTotal int64
+		}
+		err := db.Raw("SELECT phase, blocks_done, blocks_total FROM pg_stat_progress_create_index WHERE pid = ?", pid).
+			Scan(&p).Error
+		if err != nil || p.Phase == "" {
+			continue
+		}
+		if p.BlocksTotal > 0 {
+			logrus.Infof("migration %s is %s, %d%% of blocks", what, p.Phase, p.BlocksDone*100/p.BlocksTotal)
+		} else {
+			logrus.Infof("migration %s is %s", what, p.Phase)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Directives restricting versions of postgres server migration is applicable to,
+// i.e. "-- migrator:minPostgres 15". Migration fails with clear error against not matching server,
+// unless "skip" is given after version: then it is recorded as skipped without execution.
+const (
+	minPostgresDirective = "minPostgres"
+	maxPostgresDirective = "maxPostgres"
+)
+
+// environmentsDirective restricts environments migration is executed in, i.e. "-- migrator:environments dev,staging".
+// In other environments migration is recorded as skipped, so sequences of migrations stay aligned.
+const environmentsDirective = "environments"
+
+// checkEnvironment returns reason to skip migration if environments directive doesn't list the current one.
+func checkEnvironment(m migrationFile, environment string) (string, error) {
+	d, ok := findDirective(parseDirectives(m.Body), environmentsDirective)
+	if !ok {
+		return "", nil
+	}
+	if environment == "" {
+		return "", fmt.Errorf("%s directive at line %d requires environment to be set in config", d.Name, d.Line)
+	}
+	listed := strings.FieldsFunc(d.Args, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(listed) == 0 {
+		return "", fmt.Errorf("invalid %s directive at line %d, expected: %s <name>[,<name>...]", d.Name, d.Line, d.Name)
+	}
+	for _, name := range listed {
+		if name == environment {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("environment %s is not listed in %s %s", environment, d.Name, d.Args), nil
+}
+
+// serverVersion returns version of connected server in server_version_num format, i.e. 150004 for 15.4.
+func serverVersion(db *gorm.DB) (int, error) {
+	var num string
+	if err := db.Raw("SHOW server_version_num").Scan(&num).Error; err != nil {
+		return 0, fmt.Errorf("can't get server version: %w", err)
+	}
+	return strconv.Atoi(num)
+}
+
+// checkMinServerVersion refuses the whole run against server older than configured one,
+// instead of failing midway on syntax it doesn't support.
+func checkMinServerVersion(config Config, version int) error {
+	if config.Database.MinServerVersion == "" {
+		return nil
+	}
+	low, _, err := parsePostgresVersion(config.Database.MinServerVersion)
+	if err != nil {
+		return fmt.Errorf("invalid database.minServerVersion: %w", err)
+	}
+	if version < low {
+		return fmt.Errorf("server version %s is older than %s required by database.minServerVersion, migrations are not applied",
+			formatPostgresVersion(version), config.Database.MinServerVersion)
+	}
+	return nil
+}
+
+// checkServerVersion returns reason to skip migration if its version directives don't match the server.
+func checkServerVersion(m migrationFile, version int) (string, error) {
+	for _, d := range parseDirectives(m.Body) {
+		if d.Name != minPostgresDirective && d.Name != maxPostgresDirective {
+			continue
+		}
+		args := strings.Fields(d.Args)
+		if len(args) == 0 || len(args) > 2 || len(args) == 2 && args[1] != "skip" {
+			return "", fmt.Errorf("invalid %s directive at line %d, expected: %s <version> [skip]", d.Name, d.Line, d.Name)
+		}
+		low, high, err := parsePostgresVersion(args[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid %s directive at line %d: %w", d.Name, d.Line, err)
+		}
+		matches := version >= low
+		if d.Name == maxPostgresDirective {
+			matches = version <= high
+		}
+		if matches {
+			continue
+		}
+		reason := fmt.Sprintf("server version %s doesn't satisfy %s %s", formatPostgresVersion(version), d.Name, args[0])
+		if len(args) == 1 {
+			return "", fmt.Errorf("%s", reason)
+		}
+		return reason, nil
+	}
+	return "", nil
+}
+
+// parsePostgresVersion returns range of server_version_num values matching version like "15", "15.4" or "9.6".
+func parsePostgresVersion(s string) (int, int, error) {
+	parts := strings.Split(s, ".")
+	nums := make([]int, len(parts))
+	for i := range parts {
+		var err error
+		if nums[i], err = strconv.Atoi(parts[i]); err != nil || nums[i] < 0 || len(parts) > 3 {
+			return 0, 0, fmt.Errorf("invalid version %q", s)
+		}
+	}
+	// Before 10 the second number was a part of major version and the third one was minor
+	base, scale := nums[0]*10000, []int{10000, 1}
+	if nums[0] < 10 {
+		scale = []int{10000, 100, 1}
+	}
+	if len(nums) > len(scale) {
+		return 0, 0, fmt.Errorf("invalid version %q", s)
+	}
+	for i := 1; i < len(nums); i++ {
+		base += nums[i] * scale[i]
+	}
+	return base, base + scale[len(nums)-1] - 1, nil
+}
+
+func formatPostgresVersion(num int) string {
+	if num < 100000 {
+		return fmt.Sprintf("%d.%d.%d", num/10000, num/100%100, num%100)
+	}
+	return fmt.Sprintf("%d.%d", num/10000, num%10000)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParsePostgresVersion(t *testing.T) {
+	tests := []struct {
+		version   string
+		low, high int
+		wantErr   bool
+	}{
+		{version: "15", low: 150000, high: 159999},
+		{version: "15.4", low: 150004, high: 150004},
+		{version: "10.0", low: 100000, high: 100000},
+		{version: "9", low: 90000, high: 99999},
+		{version: "9.6", low: 90600, high: 90699},
+		{version: "9.6.24", low: 90624, high: 90624},
+		{version: "15.4.1", wantErr: true},
+		{version: "9.6.1.2", wantErr: true},
+		{version: "15.-1", wantErr: true},
+		{version: "15.x", wantErr: true},
+		{version: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		low, high, err := parsePostgresVersion(tt.version)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePostgresVersion(%q) = %d, %d, want error", tt.version, low, high)
+			}
+			continue
+		}
+		if err != nil || low != tt.low || high != tt.high {
+			t.Errorf("parsePostgresVersion(%q) = %d, %d, %v, want %d, %d", tt.version, low, high, err, tt.low, tt.high)
+		}
+	}
+}
+
+func TestFormatPostgresVersion(t *testing.T) {
+	for num, want := range map[int]string{150004: "15.4", 100000: "10.0", 90624: "9.6.24", 90600: "9.6.0"} {
+		if got := formatPostgresVersion(num); got != want {
+			t.Errorf("formatPostgresVersion(%d) = %q, want %q", num, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Encrypted config files are decrypted by sops and age utilities, so credentials may be kept in the repository.
+// Key of age is taken from MIGRATOR_AGE_KEY_FILE or MIGRATOR_AGE_KEY environment variables,
+// sops uses its own settings like SOPS_AGE_KEY_FILE or cloud KMS credentials.
+const (
+	ageKeyFileEnv = "MIGRATOR_AGE_KEY_FILE"
+	ageKeyEnv     = "MIGRATOR_AGE_KEY"
+)
+
+var (
+	// sopsMetadata matches top-level metadata section sops adds to encrypted YAML
+	sopsMetadata = regexp.MustCompile(`(?m)^sops:\s*$`)
+	ageHeaders   = [][]byte{[]byte("age-encryption.org/v1\n"), []byte("-----BEGIN AGE ENCRYPTED FILE-----")}
+)
+
+// decryptConfig returns config file content decrypted if it is encrypted by sops or age, plain file is returned as is.
+func decryptConfig(path string, data []byte) ([]byte, error) {
+	for _, header := range ageHeaders {
+		if bytes.HasPrefix(data, header) {
+			return decryptAge(data)
+		}
+	}
+	if sopsMetadata.Match(data) {
+		return runDecryption(nil, "sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", path)
+	}
+	return data, nil
+}
+
+func decryptAge(data []byte) ([]byte, error) {
+	keyFile := os.Getenv(ageKeyFileEnv)
+	if keyFile == "" {
+		key := os.Getenv(ageKeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("config is encrypted by age, key must be given by %s or %s", ageKeyFileEnv, ageKeyEnv)
+		}
+		dir, err := os.MkdirTemp("", "migrator-age-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		keyFile = filepath.Join(dir, "key.txt")
+		if err := os.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return runDecryption(data, "age", "--decrypt", "--identity", keyFile)
+}
+
+// runDecryption runs decryption utility with input given by stdin and returns its output.
+func runDecryption(input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt config by %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// coordinatedTarget is one of databases changed together by coordinated run.
+type coordinatedTarget struct {
+	config  Config
+	db      *gorm.DB
+	store   VersionStore
+	tx      *gorm.DB
+	version int
+	pending []migrationFile
+}
+
+// runCoordinate applies pending migrations to several databases as one logical change, i.e. paired migrations
+// of main and reporting databases. Migrations are applied to all databases in their transactions first,
+// transactions are committed only if all of them have succeeded. When servers allow prepared transactions
+// they are committed by two-phase commit, so failure of the last commit leaves nothing half applied.
+func runCoordinate(args []string) {
+	flags := flag.NewFlagSet("coordinate", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		logrus.Fatal("configs of other databases must be given as arguments, i.e. migrator coordinate reporting.yaml")
+	}
+
+	configs := []Config{initConfig(configPath())}
+	for _, path := range flags.Args() {
+		config, err := loadConfig(path)
+		if err != nil {
+			logrus.WithError(err).WithField("path", path).Fatal("invalid config")
+		}
+		configs = append(configs, config)
+	}
+	opts.Setup()
+	coordinate(configs, opts)
+}
+
+// coordinate applies pending migrations of all configured databases or none of them.
+func coordinate(configs []Config, opts *cliOptions) {
+	started := time.Now()
+	targets := make([]*coordinatedTarget, len(configs))
+	seen := make(map[string]bool)
+	total := 0
+	for i, config := range configs {
+		if seen[config.Target()] {
+			logrus.Fatalf("database %s is given twice", config.Target())
+		}
+		seen[config.Target()] = true
+		t := &coordinatedTarget{config: config, db: connectDB(config)}
+		var err error
+		if t.version, err = serverVersion(t.db); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := checkMinServerVersion(config, t.version); err != nil {
+			logrus.Fatal(err)
+		}
+		t.store = newVersionStore(t.db)
+		if _, err := t.store.Lock(true); err != nil {
+			logrus.Fatal(err)
+		}
+		defer unlock(t.store)
+		l := newLoader(config, "")
+		t.pending = readPending(t.db, t.store, l, config, allTracks)
+		if err := rewriteSquashedApplied(t.db, t.store, l, config, allTracks); err != nil {
+			logrus.WithError(err).Fatalf("%s: can't rewrite squashed migrations", config.Target())
+		}
+		all, err := t.store.List()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := checkRequirements(all, t.pending); err != nil {
+			logrus.Fatalf("%s: %s", config.Target(), err)
+		}
+		for _, m := range t.pending {
+			if m, err = m.loaded(); err != nil {
+				logrus.Fatal(err)
+			}
+			// Such migrations commit by themselves, so they can't be applied as a part of the transaction
+			for _, name := range []string{concurrentIndexDirective, batchedDirective} {
+				if _, ok := findDirective(parseDirectives(m.Body), name); ok {
+					logrus.Fatalf("%s: migration %s with %s directive can't be applied by coordinated run",
+						config.Target(), m.Path(), name)
+				}
+			}
+		}
+		total += len(t.pending)
+		targets[i] = t
+	}
+	if total == 0 {
+		fmt.Println("Found no one new migration, all databases are up to date.")
+		return
+	}
+
+	twoPhase := true
+	for _, t := range targets {
+		var maxPrepared int
+		if err := t.db.Raw("SELECT current_setting('max_prepared_transactions')::int").Scan(&maxPrepared).Error; err != nil {
+			logrus.Fatalf("%s: can't check prepared transactions: %s", t.config.Target(), err)
+		}
+		if maxPrepared == 0 {
+			logrus.Warnf("%s doesn't allow prepared transactions (max_prepared_transactions is 0), "+
+				"databases are committed one after another", t.config.Target())
+			twoPhase = false
+		}
+	}
+
+	// Apply phase: all migrations are executed, nothing is committed
+	rollback := func() {
+		for _, t := range targets {
+			if t.tx != nil {
+				t.tx.Rollback()
+			}
+		}
+	}
+	p := newProgress(os.Stdout, total, opts.Quiet)
+	for _, t := range targets {
+		if t.tx = t.db.Begin(); t.tx.Error != nil {
+			rollback()
+			logrus.WithError(t.tx.Error).Fatalf("%s: can't begin transaction", t.config.Target())
+		}
+		r := &runner{
+			db:       t.tx,
+			store:    t.store,
+			progress: p,
+			verbose:  opts.Verbose || logrus.IsLevelEnabled(logrus.DebugLevel),
+			version:  t.version,
+			skipList: newSkipList(t.config.Skip),
+			env:      t.config.Environment,
+			schema:   t.config.Schema,
+			vcs:      newVCSInfo(t.config, ""),
+		}
+		for _, m := range t.pending {
+			p.Start(t.config.Database.Name + ": " + m.Path())
+			m, err := m.loaded()
+			if err != nil {
+				p.Fail()
+				rollback()
+				logrus.Fatal(err)
+			}
+			reason, err := r.Run(m)
+			if err != nil {
+				p.Fail()
+				rollback()
+				logrus.WithError(err).Fatalf("can't apply migration %s to %s, no database is changed", m.Path(), t.config.Target())
+			}
+			if reason != "" {
+				p.Skip(reason)
+				continue
+			}
+			p.Done()
+		}
+	}
+
+	// Verify phase: all databases are checked and prepared to commit
+	gid := fmt.Sprintf("migrator-%d", started.UnixNano())
+	for i, t := range targets {
+		err := verifyPermissions(t.tx, t.config.Permissions)
+		if err == nil && twoPhase {
+			err = t.tx.Exec(fmt.Sprintf("PREPARE TRANSACTION '%s'", gid)).Error
+			// Prepared transaction is detached from the session, COMMIT only releases connection of the pool
+			t.tx.Commit()
+			t.tx = nil
+		}
+		if err != nil {
+			rollback()
+			if twoPhase {
+				for _, prepared := range targets[:i] {
+					if err := prepared.db.Exec(fmt.Sprintf("ROLLBACK PREPARED '%s'", gid)).Error; err != nil {
+						logrus.WithError(err).Errorf("%s: can't roll back prepared transaction %s, do it by ROLLBACK PREPARED",
+							prepared.config.Target(), gid)
+					}
+				}
+			}
+			logrus.WithError(err).Fatalf("%s has failed verification, no database is changed", t.config.Target())
+		}
+	}
+
+	// Commit phase
+	for i, t := range targets {
+		var err error
+		if twoPhase {
+			err = t.db.Exec(fmt.Sprintf("COMMIT PREPARED '%s'", gid)).Error
+		} else {
+			err = t.tx.Commit().Error
+		}
+		if err == nil {
+			t.tx = nil
+			continue
+		}
+		committed := make([]string, i)
+		for j := range targets[:i] {
+			committed[j] = targets[j].config.Target()
+		}
+		rest := make([]string, 0, len(targets)-i)
+		for _, other := range targets[i:] {
+			rest = append(rest, other.config.Target())
+		}
+		if twoPhase {
+			logrus.WithError(err).Fatalf("can't commit %s, transactions %s are prepared on %s: finish them by COMMIT PREPARED '%s'",
+				t.config.Target(), gid, strings.Join(rest, ", "), gid)
+		}
+		rollback()
+		logrus.WithError(err).Fatalf("can't commit %s, databases are left in inconsistent versions: %s are committed, "+
+			"%s are not, apply their pending migrations by up", t.config.Target(), strings.Join(committed, ", "), strings.Join(rest, ", "))
+	}
+
+	if opts.Quiet {
+		fmt.Printf("Has applied %d migrations to %d databases in %.1fs\n", total, len(targets), time.Since(started).Seconds())
+		return
+	}
+	for _, t := range targets {
+		fmt.Printf("Has applied migrations to %s:\n", t.config.Target())
+		for _, m := range t.pending {
+			fmt.Println(" - ", m.Path())
+		}
+	}
+}
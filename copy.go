@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// copyDirective loads CSV file into the table by COPY protocol at its place in migration,
+// i.e. "-- migrator:copy table=countries file=data/countries.csv header=true".
+const copyDirective = "copy"
+
+// copyOptions are parameters of copy directive.
+type copyOptions struct {
+	Table   string
+	File    string // path relative to root of the source, the same as of includes
+	Columns string // comma separated columns of CSV, all columns of the table by default
+	Header  bool   // the first line of CSV is header
+}
+
+func parseCopyOptions(d directive) (copyOptions, error) {
+	params := d.Params()
+	o := copyOptions{Table: params["table"], File: params["file"], Columns: params["columns"]}
+	if o.Table == "" || o.File == "" {
+		return o, fmt.Errorf("invalid %s directive at line %d, expected: %s table=<name> file=<path.csv> [columns=a,b] [header=true]",
+			d.Name, d.Line, d.Name)
+	}
+	name := path.Clean(o.File)
+	if !fs.ValidPath(name) {
+		return o, fmt.Errorf("invalid copy file path %q at line %d", o.File, d.Line)
+	}
+	o.File = name
+	switch params["header"] {
+	case "", "false":
+	case "true":
+		o.Header = true
+	default:
+		return o, fmt.Errorf("invalid header value %q of %s directive at line %d, expected true or false", params["header"], d.Name, d.Line)
+	}
+	return o, nil
+}
+
+// Statement returns COPY statement loading CSV into the table.
+func (o copyOptions) Statement() string {
+	target := o.Table
+	if o.Columns != "" {
+		target += " (" + strings.ReplaceAll(o.Columns, ",", ", ") + ")"
+	}
+	return fmt.Sprintf("COPY %s FROM STDIN WITH (FORMAT csv, HEADER %t)", target, o.Header)
+}
+
+// readCopies reads CSV files of copy directives the same way as included fragments and appends their sha256
+// to directive lines, so changes of data are detected by checksum of migration body.
+func (l *loader) readCopies(src source, m *migrationFile) error {
+	if !strings.Contains(m.Body, directivePrefix+copyDirective) {
+		return nil
+	}
+	lines := strings.SplitAfter(m.Body, "\n")
+	for _, d := range parseDirectives(m.Body) {
+		if d.Name != copyDirective {
+			continue
+		}
+		o, err := parseCopyOptions(d)
+		if err != nil {
+			return err
+		}
+		_, data, err := l.readFragment(src, o.File)
+		if err != nil {
+			return fmt.Errorf("can't read copy file %s at line %d: %w", o.File, d.Line, err)
+		}
+		if m.copies == nil {
+			m.copies = make(map[string][]byte)
+		}
+		m.copies[o.File] = []byte(data)
+		sum := sha256.Sum256([]byte(data))
+		line := strings.TrimRight(lines[d.Line-1], "\r\n")
+		lines[d.Line-1] = line + " sha256=" + hex.EncodeToString(sum[:]) + strings.TrimPrefix(lines[d.Line-1], line)
+	}
+	m.Body = strings.Join(lines, "")
+	return nil
+}
+
+// applyWithCopies applies migration with copy directives in a transaction on dedicated connection,
+// as COPY protocol is available only on the raw connection.
+func (r *runner) applyWithCopies(m migrationFile) error {
+	if _, ok := r.db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return fmt.Errorf("%s directive is not supported when migrations are applied in a single transaction", copyDirective)
+	}
+	ctx := context.Background()
+	conn, err := rawConn(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	pinned := r.db.Session(&gorm.Session{Context: ctx})
+	pinned.Statement.ConnPool, pinned.Config.ConnPool = conn, conn
+	copier := *r
+	copier.db, copier.conn = pinned, conn
+	return copier.apply(m)
+}
+
+// copyRows executes copy directives placed before the line, returning the rest of them.
+func (r *runner) copyRows(m migrationFile, directives []directive, before int) ([]directive, error) {
+	for len(directives) > 0 && directives[0].Line < before {
+		d := directives[0]
+		directives = directives[1:]
+		o, err := parseCopyOptions(d)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := m.copies[o.File]
+		if !ok || r.conn == nil {
+			return nil, fmt.Errorf("data of %s directive at line %d is not loaded", copyDirective, d.Line)
+		}
+		var rows int64
+		err = r.conn.Raw(func(driverConn any) error {
+			tag, err := driverConn.(*stdlib.Conn).Conn().PgConn().CopyFrom(context.Background(), bytes.NewReader(data), o.Statement())
+			rows = tag.RowsAffected()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't copy %s into %s at line %d: %w", o.File, o.Table, d.Line, err)
+		}
+		logrus.Debugf("migration %s: %d rows of %s are copied into %s", m.Path(), rows, o.File, o.Table)
+	}
+	return directives, nil
+}
+
+// copyDirectives returns copy directives of migration body in order of lines.
+func copyDirectives(body string) []directive {
+	var result []directive
+	for _, d := range parseDirectives(body) {
+		if d.Name == copyDirective {
+			result = append(result, d)
+		}
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultVersionWidth = 4
+
+// runCreate scaffolds the next migration file of module and track numbered after existing ones. With -down flag
+// draft down file is generated from the up SQL, it must be reviewed as only common DDL is reverted.
+func runCreate(args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := flags.String("dir", migrationsDirName, "migrations dir to create file in")
+	module := flags.String("module", moduleDefault, "module of migration")
+	track := flags.String("track", trackDefault, "track of migration")
+	upSQL := flags.String("sql", "", "file with SQL of migration, empty migration is created by default")
+	down := flags.Bool("down", false, "generate draft down file reverting the SQL")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		logrus.Fatal("name of migration must be given, i.e. migrator create add_orders")
+	}
+	name := strings.ReplaceAll(strings.TrimSpace(trimMigrationExt(flags.Arg(0))), " ", "_")
+
+	target := filepath.Join(*dir, filepath.FromSlash(*module), filepath.FromSlash(*track))
+	version, err := nextVersion(target)
+	if err != nil {
+		logrus.WithError(err).Fatal("can't number migration")
+	}
+	body := "-- Write SQL of the migration here\n"
+	if *upSQL != "" {
+		raw, err := os.ReadFile(*upSQL)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		body = string(raw)
+	}
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		logrus.Fatal(err)
+	}
+	file := version + "_" + name + ".sql"
+	created := []string{filepath.Join(target, file)}
+	if *down {
+		created = append(created, filepath.Join(target, version+"_"+name+downExt))
+	}
+	contents := []string{body, generateDown(file, body)}
+	for i, path := range created {
+		if err := writeNewFile(path, contents[i]); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	fmt.Println("Has created:")
+	for _, path := range created {
+		fmt.Println(" - ", path)
+	}
+	if *down {
+		fmt.Println("Down file is a draft, review it before committing.")
+	}
+}
+
+// nextVersion returns version prefix following the highest one in the dir, keeping width of existing numbering.
+func nextVersion(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var last int64
+	width := defaultVersionWidth
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if v, ok := fileVersion(e.Name()); ok && v >= last {
+			last = v
+			width = len(versionPrefix.FindStringSubmatch(e.Name())[1])
+		}
+	}
+	return fmt.Sprintf("%0*d", width, last+1), nil
+}
+
+// writeNewFile writes the file failing if it already exists, so nothing is overwritten.
+func writeNewFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is parsed cron expression of 5 fields: minute, hour, day of month, month and day of week.
+// Fields support "*", numbers, ranges "1-5", lists "1,15" and steps "*/15". Sunday is either 0 or 7.
+type schedule [5]uint64
+
+var scheduleBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+const (
+	weekdayField = 4
+	sunday       = 7 // alias of 0 in day of week field
+)
+
+func parseSchedule(expr string) (schedule, error) {
+	var s schedule
+	fields := strings.Fields(expr)
+	if len(fields) != len(s) {
+		return s, fmt.Errorf("invalid schedule %q, expected 5 fields: minute hour day month weekday", expr)
+	}
+	for i, field := range fields {
+		low, high := scheduleBounds[i][0], scheduleBounds[i][1]
+		for _, part := range strings.Split(field, ",") {
+			rangePart, stepPart, hasStep := strings.Cut(part, "/")
+			step := 1
+			if hasStep {
+				var err error
+				if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+					return s, fmt.Errorf("invalid step %q in schedule %q", stepPart, expr)
+				}
+			}
+			from, to := low, high
+			if rangePart != "*" {
+				first, last, isRange := strings.Cut(rangePart, "-")
+				var err1, err2 error
+				from, err1 = strconv.Atoi(first)
+				to = from
+				if isRange {
+					to, err2 = strconv.Atoi(last)
+				}
+				if err1 != nil || err2 != nil || from < low || to > high || from > to {
+					return s, fmt.Errorf("invalid value %q in schedule %q", rangePart, expr)
+				}
+			}
+			for v := from; v <= to; v += step {
+				s[i] |= 1 << v
+			}
+		}
+	}
+	if s[weekdayField]&(1<<sunday) != 0 {
+		s[weekdayField] = s[weekdayField]&^(1<<sunday) | 1
+	}
+	return s, nil
+}
+
+// Next returns the first minute after t matching the schedule.
+func (s schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every matching minute is found within a few years even for rare dates like February 29
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+		if s.Matches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Matches reports whether minute of t matches the schedule. When both day of month and day of week
+// are restricted, either of them matches like in cron.
+func (s schedule) Matches(t time.Time) bool {
+	if s[0]&(1<<t.Minute()) == 0 || s[1]&(1<<t.Hour()) == 0 || s[3]&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	day, weekday := s[2]&(1<<t.Day()) != 0, s[weekdayField]&(1<<int(t.Weekday())) != 0
+	if s.restricted(2) && s.restricted(weekdayField) {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// restricted reports whether the field doesn't match all of its values.
+func (s schedule) restricted(field int) bool {
+	low, high := scheduleBounds[field][0], scheduleBounds[field][1]
+	if field == weekdayField {
+		high = sunday - 1
+	}
+	all := uint64(1)<<(high+1) - uint64(1)<<low
+	return s[field]&all != all
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleMatches(t *testing.T) {
+	// 2024-03-01 is Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(1, 0, 0), true},
+		{"*/15 * * * *", at(1, 10, 45), true},
+		{"*/15 * * * *", at(1, 10, 46), false},
+		{"0 2-4 * * *", at(1, 4, 0), true},
+		{"0 2-4 * * *", at(1, 5, 0), false},
+		{"30 1,13 * * *", at(1, 13, 30), true},
+		{"0 0 * 3 *", at(1, 0, 0), true},
+		{"0 0 * 4 *", at(1, 0, 0), false},
+		{"* * * * 1-5", at(1, 12, 0), true},  // Friday
+		{"* * * * 1-5", at(2, 12, 0), false}, // Saturday
+		{"* * * * 0", at(3, 12, 0), true},    // Sunday
+		{"* * * * 7", at(3, 12, 0), true},
+		{"* * * * 7", at(4, 12, 0), false},
+		{"* * * * 5-7", at(3, 12, 0), true},
+		{"* * * * 5-7", at(4, 12, 0), false},
+		// Day of month and day of week restricted both match either of them
+		{"0 0 13 * 5", at(1, 0, 0), true},  // Friday, not 13th
+		{"0 0 13 * 5", at(13, 0, 0), true}, // 13th, Wednesday
+		{"0 0 13 * 5", at(12, 0, 0), false},
+		{"0 0 13 * 7", at(3, 0, 0), true},
+		// Only one of them restricted has to match
+		{"0 0 13 * *", at(1, 0, 0), false},
+		{"0 0 * * 5", at(13, 0, 0), false},
+		// Weekday field listing all days isn't a restriction, even by 7 instead of 0
+		{"0 0 13 * 1-7", at(1, 0, 0), false},
+		{"0 0 13 * 0-6", at(13, 0, 0), true},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.expr, err)
+		}
+		if got := s.Matches(tt.t); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.t.Format("Mon 2006-01-02 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "1-x * * * *"} {
+		if _, err := parseSchedule(expr); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	s, err := parseSchedule("30 2 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Schedule is evaluated in time zone of the given time
+	from := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC).In(berlin)
+	if got, want := s.Next(from), time.Date(2024, time.March, 3, 2, 30, 0, 0, berlin); !got.Equal(want) {
+		t.Errorf("Next(%s) = %s, want %s", from, got, want)
+	}
+	never, err := parseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := never.Next(from); !got.IsZero() {
+		t.Errorf("Next of never matching schedule = %s, want zero", got)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exitPending is exit code of "up -check" when there are pending migrations.
+const exitPending = 3
+
+// Daemon configures long-running mode checking the database on schedule.
+type Daemon struct {
+	Schedule string `yaml:"schedule"`
+	// Time zone of schedule like Europe/Berlin, time zone of maintenance window by default
+	Timezone string `yaml:"timezone"`
+	// Source of migrations, i.e. oci://registry/app/migrations:latest, configured sources are used by default
+	From string `yaml:"from"`
+}
+
+// configPollInterval is how often daemon checks its config file for changes.
+const configPollInterval = 10 * time.Second
+
+// runDaemon periodically checks the database for drift and pending migrations, applying them within
+// maintenance window of config and reporting results by notify hooks. Every check is done by separate run
+// of the binary, so it is isolated from failures of previous ones. Under systemd readiness and watchdog
+// are reported by sd_notify, SIGHUP reloads config.
+func runDaemon(args []string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	path := configPath()
+	config := initConfig(path)
+	opts.Setup()
+	d, err := newDaemonState(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	modified := configModTime(path)
+	reload := time.NewTicker(configPollInterval)
+	defer reload.Stop()
+	hup, stop := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				sdNotify("WATCHDOG=1")
+			}
+		}()
+	}
+	sdNotify("READY=1")
+
+	for {
+		next := d.sched.Next(time.Now().In(d.location))
+		if next.IsZero() {
+			logrus.Fatalf("schedule %q never matches", d.config.Daemon.Schedule)
+		}
+		logrus.Infof("next check is at %s", next.Format(time.RFC3339))
+		sdNotify("STATUS=next check is at " + next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-reload.C:
+				if t := configModTime(path); !t.Equal(modified) {
+					modified = t
+					if d.Reload(path) {
+						// Schedule may have changed, the next check is computed again
+						timer.Stop()
+						break wait
+					}
+				}
+			case <-hup:
+				sdNotify("RELOADING=1")
+				logrus.Info("reloading config by SIGHUP")
+				modified = configModTime(path)
+				changed := d.Reload(path)
+				sdNotify("READY=1")
+				if changed {
+					timer.Stop()
+					break wait
+				}
+			case sig := <-stop:
+				sdNotify("STOPPING=1")
+				logrus.Infof("daemon is stopped by %s", sig)
+				return
+			}
+		}
+		if time.Now().Before(next) {
+			continue
+		}
+		sdNotify("STATUS=checking the database")
+		d.Check()
+	}
+}
+
+// daemonState is config of running daemon with its parsed schedule and maintenance window.
+type daemonState struct {
+	config   Config
+	sched    schedule
+	location *time.Location // of schedule
+	window   window
+}
+
+func newDaemonState(config Config) (*daemonState, error) {
+	d := &daemonState{config: config}
+	var err error
+	if d.sched, err = parseSchedule(config.Daemon.Schedule); err != nil {
+		return nil, err
+	}
+	if d.window, err = parseMaintenanceWindow(config.Window); err != nil {
+		return nil, err
+	}
+	d.location = d.window.location
+	if config.Daemon.Timezone != "" {
+		if d.location, err = time.LoadLocation(config.Daemon.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid time zone of daemon schedule: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// Reload applies changed settings of daemon itself: log level, schedule, maintenance window, source and notify hooks.
+// Other settings are read by separate runs of checks anyway. Invalid config is logged and ignored.
+// It reports whether anything has changed.
+func (d *daemonState) Reload(path string) bool {
+	config, err := loadConfig(path)
+	if err == nil {
+		var reloaded *daemonState
+		if reloaded, err = newDaemonState(config); err == nil {
+			return d.apply(reloaded)
+		}
+	}
+	logrus.WithError(err).Error("can't reload changed config, the previous one is kept")
+	return false
+}
+
+func (d *daemonState) apply(reloaded *daemonState) bool {
+	old, config := d.config, reloaded.config
+	changed := false
+	change := func(setting string, from, to interface{}) {
+		logrus.Infof("config is reloaded: %s is changed from %v to %v", setting, from, to)
+		changed = true
+	}
+	if old.LogLevel != config.LogLevel {
+		level, _ := logrus.ParseLevel(config.LogLevel)
+		logrus.SetLevel(level)
+		change("logLevel", old.LogLevel, config.LogLevel)
+	}
+	if old.Daemon.Schedule != config.Daemon.Schedule {
+		change("daemon.schedule", old.Daemon.Schedule, config.Daemon.Schedule)
+	}
+	if old.Daemon.Timezone != config.Daemon.Timezone {
+		change("daemon.timezone", old.Daemon.Timezone, config.Daemon.Timezone)
+	}
+	if !reflect.DeepEqual(old.Window, config.Window) {
+		change("window", old.Window, config.Window)
+	}
+	if old.Daemon.From != config.Daemon.From {
+		change("daemon.from", redactURL(old.Daemon.From), redactURL(config.Daemon.From))
+	}
+	if !reflect.DeepEqual(old.Hooks.Notify, config.Hooks.Notify) {
+		logrus.Infof("config is reloaded: hooks.notify are changed, %d hooks are configured", len(config.Hooks.Notify))
+		changed = true
+	}
+	if old.Database != config.Database {
+		logrus.Info("config is reloaded: database settings are changed, they are used by the next check")
+	}
+	*d = *reloaded
+	return changed
+}
+
+// Check runs check of the database applying pending migrations within maintenance window. Without window
+// they are only reported.
+func (d *daemonState) Check() {
+	config := d.config
+	event, message := "up-to-date", ""
+	code := runSelf(config, "up", "-check")
+	switch {
+	case code == exitPending && len(config.Window.Schedules) > 0 && d.window.Open(time.Now()):
+		event, message = "applied", "pending migrations are applied within maintenance window"
+		switch runSelf(config, "up") {
+		case 0:
+		case exitOutsideWindow:
+			event, message = "pending", "there are pending migrations, they are waiting for maintenance window"
+		default:
+			event, message = "failed", "pending migrations have failed to apply"
+		}
+	case code == exitPending:
+		event, message = "pending", "there are pending migrations, they are waiting for maintenance window"
+	case code != 0:
+		event, message = "drift", "applied migrations don't match their files or the database is unreachable"
+	}
+	logrus.Infof("check is done: %s", event)
+	if event != "up-to-date" {
+		if err := notify(config.Hooks.Notify, event, message); err != nil {
+			logrus.WithError(err).Error("can't notify")
+		}
+	}
+}
+
+// configModTime returns modification time of config file, zero if it can't be read.
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// runSelf runs command of the binary itself and returns its exit code.
+func runSelf(config Config, args ...string) int {
+	if config.Daemon.From != "" {
+		args = append(args, "-from", config.Daemon.From)
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		logrus.WithError(err).Error("can't run check")
+		return -1
+	}
+	return 0
+}
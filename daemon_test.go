@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDaemonScheduleLocation(t *testing.T) {
+	tests := []struct {
+		window, daemon string
+		want           string
+	}{
+		{"", "", time.Local.String()},
+		{"Asia/Tokyo", "", "Asia/Tokyo"},
+		{"Asia/Tokyo", "Europe/Berlin", "Europe/Berlin"},
+		{"", "UTC", "UTC"},
+	}
+	for _, tt := range tests {
+		var config Config
+		config.Daemon.Schedule, config.Daemon.Timezone, config.Window.Timezone = "*/15 * * * *", tt.daemon, tt.window
+		d, err := newDaemonState(config)
+		if err != nil {
+			t.Skip(err)
+		}
+		if got := d.location.String(); got != tt.want {
+			t.Errorf("location of schedule by window %q and daemon %q time zones = %s, want %s", tt.window, tt.daemon, got, tt.want)
+		}
+	}
+
+	var config Config
+	config.Daemon.Schedule, config.Daemon.Timezone = "*/15 * * * *", "Nowhere/City"
+	if _, err := newDaemonState(config); err == nil {
+		t.Error("newDaemonState with invalid time zone succeeded, want error")
+	}
+}
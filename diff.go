@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// diffContext is number of unchanged lines shown around changes.
+const diffContext = 3
+
+type diffOp byte
+
+const (
+	diffEqual  diffOp = ' '
+	diffDelete diffOp = '-'
+	diffInsert diffOp = '+'
+)
+
+type diffLine struct {
+	Op   diffOp
+	Text string
+}
+
+// reportChange prints difference between applied migration and its file,
+// it is also written into configured file to be attached to CI artifacts.
+func reportChange(config Config, name, appliedBody, fileBody string) {
+	d, err := renderDiff(config, "applied/"+name, name, appliedBody, fileBody)
+	if err != nil {
+		logrus.WithError(err).Warn("can't render diff, unified one is printed")
+		d = unifiedDiff("applied/"+name, name, appliedBody, fileBody)
+	}
+	fmt.Fprint(os.Stderr, d)
+	if config.Diff.Output == "" {
+		return
+	}
+	if err := os.WriteFile(config.Diff.Output, []byte(d), 0644); err != nil {
+		logrus.WithError(err).Error("can't write diff file")
+	}
+}
+
+// firstChangedLine returns number of the first line of new text differing from old text.
+func firstChangedLine(oldText, newText string) int {
+	oldLines, newLines := splitLines(oldText), splitLines(newText)
+	i := 0
+	for i < len(oldLines) && i < len(newLines) && oldLines[i] == newLines[i] {
+		i++
+	}
+	// Text truncated at the end is reported at its last line
+	if i == len(newLines) && i > 0 {
+		return i
+	}
+	return i + 1
+}
+
+// unifiedDiff returns difference between old and new texts in unified format with line numbers in hunk headers.
+// Empty string is returned for equal texts.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	return unifiedDiffContext(oldName, newName, oldText, newText, diffContext)
+}
+
+// unifiedDiffContext returns unified diff with the given number of context lines, negative one shows whole texts.
+func unifiedDiffContext(oldName, newName, oldText, newText string, context int) string {
+	oldLines, newLines := splitLines(oldText), splitLines(newText)
+	lines := diffLines(oldLines, newLines)
+	if context < 0 {
+		context = len(lines)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	changed := false
+	for start := 0; start < len(lines); {
+		// Find next change and the end of its hunk including context
+		first := start
+		for first < len(lines) && lines[first].Op == diffEqual {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		changed = true
+		hunkStart := first - context
+		if hunkStart < start {
+			hunkStart = start
+		}
+		// Hunk is extended while the next change is close enough for contexts to overlap
+		end := first
+		for {
+			for end < len(lines) && lines[end].Op != diffEqual {
+				end++
+			}
+			next := end
+			for next < len(lines) && lines[next].Op == diffEqual {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		hunkEnd := end + context
+		if hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+
+		oldStart, newStart := lineNumbers(lines[:hunkStart])
+		var oldCount, newCount int
+		for _, l := range lines[hunkStart:hunkEnd] {
+			if l.Op != diffInsert {
+				oldCount++
+			}
+			if l.Op != diffDelete {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, l := range lines[hunkStart:hunkEnd] {
+			fmt.Fprintf(&out, "%c%s\n", l.Op, l.Text)
+		}
+		start = hunkEnd
+	}
+	if !changed {
+		return ""
+	}
+	return out.String()
+}
+
+// hunkRange formats range of hunk lines, empty range points to the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// lineNumbers returns number of old and new lines in the diff part.
+func lineNumbers(lines []diffLine) (int, int) {
+	var oldNum, newNum int
+	for _, l := range lines {
+		if l.Op != diffInsert {
+			oldNum++
+		}
+		if l.Op != diffDelete {
+			newNum++
+		}
+	}
+	return oldNum, newNum
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script transforming a into b found by Myers algorithm.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	// Only diagonals -d..d are reachable at step d, so trace keeps 2d+1 of them, entry of diagonal k is at k+d
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(trace [][]int, a, b []string, d int) []diffLine {
+	x, y := len(a), len(b)
+	var result []diffLine
+	for ; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK, prevX int
+		switch {
+		case d == 0:
+			// The first snake starts from the virtual point before the beginning
+			prevK, prevX = 1, 0
+		case k == -d || k != d && v[k-1+d] < v[k+1+d]:
+			prevK = k + 1
+			prevX = v[prevK+d]
+		default:
+			prevK = k - 1
+			prevX = v[prevK+d]
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			result = append(result, diffLine{Op: diffEqual, Text: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				result = append(result, diffLine{Op: diffInsert, Text: b[y]})
+			} else {
+				x--
+				result = append(result, diffLine{Op: diffDelete, Text: a[x]})
+			}
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// runDiffModels compares registered models against the live schema and emits draft SQL migration.
+// Draft is never applied, it is expected to be reviewed and saved as a new migration file.
+func runDiffModels(args []string) {
+	flags := flag.NewFlagSet("diff-models", flag.ExitOnError)
+	output := flags.String("output", "", "file to write draft migration to (stdout by default)")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	config := initConfig(configPath())
+	opts.Setup()
+	if len(models) == 0 {
+		logrus.Fatal("no models are registered, list them in models.go")
+	}
+	db := connectDB(config)
+
+	draft, err := diffModels(db, models)
+	if err != nil {
+		logrus.WithError(err).Fatal("can't compare models against schema")
+	}
+	if draft == "" {
+		fmt.Fprintln(os.Stderr, "Models match schema, nothing to migrate.")
+		return
+	}
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			logrus.WithError(err).Fatal("can't create draft file")
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := fmt.Fprintf(out, "-- Draft generated by diff-models at %s, review it before applying\n%s",
+		time.Now().UTC().Format(time.RFC3339), draft); err != nil {
+		logrus.WithError(err).Fatal("can't write draft")
+	}
+}
+
+// diffModels returns statements creating missing tables and adding missing columns of models.
+// Columns missed in models are only mentioned in comments, dropping them is left to a human.
+func diffModels(db *gorm.DB, models []interface{}) (string, error) {
+	capture := &sqlCapture{}
+	// Statements are generated by GORM migrator in dry run mode and captured instead of execution
+	dry := db.Session(&gorm.Session{DryRun: true, Logger: capture})
+
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return "", fmt.Errorf("can't parse model %T: %w", model, err)
+		}
+		table := stmt.Schema.Table
+		if !db.Migrator().HasTable(model) {
+			capture.Comment("table %s is missing", table)
+			if err := dry.Migrator().CreateTable(model); err != nil {
+				return "", fmt.Errorf("can't generate table %s: %w", table, err)
+			}
+			continue
+		}
+
+		columns, err := db.Migrator().ColumnTypes(model)
+		if err != nil {
+			return "", fmt.Errorf("can't get columns of table %s: %w", table, err)
+		}
+		existing := make(map[string]bool, len(columns))
+		for _, column := range columns {
+			existing[column.Name()] = true
+		}
+		for _, dbName := range stmt.Schema.DBNames {
+			if existing[dbName] {
+				delete(existing, dbName)
+				continue
+			}
+			capture.Comment("column %s.%s is missing", table, dbName)
+			if err := dry.Migrator().AddColumn(model, dbName); err != nil {
+				return "", fmt.Errorf("can't generate column %s.%s: %w", table, dbName, err)
+			}
+		}
+		for _, column := range columns {
+			if existing[column.Name()] {
+				capture.Comment("column %s.%s is not in model %s, drop it by hand if it is not needed", table, column.Name(), stmt.Schema.Name)
+			}
+		}
+	}
+	return capture.String(), nil
+}
+
+// sqlCapture is GORM logger collecting traced statements into migration body.
+type sqlCapture struct {
+	b strings.Builder
+}
+
+func (c *sqlCapture) Comment(format string, args ...interface{}) {
+	fmt.Fprintf(&c.b, "\n-- "+format+"\n", args...)
+}
+
+func (c *sqlCapture) String() string {
+	return c.b.String()
+}
+
+func (c *sqlCapture) LogMode(logger.LogLevel) logger.Interface      { return c }
+func (c *sqlCapture) Info(context.Context, string, ...interface{})  {}
+func (c *sqlCapture) Warn(context.Context, string, ...interface{})  {}
+func (c *sqlCapture) Error(context.Context, string, ...interface{}) {}
+
+func (c *sqlCapture) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	c.b.WriteString(sql + ";\n")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const diffFormatUnified = "unified"
+
+// diffRenderer returns difference of old and new texts, empty string for equal ones.
+type diffRenderer func(oldName, newName, oldText, newText string) (string, error)
+
+// diffRenderers are formats of mismatch diff by name of diff.format config, another one may be added
+// in init function of a separate file of the package.
+var diffRenderers = map[string]diffRenderer{
+	diffFormatUnified: func(oldName, newName, oldText, newText string) (string, error) {
+		return unifiedDiff(oldName, newName, oldText, newText), nil
+	},
+	"line":      lineDiff,
+	"character": characterDiff,
+}
+
+// renderDiff renders mismatch diff by external command or format of config.
+func renderDiff(config Config, oldName, newName, oldText, newText string) (string, error) {
+	if config.Diff.Command != "" {
+		return commandDiff(config.Diff.Command, oldName, newName, oldText, newText)
+	}
+	format := config.Diff.Format
+	if format == "" {
+		format = diffFormatUnified
+	}
+	render, ok := diffRenderers[format]
+	if !ok {
+		return "", fmt.Errorf("unknown diff format %q", format)
+	}
+	return render(oldName, newName, oldText, newText)
+}
+
+// lineDiff returns changed lines only, each one with its number in old or new text.
+func lineDiff(oldName, newName, oldText, newText string) (string, error) {
+	var out strings.Builder
+	oldNum, newNum := 0, 0
+	for _, l := range diffLines(splitLines(oldText), splitLines(newText)) {
+		switch l.Op {
+		case diffEqual:
+			oldNum, newNum = oldNum+1, newNum+1
+		case diffDelete:
+			oldNum++
+			fmt.Fprintf(&out, "%s:%d: - %s\n", oldName, oldNum, l.Text)
+		case diffInsert:
+			newNum++
+			fmt.Fprintf(&out, "%s:%d: + %s\n", newName, newNum, l.Text)
+		}
+	}
+	return out.String(), nil
+}
+
+// characterDiff returns changed lines of new text with deleted characters marked as [-old-] and inserted
+// ones as {+new+}, so changes within a line like a single replaced letter are visible. Characters are compared
+// within blocks of changed lines only, so large bodies are diffed quickly.
+func characterDiff(oldName, newName, oldText, newText string) (string, error) {
+	var out strings.Builder
+	lines := diffLines(splitLines(oldText), splitLines(newText))
+	num := 1 // number of the next line of new text
+	for i := 0; i < len(lines); {
+		if lines[i].Op == diffEqual {
+			num, i = num+1, i+1
+			continue
+		}
+		var deleted, inserted []string
+		for ; i < len(lines) && lines[i].Op != diffEqual; i++ {
+			if lines[i].Op == diffDelete {
+				deleted = append(deleted, lines[i].Text)
+			} else {
+				inserted = append(inserted, lines[i].Text)
+			}
+		}
+		for _, l := range markChars(strings.Join(deleted, "\n"), strings.Join(inserted, "\n")) {
+			fmt.Fprintf(&out, "%s:%d: %s\n", newName, num+l.offset, l.text)
+		}
+		num += len(inserted)
+	}
+	if out.Len() == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("--- %s\n+++ %s\n%s", oldName, newName, out.String()), nil
+}
+
+// markedLine is a line of characterDiff with offset of its first line in the new text of the block.
+type markedLine struct {
+	text   string
+	offset int
+}
+
+// maxCharDiff limits length of a block of changed lines compared by characters, lines of larger blocks
+// are marked as a whole, so the diff of a rewritten body doesn't take long.
+const maxCharDiff = 4096
+
+// markChars marks changed characters of b against a. Lines are split by line breaks of both texts,
+// deleted and inserted ones are escaped within marks.
+func markChars(a, b string) []markedLine {
+	if len(a)+len(b) > maxCharDiff {
+		var result []markedLine
+		for _, l := range splitLines(a) {
+			result = append(result, markedLine{"[-" + l + "-]", 0})
+		}
+		for i, l := range splitLines(b) {
+			result = append(result, markedLine{"{+" + l + "+}", i})
+		}
+		return result
+	}
+	ops := diffLines(strings.Split(a, ""), strings.Split(b, ""))
+	var result []markedLine
+	var line strings.Builder
+	offset, start := 0, 0
+	for i := 0; i < len(ops); {
+		op := ops[i]
+		if op.Op == diffEqual {
+			if op.Text == "\n" {
+				offset++
+				result = append(result, markedLine{line.String(), start})
+				line.Reset()
+				start = offset
+			} else {
+				line.WriteString(op.Text)
+			}
+			i++
+			continue
+		}
+		// Consecutive changed characters are marked together
+		var group strings.Builder
+		for ; i < len(ops) && ops[i].Op == op.Op; i++ {
+			if ops[i].Text == "\n" {
+				group.WriteString(`\n`)
+				if op.Op == diffInsert {
+					offset++
+				}
+				continue
+			}
+			group.WriteString(ops[i].Text)
+		}
+		if op.Op == diffDelete {
+			fmt.Fprintf(&line, "[-%s-]", group.String())
+		} else {
+			fmt.Fprintf(&line, "{+%s+}", group.String())
+		}
+	}
+	return append(result, markedLine{line.String(), start})
+}
+
+// commandDiff renders diff by external command, i.e. "git diff --no-index --color-words", paths of files
+// with old and new texts are appended to it. Non-zero exit code with output means differences, like of diff(1).
+func commandDiff(command, oldName, newName, oldText, newText string) (string, error) {
+	dir, err := os.MkdirTemp("", "migrator-diff")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	// Paths are relative to the temporary directory, so output shows names only
+	paths := []string{oldName, newName}
+	for i, text := range []string{oldText, newText} {
+		p := filepath.Join(dir, filepath.FromSlash(paths[i]))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(p, []byte(text), 0o644); err != nil {
+			return "", err
+		}
+	}
+	cmd := exec.Command("sh", "-c", command+` "$@"`, "sh", paths[0], paths[1])
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && len(out) > 0) {
+		return "", fmt.Errorf("diff command has failed: %w", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRenderDiff(t *testing.T) {
+	const oldText, newText = "a\nb\nc\n", "a\nB\nc\nd\n"
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", "--- applied/x.sql\n+++ x.sql\n@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n"},
+		{diffFormatUnified, "--- applied/x.sql\n+++ x.sql\n@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n"},
+		{"line", "applied/x.sql:2: - b\nx.sql:2: + B\nx.sql:4: + d\n"},
+		{"character", "--- applied/x.sql\n+++ x.sql\nx.sql:2: [-b-]{+B+}\nx.sql:4: {+d+}\n"},
+	}
+	for _, tt := range tests {
+		var config Config
+		config.Diff.Format = tt.format
+		got, err := renderDiff(config, "applied/x.sql", "x.sql", oldText, newText)
+		if err != nil || got != tt.want {
+			t.Errorf("renderDiff by %q format = %q, %v, want %q", tt.format, got, err, tt.want)
+		}
+		if got, err := renderDiff(config, "applied/x.sql", "x.sql", oldText, oldText); err != nil || got != "" {
+			t.Errorf("renderDiff by %q format of equal texts = %q, %v, want empty", tt.format, got, err)
+		}
+	}
+
+	var config Config
+	config.Diff.Format = "words"
+	if _, err := renderDiff(config, "applied/x.sql", "x.sql", oldText, newText); err == nil {
+		t.Error("renderDiff by unknown format succeeded, want error")
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, string(rune('a'+i)))
+	}
+	oldText := strings.Join(lines, "\n") + "\n"
+	lines[1], lines[17] = "B", "R"
+	newText := strings.Join(lines, "\n") + "\n"
+	want := "--- old\n+++ new\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -15,6 +15,6 @@\n o\n p\n q\n-r\n+R\n s\n t\n"
+	if got := unifiedDiff("old", "new", oldText, newText); got != want {
+		t.Errorf("unifiedDiff = %q, want %q", got, want)
+	}
+	if got, want := unifiedDiff("old", "new", "", "a\n"), "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n"; got != want {
+		t.Errorf("unifiedDiff of added file = %q, want %q", got, want)
+	}
+}
+
+func TestCharacterDiff(t *testing.T) {
+	tests := []struct {
+		name             string
+		oldText, newText string
+		want             string
+	}{
+		{"replaced letter", "SELECT 1;\n", "SELECT 2;\n", "--- o\n+++ n\nn:1: SELECT [-1-]{+2+};\n"},
+		{"added line", "a\nb\n", "a\nx\nb\n", "--- o\n+++ n\nn:2: {+x+}\n"},
+		{"removed line", "a\nx\nb\n", "a\nb\n", "--- o\n+++ n\nn:2: [-x-]\n"},
+		{"changed block", "a\nid int\nb\n", "a\nid bigint\nb\n", "--- o\n+++ n\nn:2: id {+b+}i{+gi+}nt\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := characterDiff("o", "n", tt.oldText, tt.newText); err != nil || got != tt.want {
+				t.Errorf("characterDiff(%q, %q) = %q, %v, want %q", tt.oldText, tt.newText, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestCharacterDiffLargeBlock checks that block of changed lines larger than maxCharDiff is marked as a whole.
+func TestCharacterDiffLargeBlock(t *testing.T) {
+	oldLine, newLine := strings.Repeat("a", maxCharDiff), strings.Repeat("b", maxCharDiff)
+	got, err := characterDiff("o", "n", "x\n"+oldLine+"\n", "x\n"+newLine+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--- o\n+++ n\nn:2: [-" + oldLine + "-]\nn:2: {+" + newLine + "+}\n"; got != want {
+		t.Errorf("characterDiff of large block = %q, want %q", got, want)
+	}
+}
+
+// TestDiffLines checks that edit script turns one text into another and is the shortest one for known cases.
+func TestDiffLines(t *testing.T) {
+	apply := func(lines []diffLine) (string, string) {
+		var a, b []string
+		for _, l := range lines {
+			if l.Op != diffInsert {
+				a = append(a, l.Text)
+			}
+			if l.Op != diffDelete {
+				b = append(b, l.Text)
+			}
+		}
+		return strings.Join(a, ""), strings.Join(b, "")
+	}
+	edits := func(lines []diffLine) int {
+		n := 0
+		for _, l := range lines {
+			if l.Op != diffEqual {
+				n++
+			}
+		}
+		return n
+	}
+	for _, tt := range []struct {
+		a, b  string
+		edits int
+	}{
+		{"abcabba", "cbabac", 5},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"abc", "abc", 0},
+		{"kitten", "sitting", 5},
+	} {
+		lines := diffLines(strings.Split(tt.a, ""), strings.Split(tt.b, ""))
+		if a, b := apply(lines); a != tt.a || b != tt.b || edits(lines) != tt.edits {
+			t.Errorf("diffLines(%q, %q) = %v with %d edits, want %d edits", tt.a, tt.b, lines, edits(lines), tt.edits)
+		}
+	}
+
+	random := rand.New(rand.NewSource(1))
+	text := func() string {
+		b := make([]byte, random.Intn(30))
+		for i := range b {
+			b[i] = "abc"[random.Intn(3)]
+		}
+		return string(b)
+	}
+	for i := 0; i < 500; i++ {
+		x, y := text(), text()
+		if a, b := apply(diffLines(strings.Split(x, ""), strings.Split(y, ""))); a != x || b != y {
+			t.Fatalf("edit script of diffLines(%q, %q) produces %q and %q", x, y, a, b)
+		}
+	}
+}
+
+func TestCommandDiff(t *testing.T) {
+	got, err := commandDiff("cat", "applied/x.sql", "x.sql", "SELECT 1;\n", "SELECT 2;\n")
+	if err != nil || got != "SELECT 1;\nSELECT 2;\n" {
+		t.Errorf("commandDiff by cat = %q, %v", got, err)
+	}
+	// Exit code of diff(1) is 1 for different files
+	got, err = commandDiff("diff", "applied/x.sql", "x.sql", "SELECT 1;\n", "SELECT 2;\n")
+	if err != nil || !strings.Contains(got, "< SELECT 1;") || !strings.Contains(got, "> SELECT 2;") {
+		t.Errorf("commandDiff by diff = %q, %v", got, err)
+	}
+	if _, err := commandDiff("exit 2", "applied/x.sql", "x.sql", "a", "b"); err == nil {
+		t.Error("commandDiff by failed command without output succeeded, want error")
+	}
+}
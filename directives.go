@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+)
+
+const directivePrefix = "-- migrator:"
+
+// directive is a special comment inside of migration file which changes the way migration is applied,
+// for example "-- migrator:batched rows=10000".
+type directive struct {
+	Name string
+	Args string
+	Line int
+}
+
+// parseDirectives returns all directives found in migration body.
+// Directive must be placed on its own line.
+func parseDirectives(body string) []directive {
+	var result []directive
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		name, args, _ := strings.Cut(strings.TrimPrefix(line, directivePrefix), " ")
+		result = append(result, directive{Name: name, Args: strings.TrimSpace(args), Line: i + 1})
+	}
+	return result
+}
+
+// findDirective returns the first directive with given name.
+func findDirective(directives []directive, name string) (directive, bool) {
+	for _, d := range directives {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return directive{}, false
+}
+
+// Params parses directive arguments in form of "key=value key2=value2".
+func (d directive) Params() map[string]string {
+	params := make(map[string]string)
+	for _, field := range strings.Fields(d.Args) {
+		key, value, _ := strings.Cut(field, "=")
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const diskPolicyWarn = "warn"
+
+// DiskCheck configures check of free disk space before migrations rewriting tables. Postgres doesn't report
+// free space of the server, so it is estimated by capacity of storage or returned by the query.
+type DiskCheck struct {
+	// Storage of the server like 500GB, free space is estimated as capacity minus sizes of all databases
+	Capacity string `yaml:"capacity"`
+	// Query returning free bytes of the server instead of estimate, i.e. by an extension or foreign table
+	FreeQuery string `yaml:"freeQuery"`
+	// Space like 10GB which must be left free after rewrites, i.e. for WAL
+	Reserve string `yaml:"reserve"`
+	// fail (default) refuses to start, warn only logs the shortage
+	Policy string `yaml:"policy" binding:"omitempty,oneof=fail warn"`
+}
+
+// Enabled reports whether free space is checked.
+func (c DiskCheck) Enabled() bool {
+	return c.Capacity != "" || c.FreeQuery != ""
+}
+
+// rewrite is a table statement writes a new copy of, only indexes of it are rebuilt by REINDEX.
+type rewrite struct {
+	Table       string
+	IndexesOnly bool
+}
+
+// tableRewrites returns tables rewritten by statement. Common statements are recognized only, i.e. changes
+// of column types are considered rewrites though some of them are binary compatible.
+func tableRewrites(sql string) []rewrite {
+	t := sqlTokens(sql)
+	is := func(i int, words ...string) bool {
+		for j, w := range words {
+			if i+j >= len(t) || !strings.EqualFold(t[i+j], w) {
+				return false
+			}
+		}
+		return true
+	}
+	rest := " " + strings.ToUpper(strings.Join(t, " ")) + " "
+	switch {
+	case is(0, "ALTER", "TABLE"):
+		i := 2
+		for _, optional := range [][]string{{"IF", "EXISTS"}, {"ONLY"}} {
+			if is(i, optional...) {
+				i += len(optional)
+			}
+		}
+		if i >= len(t) {
+			return nil
+		}
+		for _, action := range []string{" TYPE ", " SET LOGGED ", " SET UNLOGGED ", " SET TABLESPACE ", " SET ACCESS METHOD "} {
+			if strings.Contains(rest, action) {
+				return []rewrite{{Table: t[i]}}
+			}
+		}
+	case is(0, "CLUSTER") && len(t) > 1 && !is(1, "VERBOSE"):
+		return []rewrite{{Table: t[1]}}
+	case is(0, "VACUUM", "FULL"):
+		// Comma separated list of tables, VACUUM FULL of the whole database is not estimated
+		var rewrites []rewrite
+		for _, token := range t[2:] {
+			if token != "," && !strings.EqualFold(token, "VERBOSE") && !strings.EqualFold(token, "ANALYZE") {
+				rewrites = append(rewrites, rewrite{Table: token})
+			}
+		}
+		return rewrites
+	case is(0, "REINDEX", "TABLE") && len(t) > 2:
+		name := t[2]
+		if is(2, "CONCURRENTLY") && len(t) > 3 {
+			name = t[3]
+		}
+		return []rewrite{{Table: name, IndexesOnly: true}}
+	}
+	return nil
+}
+
+// checkDiskSpace estimates space needed by rewrites of each pending migration, old copies are removed only
+// on commit, and refuses the run or warns by policy when it exceeds free space of the server less the reserve.
+// In a single transaction all rewrites are held until the end, so they are summed up.
+func checkDiskSpace(db *gorm.DB, c DiskCheck, pending []migrationFile, single bool) error {
+	if !c.Enabled() {
+		return nil
+	}
+	free, err := freeDiskSpace(db, c)
+	if err != nil {
+		return err
+	}
+	reserve, err := parseBytes(c.Reserve)
+	if err != nil {
+		return err
+	}
+	var needed, total int64
+	var worst string
+	for _, m := range pending {
+		if m, err = m.loaded(); err != nil {
+			return err
+		}
+		var size int64
+		for _, stmt := range splitStatements(m.Body) {
+			for _, r := range tableRewrites(stmt.SQL) {
+				function := "pg_total_relation_size"
+				if r.IndexesOnly {
+					function = "pg_indexes_size"
+				}
+				var bytes int64
+				err := db.Raw(fmt.Sprintf("SELECT coalesce(%s(to_regclass(?)), 0)", function), strings.Trim(r.Table, `"`)).Scan(&bytes).Error
+				if err != nil {
+					return fmt.Errorf("can't get size of %s: %w", r.Table, err)
+				}
+				size += bytes
+			}
+		}
+		total += size
+		if size > needed {
+			needed, worst = size, m.Path()
+		}
+	}
+	if single {
+		needed, worst = total, "the run"
+	}
+	if needed == 0 {
+		return nil
+	}
+	logrus.Debugf("%s rewrites %s, free space is ~%s", worst, formatBytes(needed), formatBytes(free))
+	if needed <= free-reserve {
+		return nil
+	}
+	message := fmt.Sprintf("%s rewrites tables of %s, while only ~%s is free", worst, formatBytes(needed), formatBytes(free))
+	if reserve > 0 {
+		message += fmt.Sprintf(" and %s must be left", formatBytes(reserve))
+	}
+	if c.Policy == diskPolicyWarn {
+		logrus.Warn(message)
+		return nil
+	}
+	return fmt.Errorf("%s, migrations are not applied", message)
+}
+
+// freeDiskSpace returns free bytes by the query or estimates them by capacity and sizes of all databases.
+func freeDiskSpace(db *gorm.DB, c DiskCheck) (int64, error) {
+	var free int64
+	if c.FreeQuery != "" {
+		if err := db.Raw(c.FreeQuery).Scan(&free).Error; err != nil {
+			return 0, fmt.Errorf("can't get free disk space: %w", err)
+		}
+		return free, nil
+	}
+	capacity, err := parseBytes(c.Capacity)
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	if err := db.Raw("SELECT coalesce(sum(pg_database_size(datname)), 0)::bigint FROM pg_database").Scan(&used).Error; err != nil {
+		return 0, fmt.Errorf("can't get sizes of databases: %w", err)
+	}
+	return capacity - used, nil
+}
+
+var byteUnits = []string{"B", "kB", "MB", "GB", "TB"}
+
+// parseBytes parses size like "500GB" in units of postgres, which are powers of 1024. Empty size is zero.
+func parseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	upper := strings.ToUpper(s)
+	for i := len(byteUnits) - 1; i >= 0; i-- {
+		number, ok := strings.CutSuffix(upper, strings.ToUpper(byteUnits[i]))
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || value < 0 {
+			break
+		}
+		return int64(value * float64(int64(1)<<(10*i))), nil
+	}
+	return 0, fmt.Errorf("invalid size %q, expected number with unit like 500GB", s)
+}
+
+// formatBytes returns size in the largest unit it has at least one of.
+func formatBytes(n int64) string {
+	value, i := float64(n), 0
+	for ; i < len(byteUnits)-1 && (value >= 1024 || value <= -1024); i++ {
+		value /= 1024
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + byteUnits[i]
+}
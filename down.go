@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rollbackStep is applied migration to roll back with its down file, skipped migration has nothing to revert.
+type rollbackStep struct {
+	applied Migration
+	file    migrationFile
+	down    string
+}
+
+// runDown rolls back the last applied migrations, ones applied after the given one or since the date
+// by their down files in reverse order of applying.
+func runDown(args []string) {
+	flags := flag.NewFlagSet("down", flag.ExitOnError)
+	to := flags.String("to", "", "roll back all migrations applied after the given one, i.e. 0042_add_orders")
+	before := flags.String("before", "", "roll back all migrations applied since the date, i.e. 2024-05-01")
+	last := flags.Int("last", 0, "roll back the given number of the last applied migrations (1 by default)")
+	from := flags.String("from", "", "read down files only from the given source")
+	yes := flags.Bool("yes", false, "roll back without confirmation")
+	dryRun := flags.Bool("dry-run", false, "only print migrations which would be rolled back")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	given := 0
+	for _, set := range []bool{*to != "", *before != "", *last != 0} {
+		if set {
+			given++
+		}
+	}
+	switch {
+	case given > 1:
+		logrus.Fatal("only one of -to, -before and -last flags may be given")
+	case *last < 0:
+		logrus.Fatal("number of migrations given by -last flag must be positive")
+	case given == 0:
+		*last = 1
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	var report *runReport
+	if !*dryRun {
+		report = newReport(config, "down", time.Now())
+	}
+	db := connectDB(config)
+	store := newVersionStore(db)
+	if _, err := store.Lock(true); err != nil {
+		logrus.Fatal(err)
+	}
+	defer unlock(store)
+	applied, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	sortApplied(applied)
+
+	var selected []Migration
+	switch {
+	case *to != "":
+		selected, err = appliedAfter(applied, *to)
+	case *before != "":
+		selected, err = appliedSince(applied, *before)
+	default:
+		selected = applied[max(len(applied)-*last, 0):]
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(selected) == 0 {
+		fmt.Println("Found no one migration to roll back.")
+		report.Finish(statusUpToDate)
+		return
+	}
+
+	l := newLoader(config, *from)
+	steps, err := planRollback(l, store, selected)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	printRollback(config, steps)
+	if *dryRun {
+		return
+	}
+	if !*yes && !confirm(fmt.Sprintf("Roll back %d migrations?", len(steps))) {
+		logrus.Fatal("rollback is not confirmed")
+	}
+	version, err := serverVersion(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	r := &runner{
+		db:       db,
+		store:    store,
+		progress: newProgress(os.Stdout, len(steps), opts.Quiet),
+		verbose:  opts.Verbose || logrus.IsLevelEnabled(logrus.DebugLevel),
+		version:  version,
+		env:      config.Environment,
+		schema:   config.Schema,
+	}
+	rollBack(r, steps, report)
+	report.Finish(statusRolledBack)
+}
+
+// sortApplied orders applied migrations by time of applying.
+func sortApplied(applied []Migration) {
+	sort.SliceStable(applied, func(i, j int) bool {
+		if !applied[i].CreatedAt.Equal(applied[j].CreatedAt) {
+			return applied[i].CreatedAt.Before(applied[j].CreatedAt)
+		}
+		return applied[i].ID < applied[j].ID
+	})
+}
+
+// appliedAfter returns migrations applied after the named one, name may be given without extension
+// and qualified by module and track the same way as in skip config.
+func appliedAfter(applied []Migration, name string) ([]Migration, error) {
+	name = trimMigrationExt(name)
+	found := -1
+	for i, m := range applied {
+		file := migrationFile{Name: m.Name, Module: m.Module, Track: m.Track}
+		if trimMigrationExt(m.Name) != name && trimMigrationExt(file.Path()) != name {
+			continue
+		}
+		if found >= 0 {
+			return nil, fmt.Errorf("migration %s is ambiguous, qualify it by module and track, i.e. %s", name, file.Path())
+		}
+		found = i
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("migration %s is not applied", name)
+	}
+	return applied[found+1:], nil
+}
+
+// appliedSince returns migrations applied at the date or later.
+func appliedSince(applied []Migration, date string) ([]Migration, error) {
+	t, err := parseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range applied {
+		if !m.CreatedAt.Before(t) {
+			return applied[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// planRollback returns steps reverting migrations in reverse order. Down files of all of them are read first,
+// so nothing is touched if any one is missed.
+func planRollback(l *loader, store VersionStore, selected []Migration) ([]rollbackStep, error) {
+	steps := make([]rollbackStep, 0, len(selected))
+	var missed []string
+	for i := len(selected) - 1; i >= 0; i-- {
+		m := selected[i]
+		step := rollbackStep{applied: m, file: migrationFile{Name: m.Name, Module: m.Module, Track: m.Track}}
+		if m.SkipReason == "" {
+			// Directives of applied body like schema and role apply to its down file
+			body, err := appliedBody(store, m)
+			if err != nil {
+				return nil, err
+			}
+			step.file.Body = body
+			down, found, err := l.ReadDown(step.file)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				missed = append(missed, step.file.Path())
+			}
+			step.down = down
+		}
+		steps = append(steps, step)
+	}
+	if len(missed) > 0 {
+		return nil, fmt.Errorf("migrations have no down files, nothing is rolled back: %s", strings.Join(missed, ", "))
+	}
+	return steps, nil
+}
+
+// printRollback prints preview of migrations to roll back in order of rolling back.
+func printRollback(config Config, steps []rollbackStep) {
+	fmt.Printf("Migrations to roll back from %s:\n", config.Target())
+	for _, s := range steps {
+		if s.applied.SkipReason != "" {
+			fmt.Printf(" -  %s (skipped, only its row is removed)\n", s.file.Path())
+			continue
+		}
+		fmt.Printf(" -  %s applied at %s\n", s.file.Path(), s.applied.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// confirm asks user to confirm the action on terminal, without terminal it is not confirmed.
+func confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		logrus.Error("can't ask for confirmation without terminal, pass -yes flag")
+		return false
+	}
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// rollBack executes rollback steps one by one, records them into the report and prints what was undone.
+func rollBack(r *runner, steps []rollbackStep, report *runReport) {
+	for _, s := range steps {
+		r.progress.Start(s.file.Path())
+		started := time.Now()
+		err := r.Rollback(s.file, s.down)
+		report.Add(s.file, started, "", err)
+		if err != nil {
+			r.progress.Fail()
+			logrus.WithError(err).Fatalf("can't roll back migration %s", s.file.Path())
+		}
+		report.RollBack(s.file)
+		r.progress.Done()
+		logrus.Infof("migration %s is rolled back", s.file.Path())
+		events.OnMigrationRolledBack(s.file.Path())
+	}
+	fmt.Println("Has rolled back migrations:")
+	for _, s := range steps {
+		fmt.Println(" - ", s.file.Path())
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// downDraftHeader marks generated down files, they must be reviewed before the migration is committed.
+const downDraftHeader = "-- DRAFT generated from %s, review it before committing: statements without known inverse\n" +
+	"-- are left as TODO comments, data changes are never reverted.\n"
+
+// generateDown returns draft down file reverting statements of up SQL in reverse order. Only common DDL
+// is recognized, the rest is left as TODO comments for a human.
+func generateDown(name, up string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, downDraftHeader, name)
+	statements := splitStatements(up)
+	for i := len(statements) - 1; i >= 0; i-- {
+		stmt := statements[i]
+		inverse := inverseStatements(stmt.SQL)
+		if len(inverse) == 0 {
+			fmt.Fprintf(&b, "\n-- TODO: revert statement at line %d: %s\n", stmt.Line, snippet(stmt.SQL))
+			continue
+		}
+		b.WriteString("\n")
+		for _, s := range inverse {
+			b.WriteString(s + "\n")
+		}
+	}
+	return b.String()
+}
+
+// inverseStatements returns statements reverting DDL statement, none if it is not recognized.
+func inverseStatements(sql string) []string {
+	t := sqlTokens(sql)
+	is := func(i int, words ...string) bool {
+		for j, w := range words {
+			if i+j >= len(t) || !strings.EqualFold(t[i+j], w) {
+				return false
+			}
+		}
+		return true
+	}
+	// skip returns position after optional words at i
+	skip := func(i int, optional ...string) int {
+		for _, w := range optional {
+			if words := strings.Fields(w); is(i, words...) {
+				i += len(words)
+			}
+		}
+		return i
+	}
+	at := func(i int) string {
+		if i < len(t) {
+			return t[i]
+		}
+		return ""
+	}
+	// drop returns DROP statement of object created by CREATE <kind> statement with name at i
+	drop := func(kind string, i int) []string {
+		if name := at(skip(i, "IF NOT EXISTS")); name != "" {
+			return []string{fmt.Sprintf("DROP %s IF EXISTS %s;", kind, name)}
+		}
+		return nil
+	}
+
+	replaced := "-- TODO: previous definition is replaced, restore it instead of dropping"
+	switch {
+	case is(0, "CREATE", "TABLE"), is(0, "CREATE", "UNLOGGED", "TABLE"):
+		return drop("TABLE", skip(1, "UNLOGGED")+1)
+	case is(0, "CREATE", "SCHEMA"):
+		return drop("SCHEMA", 2)
+	case is(0, "CREATE", "SEQUENCE"):
+		return drop("SEQUENCE", 2)
+	case is(0, "CREATE", "TYPE"):
+		return drop("TYPE", 2)
+	case is(0, "CREATE", "EXTENSION"):
+		return drop("EXTENSION", 2)
+	case is(0, "CREATE", "MATERIALIZED", "VIEW"):
+		return drop("MATERIALIZED VIEW", 3)
+	case is(0, "CREATE", "VIEW"):
+		return drop("VIEW", 2)
+	case is(0, "CREATE", "OR", "REPLACE", "VIEW"):
+		return append([]string{replaced}, drop("VIEW", 4)...)
+	case is(0, "CREATE", "FUNCTION"), is(0, "CREATE", "PROCEDURE"):
+		return drop(strings.ToUpper(t[1]), 2)
+	case is(0, "CREATE", "OR", "REPLACE", "FUNCTION"), is(0, "CREATE", "OR", "REPLACE", "PROCEDURE"):
+		return append([]string{replaced}, drop(strings.ToUpper(t[3]), 4)...)
+	case is(0, "CREATE", "INDEX"), is(0, "CREATE", "UNIQUE", "INDEX"):
+		// Down file is executed in a transaction, so index is dropped without CONCURRENTLY
+		i := skip(skip(1, "UNIQUE")+1, "CONCURRENTLY", "IF NOT EXISTS")
+		index := at(i)
+		if index == "" || strings.EqualFold(index, "ON") {
+			// Name of unnamed index is chosen by server
+			return nil
+		}
+		for j := i; j < len(t); j++ {
+			if is(j, "ON") {
+				// Index is created in the schema of its table
+				if table := at(skip(j+1, "ONLY")); strings.Contains(table, ".") && !strings.Contains(index, ".") {
+					index = table[:strings.LastIndex(table, ".")+1] + index
+				}
+				break
+			}
+		}
+		return []string{fmt.Sprintf("DROP INDEX IF EXISTS %s;", index)}
+	case is(0, "CREATE", "TRIGGER"), is(0, "CREATE", "OR", "REPLACE", "TRIGGER"):
+		i := skip(1, "OR REPLACE") + 1
+		for j := i; j < len(t); j++ {
+			if is(j, "ON") && at(i) != "" && at(j+1) != "" {
+				return []string{fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s;", at(i), at(j+1))}
+			}
+		}
+	case is(0, "ALTER", "TABLE"):
+		i := skip(2, "IF EXISTS", "ONLY")
+		table := at(i)
+		if table == "" {
+			return nil
+		}
+		var inverse []string
+		for _, action := range splitActions(t[i+1:]) {
+			s := inverseAction(table, action)
+			if s == "" {
+				return nil
+			}
+			inverse = append(inverse, s)
+		}
+		// Actions are reverted in reverse order too
+		for l, r := 0, len(inverse)-1; l < r; l, r = l+1, r-1 {
+			inverse[l], inverse[r] = inverse[r], inverse[l]
+		}
+		return inverse
+	}
+	return nil
+}
+
+// inverseAction returns statement reverting single action of ALTER TABLE, empty if it is not recognized.
+func inverseAction(table string, a []string) string {
+	is := func(i int, words ...string) bool {
+		for j, w := range words {
+			if i+j >= len(a) || !strings.EqualFold(a[i+j], w) {
+				return false
+			}
+		}
+		return true
+	}
+	at := func(i int) string {
+		if i < len(a) {
+			return a[i]
+		}
+		return ""
+	}
+	alter := func(format string, args ...any) string {
+		return fmt.Sprintf("ALTER TABLE %s %s;", table, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case is(0, "ADD", "CONSTRAINT") && at(2) != "":
+		return alter("DROP CONSTRAINT IF EXISTS %s", at(2))
+	case is(0, "ADD", "COLUMN", "IF", "NOT", "EXISTS") && at(5) != "":
+		return alter("DROP COLUMN IF EXISTS %s", at(5))
+	case is(0, "ADD", "COLUMN") && at(2) != "":
+		return alter("DROP COLUMN IF EXISTS %s", at(2))
+	case is(0, "ADD") && at(1) != "" && !is(1, "PRIMARY") && !is(1, "UNIQUE") && !is(1, "CHECK") &&
+		!is(1, "FOREIGN") && !is(1, "EXCLUDE"):
+		return alter("DROP COLUMN IF EXISTS %s", at(1))
+	case is(0, "RENAME", "COLUMN") && is(3, "TO") && at(4) != "":
+		return alter("RENAME COLUMN %s TO %s", at(4), at(2))
+	case is(0, "RENAME", "TO") && at(2) != "":
+		// New name is in the schema of the table
+		renamed := at(2)
+		if i := strings.LastIndex(table, "."); i >= 0 {
+			renamed = table[:i+1] + renamed
+		}
+		name := table[strings.LastIndex(table, ".")+1:]
+		return fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", renamed, name)
+	case is(0, "ALTER", "COLUMN") && is(3, "SET", "NOT", "NULL"):
+		return alter("ALTER COLUMN %s DROP NOT NULL", at(2))
+	case is(0, "ALTER") && is(2, "SET", "NOT", "NULL"):
+		return alter("ALTER COLUMN %s DROP NOT NULL", at(1))
+	case is(0, "ALTER", "COLUMN") && is(3, "DROP", "NOT", "NULL"):
+		return alter("ALTER COLUMN %s SET NOT NULL", at(2))
+	case is(0, "ENABLE", "ROW", "LEVEL", "SECURITY"):
+		return alter("DISABLE ROW LEVEL SECURITY")
+	}
+	return ""
+}
+
+// splitActions splits tokens of ALTER TABLE actions by top level commas.
+func splitActions(t []string) [][]string {
+	var actions [][]string
+	depth, start := 0, 0
+	for i, token := range t {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",", ";":
+			if depth == 0 {
+				if i > start {
+					actions = append(actions, t[start:i])
+				}
+				start = i + 1
+			}
+		}
+	}
+	if start < len(t) {
+		actions = append(actions, t[start:])
+	}
+	return actions
+}
+
+// sqlTokens returns tokens of canonical SQL with qualified names like "app" .orders joined into one.
+func sqlTokens(sql string) []string {
+	var tokens []string
+	for _, token := range strings.Fields(canonicalSQL(sql)) {
+		if n := len(tokens); n > 0 && (strings.HasPrefix(token, ".") || strings.HasSuffix(tokens[n-1], ".")) {
+			tokens[n-1] += token
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var createIndex = regexp.MustCompile(`(?i)\bCREATE\s+(UNIQUE\s+)?INDEX\b`)
+
+// migrationKind groups migrations expected to take similar time: batched, index, data or schema ones.
+func migrationKind(body, track string) string {
+	if _, ok := findDirective(parseDirectives(body), batchedDirective); ok {
+		return "batched"
+	}
+	switch {
+	case createIndex.MatchString(body):
+		return "index"
+	case track == trackData:
+		return "data"
+	}
+	return "schema"
+}
+
+type durationSample struct {
+	Size     int64
+	Duration time.Duration
+}
+
+// estimator predicts duration of pending migrations by durations of applied ones of the same kind and similar size.
+type estimator map[string][]durationSample
+
+func newEstimator(applied []Migration) estimator {
+	e := make(estimator)
+	for _, m := range applied {
+		// Durations are not known for migrations applied by older versions
+		if m.SkipReason != "" || m.DurationMs == 0 {
+			continue
+		}
+		kind, size := m.Kind, m.Size
+		if kind == "" {
+			// Rows recorded by older versions are classified by body, which is listed only if they have no checksum
+			if m.Body == "" {
+				continue
+			}
+			kind, size = migrationKind(m.Body, m.Track), int64(len(m.Body))
+		}
+		e[kind] = append(e[kind], durationSample{Size: size, Duration: time.Duration(m.DurationMs) * time.Millisecond})
+	}
+	return e
+}
+
+// Estimate returns median duration of similar migrations and their number, zero number means there is no history.
+// Migrations of size from half to double of the given one are similar, all of the kind are used if there are no such.
+func (e estimator) Estimate(m migrationFile) (time.Duration, int) {
+	samples := e[migrationKind(m.Body, m.Track)]
+	size := m.Size()
+	var similar []time.Duration
+	for _, s := range samples {
+		if s.Size*2 >= size && s.Size <= size*2 {
+			similar = append(similar, s.Duration)
+		}
+	}
+	if len(similar) == 0 {
+		for _, s := range samples {
+			similar = append(similar, s.Duration)
+		}
+	}
+	if len(similar) == 0 {
+		return 0, 0
+	}
+	sort.Slice(similar, func(i, j int) bool { return similar[i] < similar[j] })
+	return similar[len(similar)/2], len(similar)
+}
+
+// Format returns human readable estimate of migration, i.e. "~1.2s (index, 12 similar)".
+func (e estimator) Format(m migrationFile) string {
+	d, n := e.Estimate(m)
+	kind := migrationKind(m.Body, m.Track)
+	if n == 0 {
+		return fmt.Sprintf("unknown (%s, no history)", kind)
+	}
+	return fmt.Sprintf("~%s (%s, %d similar)", roundDuration(d), kind, n)
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(100 * time.Millisecond)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"migrator/extend"
+)
+
+// Events are notified as up command goes on, see extend.Events.
+type Events = extend.Events
+
+// NopEvents ignores all events.
+type NopEvents = extend.NopEvents
+
+// events are notified by up command, the handler is given to extend.SetEvents in init function of a package
+// imported by migrator.
+var events = extend.EventsHandler()
+
+// multiEvents notifies all events in order.
+type multiEvents []Events
+
+func (m multiEvents) OnStart(pending []string) {
+	for _, e := range m {
+		e.OnStart(pending)
+	}
+}
+
+func (m multiEvents) OnMigrationApplied(name string, skipReason string, duration time.Duration) {
+	for _, e := range m {
+		e.OnMigrationApplied(name, skipReason, duration)
+	}
+}
+
+func (m multiEvents) OnMigrationRolledBack(name string) {
+	for _, e := range m {
+		e.OnMigrationRolledBack(name)
+	}
+}
+
+func (m multiEvents) OnChecksumMismatch(name string) {
+	for _, e := range m {
+		e.OnChecksumMismatch(name)
+	}
+}
+
+func (m multiEvents) OnFinish(status string, err error) {
+	for _, e := range m {
+		e.OnFinish(status, err)
+	}
+}
+
+// eventsRun notifies OnFinish once, including the case of fatal error.
+type eventsRun struct {
+	err  error
+	once sync.Once
+}
+
+func newEventsRun() *eventsRun {
+	r := &eventsRun{}
+	logrus.AddHook(r)
+	logrus.RegisterExitHandler(func() { r.Finish(statusFailed) })
+	return r
+}
+
+// Finish notifies the end of run with the status.
+func (r *eventsRun) Finish(status string) {
+	r.once.Do(func() { events.OnFinish(status, r.err) })
+}
+
+// Levels implements logrus.Hook, fatal errors are passed to OnFinish.
+func (r *eventsRun) Levels() []logrus.Level {
+	return []logrus.Level{logrus.FatalLevel}
+}
+
+// Fire implements logrus.Hook.
+func (r *eventsRun) Fire(entry *logrus.Entry) error {
+	msg := entry.Message
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		msg += ": " + err.Error()
+	}
+	r.err = errors.New(msg)
+	return nil
+}
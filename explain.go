@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const explainSavepoint = "migrator_explain"
+
+// explainedStatement is estimate of the planner for data statement of migration.
+type explainedStatement struct {
+	Line      int    `json:"line"`
+	Statement string `json:"statement"`
+	Table     string `json:"table,omitempty"`
+	Rows      int64  `json:"rows"`
+	// Statement reads the whole table, i.e. UPDATE without WHERE
+	FullScan bool   `json:"fullScan,omitempty"`
+	Error    string `json:"error,omitempty"` // statement can't be explained, i.e. table is created by pending migration
+}
+
+// explainNode is a node of plan returned by EXPLAIN (FORMAT JSON).
+type explainNode struct {
+	NodeType string        `json:"Node Type"`
+	Relation string        `json:"Relation Name"`
+	Rows     float64       `json:"Plan Rows"`
+	Filter   string        `json:"Filter"`
+	Plans    []explainNode `json:"Plans"`
+}
+
+// isDataStatement reports whether statement updates or deletes rows.
+func isDataStatement(sql string) bool {
+	t := strings.Fields(canonicalSQL(sql))
+	return len(t) > 0 && (strings.EqualFold(t[0], "UPDATE") || strings.EqualFold(t[0], "DELETE"))
+}
+
+// explainMigration runs EXPLAIN on UPDATE and DELETE statements of migration without executing them.
+// Statements which can't be explained are reported with the error, as they often depend on pending migrations.
+// tx must be a transaction, session settings of the migration are restored after.
+func explainMigration(tx *gorm.DB, r *runner, m migrationFile) ([]explainedStatement, error) {
+	var result []explainedStatement
+	for _, stmt := range splitStatements(m.Body) {
+		if !isDataStatement(stmt.SQL) {
+			continue
+		}
+		e := explainedStatement{Line: stmt.Line, Statement: snippet(stmt.SQL)}
+		if err := tx.SavePoint(explainSavepoint).Error; err != nil {
+			return nil, err
+		}
+		restore, err := r.setSession(tx, m)
+		if err != nil {
+			return nil, err
+		}
+		var out string
+		if err := tx.Raw("EXPLAIN (FORMAT JSON) " + stmt.SQL).Row().Scan(&out); err != nil {
+			e.Error = err.Error()
+			if err := tx.RollbackTo(explainSavepoint).Error; err != nil {
+				return nil, err
+			}
+			result = append(result, e)
+			continue
+		}
+		if err := restore(); err != nil {
+			return nil, err
+		}
+		var plans []struct{ Plan explainNode }
+		if err := json.Unmarshal([]byte(out), &plans); err != nil || len(plans) == 0 {
+			return nil, fmt.Errorf("can't decode plan of statement at line %d of %s: %v", stmt.Line, m.Path(), err)
+		}
+		e.Table, e.Rows, e.FullScan = modifiedRows(plans[0].Plan)
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// modifiedRows returns table modified by the plan, estimated number of modified rows and whether the table
+// is scanned without filter.
+func modifiedRows(n explainNode) (string, int64, bool) {
+	if n.NodeType != "ModifyTable" {
+		for _, child := range n.Plans {
+			if table, rows, full := modifiedRows(child); table != "" {
+				return table, rows, full
+			}
+		}
+		return "", int64(n.Rows), false
+	}
+	var rows float64
+	if len(n.Plans) > 0 {
+		rows = n.Plans[0].Rows
+	}
+	var full func(explainNode) bool
+	full = func(c explainNode) bool {
+		if c.NodeType == "Seq Scan" && c.Relation == n.Relation && c.Filter == "" {
+			return true
+		}
+		for _, p := range c.Plans {
+			if full(p) {
+				return true
+			}
+		}
+		return false
+	}
+	return n.Relation, int64(rows), full(n)
+}
+
+// describeExplained returns estimate of statement in human readable form.
+func describeExplained(e explainedStatement) string {
+	switch {
+	case e.Error != "":
+		return fmt.Sprintf("line %d: can't explain: %s", e.Line, e.Error)
+	case e.FullScan:
+		return fmt.Sprintf("line %d: ~%d rows of %s, FULL TABLE", e.Line, e.Rows, e.Table)
+	}
+	return fmt.Sprintf("line %d: ~%d rows of %s", e.Line, e.Rows, e.Table)
+}
+
+// printExplain prints estimated rows of data statements of pending migrations, nothing is executed.
+func printExplain(db *gorm.DB, r *runner, pending []migrationFile) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer tx.Rollback()
+	fmt.Println("Data statements of pending migrations:")
+	found := false
+	for _, m := range pending {
+		m, err := m.loaded()
+		if err != nil {
+			return err
+		}
+		explained, err := explainMigration(tx, r, m)
+		if err != nil {
+			return err
+		}
+		for _, e := range explained {
+			found = true
+			fmt.Printf(" -  %s:%s\n", m.Path(), strings.TrimPrefix(describeExplained(e), "line "))
+		}
+	}
+	if !found {
+		fmt.Println(" -  no UPDATE or DELETE statements")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runExportApplied writes bodies of applied migrations kept in tracking table into migrations directory,
+// i.e. when original files are lost or to start a repository from a legacy database. Migrations whose bodies
+// are not stored or don't match their checksums, like headers of streamed ones, are not exported. CSV files
+// of copy directives are not stored, they are exported from sources of migrations if their checksums match.
+func runExportApplied(args []string) {
+	flags := flag.NewFlagSet("export-applied", flag.ExitOnError)
+	dir := flags.String("dir", "migrations-export", "directory to write migration files into, existing files are not overwritten")
+	from := flags.String("from", "", "read CSV files of copy directives only from the given source")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	config := initConfig(configPath())
+	opts.Setup()
+	db := connectDB(config)
+	store := newVersionStore(db)
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	checksums := newChecksummer(config.Checksum, config.Normalization)
+	l := newLoader(config, *from)
+
+	var missed []string
+	exported := 0
+	written := make(map[string]bool) // copy files shared by migrations
+	for _, m := range all {
+		path := migrationFile{Name: m.Name, Track: m.Track, Module: m.Module}.Path()
+		body, err := appliedBody(store, m)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if body == "" {
+			logrus.Warnf("body of migration %s isn't stored", path)
+			missed = append(missed, path)
+			continue
+		}
+		if ok, err := matchesApplied(m, migrationFile{Body: body, checksums: checksums}); err != nil || !ok {
+			logrus.Warnf("stored body of migration %s doesn't match its checksum", path)
+			missed = append(missed, path)
+			continue
+		}
+		body, copies, err := exportCopies(l, body)
+		if err != nil {
+			logrus.WithError(err).Warnf("data of migration %s isn't exported", path)
+			missed = append(missed, path)
+			continue
+		}
+		content := []byte(body)
+		if strings.HasSuffix(m.Name, gzipExt) {
+			if content, err = compress(content); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		file := filepath.Join(*dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := writeNewFile(file, string(content)); err != nil {
+			logrus.WithError(err).Fatalf("can't write migration %s", path)
+		}
+		for name, data := range copies {
+			if written[name] {
+				continue
+			}
+			file := filepath.Join(*dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				logrus.Fatal(err)
+			}
+			if err := writeNewFile(file, string(data)); err != nil {
+				logrus.WithError(err).Fatalf("can't write copy file %s of migration %s", name, path)
+			}
+			written[name] = true
+		}
+		exported++
+	}
+	fmt.Printf("Has exported %d applied migrations into %s.\n", exported, *dir)
+	if len(missed) > 0 {
+		logrus.Fatalf("%d migrations are not exported: %s", len(missed), strings.Join(missed, ", "))
+	}
+}
+
+// exportCopies returns body of migration file without checksums appended to its copy directives on loading
+// and CSV files of the directives read from sources and verified by the checksums.
+func exportCopies(l *loader, body string) (string, map[string][]byte, error) {
+	directives := copyDirectives(body)
+	if len(directives) == 0 {
+		return body, nil, nil
+	}
+	if len(l.sources) == 0 {
+		return "", nil, fmt.Errorf("no sources of copy files are configured")
+	}
+	lines := strings.Split(body, "\n")
+	copies := make(map[string][]byte, len(directives))
+	for _, d := range directives {
+		o, err := parseCopyOptions(d)
+		if err != nil {
+			return "", nil, err
+		}
+		stored := d.Params()["sha256"]
+		if stored == "" {
+			return "", nil, fmt.Errorf("checksum of copy file %s at line %d isn't stored", o.File, d.Line)
+		}
+		_, data, err := l.readFragment(l.sources[0], o.File)
+		if err != nil {
+			return "", nil, fmt.Errorf("can't read copy file %s at line %d: %w", o.File, d.Line, err)
+		}
+		if sum := sha256.Sum256([]byte(data)); hex.EncodeToString(sum[:]) != stored {
+			return "", nil, fmt.Errorf("copy file %s at line %d differs from the applied one", o.File, d.Line)
+		}
+		copies[o.File] = []byte(data)
+		lines[d.Line-1] = strings.Replace(lines[d.Line-1], " sha256="+stored, "", 1)
+	}
+	return strings.Join(lines, "\n"), copies, nil
+}
+
+// compress returns gzipped content of migration file.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
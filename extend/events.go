@@ -0,0 +1,39 @@
+package extend
+
+import "time"
+
+// Events are notified as up command goes on, so application embedding migrator may update
+// its own metrics, locks or feature flags. Migrations are identified by their paths, i.e. "billing/schema/0002_accounts.sql".
+type Events interface {
+	// OnStart is called when pending migrations are found, the list may be empty.
+	OnStart(pending []string)
+	// OnMigrationApplied is called after migration is applied or recorded as skipped for the reason.
+	OnMigrationApplied(name string, skipReason string, duration time.Duration)
+	// OnMigrationRolledBack is called after migration applied in the failed run is reverted by its down file.
+	OnMigrationRolledBack(name string)
+	// OnChecksumMismatch is called when applied migration differs from its file, the run fails then.
+	OnChecksumMismatch(name string)
+	// OnFinish is called once when run is over with its status, err is set if run has failed.
+	OnFinish(status string, err error)
+}
+
+// NopEvents ignores all events, custom implementations may embed it to handle only some of them.
+type NopEvents struct{}
+
+func (NopEvents) OnStart([]string)                                 {}
+func (NopEvents) OnMigrationApplied(string, string, time.Duration) {}
+func (NopEvents) OnMigrationRolledBack(string)                     {}
+func (NopEvents) OnChecksumMismatch(string)                        {}
+func (NopEvents) OnFinish(string, error)                           {}
+
+var events Events = NopEvents{}
+
+// SetEvents sets handler of events of up command.
+func SetEvents(e Events) {
+	events = e
+}
+
+// EventsHandler returns handler given to SetEvents, NopEvents by default.
+func EventsHandler() Events {
+	return events
+}
@@ -0,0 +1,70 @@
+// Package extend plugs custom implementations into migrator binary built with them. They are registered
+// in init function of a package imported by a file of migrator main package, i.e. next to models.go:
+//
+//	import _ "example.com/app/migratorext"
+package extend
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a row of tracking table.
+type Migration struct {
+	ID        int
+	CreatedAt time.Time
+	Name      string
+	Body      string
+	Track     string `gorm:"not null;default:''"`
+	Module    string `gorm:"not null;default:''"`
+	// Migration is recorded without execution when it is not applicable, for example to server version
+	SkipReason string `gorm:"not null;default:''"`
+	DurationMs int64  `gorm:"not null;default:0"`
+	Checksum   string `gorm:"not null;default:''"` // checksum of body by ChecksumAlgorithm
+	// Fields of metadata header of migration file
+	Author      string `gorm:"not null;default:''"`
+	Ticket      string `gorm:"not null;default:''"`
+	Description string `gorm:"not null;default:''"`
+	// Commit migration is applied from and author of the commit which has added its file
+	GitSHA    string `gorm:"not null;default:''"`
+	GitAuthor string `gorm:"not null;default:''"`
+	// Kind and size of body estimate durations of similar migrations, as bodies are not listed
+	Kind string `gorm:"not null;default:''"`
+	Size int64  `gorm:"not null;default:0"`
+	// Scheme of checksum like "sha256" or "xxhash+normalized", empty for sha256 of rows recorded by older versions
+	ChecksumAlgorithm string `gorm:"not null;default:''"`
+	// Compression of body like "zstd", empty for plain text
+	BodyEncoding string `gorm:"not null;default:''"`
+	// Schema of tenant migration is applied to in tenants mode, empty otherwise
+	Tenant string `gorm:"not null;default:''"`
+	// Version of migrator which has recorded the migration, empty for rows of older versions
+	MigratorVersion string `gorm:"not null;default:''"`
+}
+
+// VersionStore keeps history of applied migrations. Default one is the tracking table of the target database,
+// another one (i.e. in a different database or a schema registry) may be plugged by SetVersionStore.
+type VersionStore interface {
+	// List returns all applied and skipped migrations ordered by module, track and name.
+	List() ([]Migration, error)
+	// Record saves migration. Tx is transaction of the target database migration is applied in,
+	// store kept in the same database records migration in it, so both are committed atomically.
+	Record(tx *gorm.DB, m *Migration) error
+	// Remove deletes record of rolled back or squashed migration, identified by module, track and name.
+	Remove(tx *gorm.DB, m Migration) error
+	// Lock serializes concurrent runs, it is held until the process exits. Without wait it reports
+	// whether the lock is acquired instead of waiting for it.
+	Lock(wait bool) (bool, error)
+}
+
+var newVersionStore func(db *gorm.DB) VersionStore
+
+// SetVersionStore replaces the tracking table by store returned by the factory for the target database.
+func SetVersionStore(factory func(db *gorm.DB) VersionStore) {
+	newVersionStore = factory
+}
+
+// VersionStoreFactory returns factory given to SetVersionStore, nil if the default store is used.
+func VersionStoreFactory() func(db *gorm.DB) VersionStore {
+	return newVersionStore
+}
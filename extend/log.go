@@ -0,0 +1,16 @@
+package extend
+
+import "log/slog"
+
+var logHandler slog.Handler
+
+// SetLogHandler routes log records of migrator into the handler instead of its own output, i.e. into logging
+// stack of the application. Fields like migration name and duration are passed as attributes.
+func SetLogHandler(h slog.Handler) {
+	logHandler = h
+}
+
+// LogHandler returns handler given to SetLogHandler, nil if migrator writes its own output.
+func LogHandler() slog.Handler {
+	return logHandler
+}
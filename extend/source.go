@@ -0,0 +1,24 @@
+package extend
+
+// Source provides migration files. Layout of each source is the same as of migrations dir:
+// files of modules and tracks are placed into subdirectories.
+type Source interface {
+	// List returns names of files (not subdirectories) of the directory given relative to source root,
+	// error wrapping fs.ErrNotExist is returned if there is no such directory.
+	List(dir string) ([]string, error)
+	// Read returns content of the file, error wraps fs.ErrNotExist if there is no such file.
+	Read(name string) ([]byte, error)
+}
+
+var sourceSchemes = map[string]func(location string) (Source, error){}
+
+// RegisterSource registers factory of custom sources by URL scheme, i.e. company artifact store or encrypted bundles.
+// Registered schemes take precedence over built-in ones.
+func RegisterSource(scheme string, factory func(location string) (Source, error)) {
+	sourceSchemes[scheme] = factory
+}
+
+// SourceFactory returns factory of sources registered for the scheme, nil if there is no one.
+func SourceFactory(scheme string) func(location string) (Source, error) {
+	return sourceSchemes[scheme]
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// requiresExtensionDirective declares extension migration depends on, i.e. "-- migrator:requiresExtension postgis>=3.3".
+// Missed extension fails migration before its execution, unless "create" is given after requirement:
+// then it is created if the server allows it.
+const requiresExtensionDirective = "requiresExtension"
+
+var extensionRequirement = regexp.MustCompile(`^([\w-]+)(?:(>=|=)([\w.]+))?$`)
+
+// checkExtensions verifies extensions required by migration, creating missed ones when allowed.
+func checkExtensions(db *gorm.DB, m migrationFile) error {
+	for _, d := range parseDirectives(m.Body) {
+		if d.Name != requiresExtensionDirective {
+			continue
+		}
+		args := strings.Fields(d.Args)
+		var match []string
+		if len(args) > 0 {
+			match = extensionRequirement.FindStringSubmatch(args[0])
+		}
+		if match == nil || len(args) > 2 || len(args) == 2 && args[1] != "create" {
+			return fmt.Errorf("invalid %s directive at line %d, expected: %s <name>[>=<version>] [create]", d.Name, d.Line, d.Name)
+		}
+		name, op, required := match[1], match[2], match[3]
+
+		var installed string
+		if err := db.Raw("SELECT extversion FROM pg_extension WHERE extname = ?", name).Scan(&installed).Error; err != nil {
+			return fmt.Errorf("can't get version of extension %s: %w", name, err)
+		}
+		if installed == "" {
+			if len(args) == 1 {
+				return fmt.Errorf("migration requires extension %s (line %d), create it or add \"create\" to the directive", name, d.Line)
+			}
+			if err := createExtension(db, name, op, required); err != nil {
+				return err
+			}
+			continue
+		}
+		if op == "" {
+			continue
+		}
+		cmp := compareVersions(flywayVersion(installed), flywayVersion(required))
+		if op == "=" && cmp != 0 || op == ">=" && cmp < 0 {
+			return fmt.Errorf("migration requires extension %s%s%s (line %d), but version %s is installed, "+
+				"update it by ALTER EXTENSION %s UPDATE", name, op, required, d.Line, installed, name)
+		}
+	}
+	return nil
+}
+
+// createExtension creates extension of default version after checking the server provides it.
+func createExtension(db *gorm.DB, name, op, required string) error {
+	var available string
+	if err := db.Raw("SELECT default_version FROM pg_available_extensions WHERE name = ?", name).Scan(&available).Error; err != nil {
+		return fmt.Errorf("can't get available version of extension %s: %w", name, err)
+	}
+	if available == "" {
+		return fmt.Errorf("extension %s is not available on the server, install it or ask the provider to allow it", name)
+	}
+	cmp := compareVersions(flywayVersion(available), flywayVersion(required))
+	if op == "=" && cmp != 0 || op == ">=" && cmp < 0 {
+		return fmt.Errorf("extension %s%s%s is required, but the server provides version %s", name, op, required, available)
+	}
+	if err := db.Exec(fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %q", name)).Error; err != nil {
+		return fmt.Errorf("can't create extension %s (it may be not allowed on managed service): %w", name, err)
+	}
+	return nil
+}
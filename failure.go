@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Failure policies controlling what happens to the run when a migration fails.
+const (
+	// failureAbort stops the run at the first failure
+	failureAbort = "abort"
+	// failureNextTarget skips the rest of the failed module and goes on with other modules
+	failureNextTarget = "next-target"
+	// failureContinue skips only later migrations of the failed module track and ones requiring skipped migrations
+	failureContinue = "continue"
+)
+
+// failures tracks migrations failed in the run and ones blocked by them according to policy.
+type failures struct {
+	policy  string
+	failed  []string
+	blocked map[string]bool // modules, tracks and names of migrations which can't be applied
+}
+
+func newFailures(policy string) *failures {
+	if policy == "" {
+		policy = failureAbort
+	}
+	return &failures{policy: policy, blocked: make(map[string]bool)}
+}
+
+// Add records failed migration, it reports whether the run may go on.
+func (f *failures) Add(m migrationFile) bool {
+	f.failed = append(f.failed, m.Path())
+	f.block(m)
+	return f.policy != failureAbort
+}
+
+// Blocked returns reason why migration can't be applied because of earlier failures, empty if it can.
+func (f *failures) Blocked(m migrationFile) string {
+	if len(f.failed) == 0 {
+		return ""
+	}
+	var reason string
+	switch {
+	case f.policy == failureNextTarget && f.blocked["module:"+m.Module]:
+		reason = "earlier migration of the module has failed"
+	case f.policy == failureContinue && f.blocked["track:"+m.Module+"/"+m.Track]:
+		reason = "earlier migration of the track has failed"
+	case f.policy == failureContinue:
+		for _, d := range parseDirectives(m.Body) {
+			if d.Name != requiresDirective {
+				continue
+			}
+			for _, required := range strings.Fields(d.Args) {
+				if f.blocked["name:"+trimMigrationExt(required)] {
+					reason = fmt.Sprintf("required %s is not applied because of failure", required)
+				}
+			}
+		}
+	}
+	if reason != "" {
+		f.block(m)
+	}
+	return reason
+}
+
+func (f *failures) block(m migrationFile) {
+	f.blocked["module:"+m.Module] = true
+	f.blocked["track:"+m.Module+"/"+m.Track] = true
+	f.blocked["name:"+trimMigrationExt(m.Name)] = true
+	f.blocked["name:"+trimMigrationExt(m.Path())] = true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// healthServer reports state of the run over HTTP, so probes and dependent jobs may wait for
+// migrations to complete instead of parsing logs.
+type healthServer struct {
+	db       *gorm.DB // own connection, the main one is busy while migrations are applied
+	complete atomic.Bool
+}
+
+type healthState struct {
+	Status   string `json:"status"` // migrating or complete
+	Database string `json:"database"`
+}
+
+// startHealthServer listens on addr: /healthz answers while the process is alive, /readyz answers
+// with 200 only when migrations are complete and the database is reachable.
+func startHealthServer(addr string, config Config) *healthServer {
+	h := &healthServer{db: openDB(config)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", h.ready)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Fatal("can't serve health endpoint")
+		}
+	}()
+	return h
+}
+
+func (h *healthServer) ready(w http.ResponseWriter, r *http.Request) {
+	state := healthState{Status: "migrating", Database: "reachable"}
+	code := http.StatusServiceUnavailable
+	if h.complete.Load() {
+		state.Status, code = "complete", http.StatusOK
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if sqlDB, err := h.db.DB(); err != nil {
+		state.Database, code = "unreachable: "+err.Error(), http.StatusServiceUnavailable
+	} else if err := sqlDB.PingContext(ctx); err != nil {
+		state.Database, code = "unreachable: "+err.Error(), http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+// Complete marks migrations as complete and serves until the process is interrupted or terminated.
+func (h *healthServer) Complete() {
+	h.complete.Store(true)
+	logrus.Info("migrations are complete, serving health endpoint until termination")
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// historyEntry is an applied migration exported by history command.
+type historyEntry struct {
+	Name       string    `json:"name"`
+	AppliedAt  time.Time `json:"appliedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Checksum   string    `json:"checksum"`
+	SkipReason string    `json:"skipReason,omitempty"`
+}
+
+// runHistory exports history of applied migrations for audits.
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	format := flags.String("format", "csv", "output format: csv or json")
+	since := flags.String("since", "", "export migrations applied since the date, i.e. 2024-01-01 or RFC 3339 timestamp")
+	until := flags.String("until", "", "export migrations applied before the date")
+	output := flags.String("output", "", "write history into the file instead of stdout")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if *format != "csv" && *format != "json" {
+		logrus.Fatalf("unknown format %q, available values: csv, json", *format)
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	db := connectDB(config)
+
+	// Bodies are not selected as they may be huge, checksums are calculated from them only for old rows
+	query := db.Select("id", "created_at", "name", "track", "module", "duration_ms", "checksum", "skip_reason").
+		Order("created_at, id")
+	for _, bound := range []struct {
+		value string
+		cond  string
+	}{{*since, "created_at >= ?"}, {*until, "created_at < ?"}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := parseDate(bound.value)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid date range")
+		}
+		query = query.Where(bound.cond, t)
+	}
+	var applied []Migration
+	if err := query.Find(&applied).Error; err != nil {
+		logrus.WithError(err).Fatal("can't get applied migrations")
+	}
+
+	entries := make([]historyEntry, len(applied))
+	for i, m := range applied {
+		if m.Checksum == "" {
+			var body string
+			if err := db.Model(&Migration{}).Where("id = ?", m.ID).Pluck("body", &body).Error; err != nil {
+				logrus.WithError(err).Fatal("can't get migration body")
+			}
+			m.Checksum = checksum(body)
+		}
+		file := migrationFile{Name: m.Name, Track: m.Track, Module: m.Module}
+		entries[i] = historyEntry{
+			Name:       file.Path(),
+			AppliedAt:  m.CreatedAt,
+			DurationMs: m.DurationMs,
+			Checksum:   m.Checksum,
+			SkipReason: m.SkipReason,
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			logrus.WithError(err).Fatal("can't create output file")
+		}
+		defer file.Close()
+		out = file
+	}
+	var err error
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	} else {
+		err = writeHistoryCSV(out, entries)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("can't write history")
+	}
+}
+
+func writeHistoryCSV(out io.Writer, entries []historyEntry) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"name", "applied_at", "duration_ms", "checksum", "skip_reason"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{e.Name, e.AppliedAt.Format(time.RFC3339), strconv.FormatInt(e.DurationMs, 10), e.Checksum, e.SkipReason}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseDate parses date like 2024-01-01 in local time zone or RFC 3339 timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("can't parse %q as date or RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"gorm.io/gorm"
+)
+
+// Hook is SQL snippet or shell command executed around the run or each migration.
+type Hook struct {
+	SQL     string `yaml:"sql"     binding:"required_without=Command,excluded_with=Command"`
+	Command string `yaml:"command"`
+}
+
+// Hooks are executed in order they are listed, failure of any of them aborts the run.
+type Hooks struct {
+	BeforeRun       []Hook `yaml:"beforeRun"       binding:"dive"`
+	AfterRun        []Hook `yaml:"afterRun"        binding:"dive"`
+	BeforeMigration []Hook `yaml:"beforeMigration" binding:"dive"`
+	AfterMigration  []Hook `yaml:"afterMigration"  binding:"dive"`
+	// Notify hooks are commands reporting results of daemon checks
+	Notify []Hook `yaml:"notify" binding:"dive"`
+}
+
+// runHooks executes hooks of the stage, migration is nil for hooks around the whole run.
+// Commands get path of the migration in MIGRATOR_MIGRATION environment variable.
+func runHooks(db *gorm.DB, stage string, hooks []Hook, m *migrationFile) error {
+	for i, hook := range hooks {
+		var err error
+		if hook.SQL != "" {
+			err = db.Exec(hook.SQL).Error
+		} else if m != nil {
+			err = runCommand(hook.Command, "MIGRATOR_MIGRATION="+m.Path())
+		} else {
+			err = runCommand(hook.Command)
+		}
+		if err == nil {
+			continue
+		}
+		if m != nil {
+			return fmt.Errorf("%s hook #%d of migration %s has failed: %w", stage, i+1, m.Path(), err)
+		}
+		return fmt.Errorf("%s hook #%d has failed: %w", stage, i+1, err)
+	}
+	return nil
+}
+
+// notify executes commands of notify hooks, they get MIGRATOR_EVENT (pending, applied, failed or drift)
+// and MIGRATOR_MESSAGE environment variables.
+func notify(hooks []Hook, event, message string) error {
+	for i, hook := range hooks {
+		if hook.Command == "" {
+			return fmt.Errorf("notify hook #%d must be a command", i+1)
+		}
+		if err := runCommand(hook.Command, "MIGRATOR_EVENT="+event, "MIGRATOR_MESSAGE="+message); err != nil {
+			return fmt.Errorf("notify hook #%d has failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// runCommand executes shell command with additional environment variables, its output goes to stderr.
+func runCommand(command string, env ...string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// importedMigration is a migration file recorded as applied by another tool.
+type importedMigration struct {
+	File      migrationFile
+	AppliedAt time.Time
+}
+
+// importSource reads history of another migration tool and matches it with migration files.
+// Returned problems are files (or history entries) which can't be matched, they are reported to user.
+type importSource func(db *gorm.DB, files []migrationFile) (applied []importedMigration, problems []string, err error)
+
+var importSources = map[string]importSource{
+	"golang-migrate": importGolangMigrate,
+	"goose":          importGoose,
+	"flyway":         importFlyway,
+}
+
+// runImport populates tracking table from history of another migration tool, so it is possible
+// to switch to migrator without re-baselining by hand.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	from := flags.String("from", "", "migration tool to import history from: golang-migrate, goose, flyway")
+	module := flags.String("module", moduleDefault, "module migrations belong to")
+	track := flags.String("track", trackDefault, "track migrations belong to")
+	dryRun := flags.Bool("dry-run", false, "print what would be imported without changes")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	read, ok := importSources[*from]
+	if !ok {
+		logrus.Fatalf("unknown tool %q to import history from, available values: golang-migrate, goose, flyway", *from)
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	// Dry run changes nothing, so it is not audited
+	var report *runReport
+	if !*dryRun {
+		report = newReport(config, "import", time.Now())
+	}
+	db := connectDB(config)
+	sources, err := initSources(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	l := &loader{sources: sources, variables: config.Variables}
+	files, err := l.Read(*module, *track)
+	if err != nil {
+		logrus.WithError(err).Fatal("can't read migrations")
+	}
+
+	store := newVersionStore(db)
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if count := len(appliedOf(all, *module, *track)); count > 0 {
+		logrus.Fatalf("tracking table already has %d migrations of the track, import is possible only into empty one", count)
+	}
+
+	applied, problems, err := read(db, files)
+	if err != nil {
+		logrus.WithError(err).Fatalf("can't read %s history", *from)
+	}
+	for _, problem := range problems {
+		logrus.Warn(problem)
+	}
+	// Applied migrations must be the first files ordered by name, as they are verified this way
+	for i := range applied {
+		if applied[i].File.Name != files[i].Name {
+			logrus.Fatalf("applied migration %s is ordered by name after not applied %s, "+
+				"rename files to make versions order match names order (i.e. use zero-padded versions)",
+				applied[i].File.Name, files[i].Name)
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("Would import %d applied migrations:\n", len(applied))
+		for _, m := range applied {
+			fmt.Println(" - ", m.File.Path())
+		}
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range applied {
+			record := m.File.Record()
+			record.CreatedAt = m.AppliedAt
+			if err := store.Record(tx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("can't import migrations")
+	}
+	report.Finish(statusImported)
+	fmt.Printf("Has imported %d applied migrations, %d are pending\n", len(applied), len(files)-len(applied))
+}
+
+var versionPrefix = regexp.MustCompile(`^(\d+)_`)
+
+// fileVersion returns numeric version prefix of the file name like 0042_add_orders.up.sql.
+func fileVersion(name string) (int64, bool) {
+	match := versionPrefix.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(match[1], 10, 64)
+	return v, err == nil
+}
+
+// importGolangMigrate reads schema_migrations table of golang-migrate, which stores only the last applied version,
+// so all files with the same or lower version are considered applied.
+func importGolangMigrate(db *gorm.DB, files []migrationFile) ([]importedMigration, []string, error) {
+	var state struct {
+		Version int64
+		Dirty   bool
+	}
+	res := db.Raw("SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&state)
+	if res.Error != nil {
+		return nil, nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil, nil
+	}
+	if state.Dirty {
+		return nil, nil, fmt.Errorf("database is dirty at version %d, fix it with golang-migrate first", state.Version)
+	}
+
+	var (
+		applied  []importedMigration
+		problems []string
+		found    bool
+		now      = time.Now()
+	)
+	for _, f := range files {
+		v, ok := fileVersion(f.Name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("file %s has no version prefix, it is considered pending", f.Path()))
+			continue
+		}
+		if v <= state.Version {
+			applied = append(applied, importedMigration{File: f, AppliedAt: now})
+		}
+		found = found || v == state.Version
+		if !strings.HasSuffix(f.Name, ".up.sql") {
+			problems = append(problems, fmt.Sprintf("file %s is not an up migration of golang-migrate", f.Path()))
+		}
+	}
+	if !found {
+		problems = append(problems, fmt.Sprintf("no file matches applied version %d", state.Version))
+	}
+	return applied, problems, nil
+}
+
+// gooseDownAnnotation starts section of goose migration file reverting it.
+const gooseDownAnnotation = "-- +goose Down"
+
+// importGoose reads goose_db_version table of pressly/goose, which logs every apply and rollback,
+// so the latest entry of a version tells whether it is applied.
+func importGoose(db *gorm.DB, files []migrationFile) ([]importedMigration, []string, error) {
+	var rows []struct {
+		VersionID int64
+		IsApplied bool
+		Tstamp    time.Time
+	}
+	if err := db.Raw("SELECT version_id, is_applied, tstamp FROM goose_db_version ORDER BY id").Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	appliedAt := make(map[int64]time.Time)
+	for _, row := range rows {
+		// Version 0 is inserted by goose on creation of the table
+		if row.VersionID == 0 {
+			continue
+		}
+		if row.IsApplied {
+			appliedAt[row.VersionID] = row.Tstamp
+		} else {
+			delete(appliedAt, row.VersionID)
+		}
+	}
+
+	var (
+		applied  []importedMigration
+		problems []string
+	)
+	for _, f := range files {
+		v, ok := fileVersion(f.Name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("file %s has no version prefix, it is considered pending", f.Path()))
+			continue
+		}
+		if at, ok := appliedAt[v]; ok {
+			applied = append(applied, importedMigration{File: f, AppliedAt: at})
+			delete(appliedAt, v)
+		} else if strings.Contains(f.Body, gooseDownAnnotation) {
+			problems = append(problems, fmt.Sprintf("pending file %s has goose Down section, which would be executed too, "+
+				"move it to %s file", f.Path(), downExt))
+		}
+	}
+	for v := range appliedAt {
+		problems = append(problems, fmt.Sprintf("no file matches applied version %d, Go migrations of goose are not supported", v))
+	}
+	return applied, problems, nil
+}
+
+// flywayFileName matches versioned migration of Flyway like V1_2__add_orders.sql, which version is 1.2.
+var flywayFileName = regexp.MustCompile(`^V([0-9._]+)__.*\.sql$`)
+
+// importFlyway reads flyway_schema_history table. Checksums of Flyway are compared with files,
+// so files changed since apply are reported, and then replaced by checksums of the migrator.
+func importFlyway(db *gorm.DB, files []migrationFile) ([]importedMigration, []string, error) {
+	var rows []struct {
+		Version     *string
+		Type        string
+		Script      string
+		Checksum    *int32
+		InstalledOn time.Time
+		Success     bool
+	}
+	err := db.Raw("SELECT version, type, script, checksum, installed_on, success FROM flyway_schema_history " +
+		"ORDER BY installed_rank").Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		applied  []importedMigration
+		problems []string
+		baseline []int64
+		byScript = make(map[string]int)
+	)
+	for i, row := range rows {
+		switch {
+		case !row.Success:
+			return nil, nil, fmt.Errorf("migration %s has failed, repair it with Flyway first", row.Script)
+		case row.Type == "SCHEMA":
+			continue
+		case row.Type == "BASELINE" && row.Version != nil:
+			baseline = flywayVersion(*row.Version)
+		case row.Version == nil:
+			problems = append(problems, fmt.Sprintf("repeatable migration %s is not supported, it is ignored", row.Script))
+		default:
+			// Script is path relative to Flyway location, files are matched by name
+			byScript[path.Base(row.Script)] = i
+		}
+	}
+
+	for _, f := range files {
+		if i, ok := byScript[f.Name]; ok {
+			row := rows[i]
+			delete(byScript, f.Name)
+			if row.Checksum != nil && *row.Checksum != flywayChecksum(f.Body) {
+				problems = append(problems, fmt.Sprintf("file %s was changed since Flyway has applied it", f.Path()))
+			}
+			applied = append(applied, importedMigration{File: f, AppliedAt: row.InstalledOn})
+			continue
+		}
+		match := flywayFileName.FindStringSubmatch(f.Name)
+		if match == nil {
+			problems = append(problems, fmt.Sprintf("file %s is not versioned migration of Flyway, it is considered pending", f.Path()))
+			continue
+		}
+		if baseline != nil && compareVersions(flywayVersion(match[1]), baseline) <= 0 {
+			applied = append(applied, importedMigration{File: f, AppliedAt: time.Now()})
+		}
+	}
+	for script := range byScript {
+		problems = append(problems, fmt.Sprintf("no file matches applied migration %s", script))
+	}
+	return applied, problems, nil
+}
+
+// flywayVersion parses version like 1.2 or 1_2 into its numeric parts.
+func flywayVersion(s string) []int64 {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == '_' })
+	v := make([]int64, len(parts))
+	for i := range parts {
+		v[i], _ = strconv.ParseInt(parts[i], 10, 64)
+	}
+	return v
+}
+
+// compareVersions compares versions part by part, missed parts are zeroes.
+func compareVersions(a, b []int64) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int64
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// flywayChecksum calculates checksum the way Flyway does: CRC32 of file lines without line terminators and BOM.
+func flywayChecksum(body string) int32 {
+	crc := crc32.NewIEEE()
+	body = strings.TrimPrefix(body, "\uFEFF")
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	body = strings.ReplaceAll(body, "\r", "\n")
+	body = strings.TrimSuffix(body, "\n")
+	for _, line := range strings.Split(body, "\n") {
+		crc.Write([]byte(line))
+	}
+	return int32(crc.Sum32())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	includeDirective = "include"
+	maxIncludeDepth  = 10
+)
+
+// inline replaces "-- migrator:include common/functions.sql" directives by content of the fragment.
+// Fragment path is relative to root of the source, it is looked up in the same source first and then in others.
+// Fragments may include other fragments. Inlined content is a part of migration body, so its changes are detected.
+func (l *loader) inline(src source, body string, depth int) (string, error) {
+	if depth > maxIncludeDepth {
+		return "", fmt.Errorf("includes are nested deeper than %d levels, probably there is a cycle", maxIncludeDepth)
+	}
+	if !strings.Contains(body, directivePrefix+includeDirective) {
+		return body, nil
+	}
+	lines := strings.SplitAfter(body, "\n")
+	for _, d := range parseDirectives(body) {
+		if d.Name != includeDirective {
+			continue
+		}
+		name := path.Clean(d.Args)
+		if !fs.ValidPath(name) {
+			return "", fmt.Errorf("invalid include path %q at line %d", d.Args, d.Line)
+		}
+		fragmentSrc, fragment, err := l.readFragment(src, name)
+		if err != nil {
+			return "", fmt.Errorf("can't include %s at line %d: %w", name, d.Line, err)
+		}
+		if fragment, err = l.inline(fragmentSrc, fragment, depth+1); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		if !strings.HasSuffix(fragment, "\n") {
+			fragment += "\n"
+		}
+		lines[d.Line-1] = fragment
+	}
+	return strings.Join(lines, ""), nil
+}
+
+func (l *loader) readFragment(src source, name string) (source, string, error) {
+	sources := append([]source{src}, l.sources...)
+	for _, s := range sources {
+		data, err := s.Read(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return s, "", err
+		}
+		if err := l.verifier.Verify(s, name, data); err != nil {
+			return s, "", err
+		}
+		return s, string(data), nil
+	}
+	return src, "", fmt.Errorf("fragment is not found in any source")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lintIgnoreDirective disables lint rules for migration, i.e. "-- migrator:lint-ignore rename,set-not-null".
+const lintIgnoreDirective = "lint-ignore"
+
+// lintRule finds statements unsafe for online deployments, when old version of application still works
+// with the database while migrations are applied. Statements are matched in canonical upper-cased form.
+type lintRule struct {
+	Name    string
+	Match   func(sql string) bool
+	Message string
+}
+
+var (
+	alterColumnType  = regexp.MustCompile(`^ALTER TABLE .*\bALTER (COLUMN )?\S+ (SET DATA )?TYPE\b`)
+	setNotNull       = regexp.MustCompile(`^ALTER TABLE .*\bALTER (COLUMN )?\S+ SET NOT NULL\b`)
+	addColumnClauses = regexp.MustCompile(`\bADD (COLUMN )?(IF NOT EXISTS )?[^,]*`)
+	renameTable      = regexp.MustCompile(`^ALTER TABLE .*\bRENAME\b`)
+)
+
+var lintRules = []lintRule{
+	{
+		Name:  "column-type-change",
+		Match: alterColumnType.MatchString,
+		Message: "changing column type rewrites the table under ACCESS EXCLUSIVE lock and may break old application version; " +
+			"expand/contract: add a new column, backfill it in batches, switch the application to it, then drop the old one",
+	},
+	{
+		Name:  "set-not-null",
+		Match: setNotNull.MatchString,
+		Message: "SET NOT NULL scans the whole table under ACCESS EXCLUSIVE lock; backfill the column first, " +
+			"add CHECK (column IS NOT NULL) NOT VALID, validate it by a separate migration, then SET NOT NULL uses the check",
+	},
+	{
+		Name: "add-column-not-null",
+		Match: func(sql string) bool {
+			if !strings.HasPrefix(sql, "ALTER TABLE ") {
+				return false
+			}
+			for _, clause := range addColumnClauses.FindAllString(sql, -1) {
+				if strings.Contains(clause, " NOT NULL") && !strings.Contains(clause, " DEFAULT ") &&
+					!strings.HasPrefix(clause, "ADD CONSTRAINT ") {
+					return true
+				}
+			}
+			return false
+		},
+		Message: "NOT NULL column without default fails on filled table and breaks inserts of old application version; " +
+			"expand/contract: add nullable column or one with default, backfill it, then add the constraint",
+	},
+	{
+		Name:  "rename",
+		Match: renameTable.MatchString,
+		Message: "renaming breaks old application version still running during deployment; expand/contract: " +
+			"add a new column or table, write to both, switch reads to the new one, drop the old one by a later release",
+	},
+	{
+		Name: "create-index",
+		Match: func(sql string) bool {
+			return createIndex.MatchString(sql) && !strings.Contains(sql, " INDEX CONCURRENTLY ")
+		},
+		Message: "CREATE INDEX blocks writes to the table until it is built; use CREATE INDEX CONCURRENTLY " +
+			"with concurrent-index directive",
+	},
+}
+
+// lintFinding is a statement violating lint rule.
+type lintFinding struct {
+	Migration string
+	Line      int
+	Rule      string
+	Message   string
+}
+
+// lintMigration checks statements of migration against rules not ignored by its directive.
+func lintMigration(m migrationFile) []lintFinding {
+	ignored := make(map[string]bool)
+	if d, ok := findDirective(parseDirectives(m.Body), lintIgnoreDirective); ok {
+		for _, name := range strings.FieldsFunc(d.Args, func(r rune) bool { return r == ',' || r == ' ' }) {
+			ignored[name] = true
+		}
+	}
+	var findings []lintFinding
+	for _, stmt := range splitStatements(m.Body) {
+		sql := strings.ToUpper(canonicalSQL(stmt.SQL))
+		for _, rule := range lintRules {
+			if !ignored[rule.Name] && rule.Match(sql) {
+				findings = append(findings, lintFinding{Migration: m.Path(), Line: stmt.Line, Rule: rule.Name, Message: rule.Message})
+			}
+		}
+	}
+	return findings
+}
+
+// runLint checks migration files for statements unsafe for zero-downtime deployments without connecting to the database.
+// It exits with code 1 if anything is found.
+func runLint(args []string) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	since := flags.String("since", "", "check only migrations ordered after the given name, i.e. already released ones are skipped")
+	from := flags.String("from", "", "read migrations only from the given source")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	config := initConfig(configPath())
+	opts.Setup()
+	l := newLoader(config, *from)
+	var findings []lintFinding
+	for _, track := range allTracks {
+		for _, module := range append([]string{moduleDefault}, config.Modules...) {
+			files, err := l.Read(module, track)
+			if err != nil {
+				logrus.WithError(err).Fatal("can't read migrations")
+			}
+			for _, m := range files {
+				if m.Name > *since {
+					findings = append(findings, lintMigration(m)...)
+				}
+			}
+		}
+	}
+	for _, f := range findings {
+		fmt.Printf("%s:%d: %s: %s\n", f.Migration, f.Line, f.Rule, f.Message)
+		annotate("error", f.Migration, f.Line, f.Rule+": "+f.Message)
+	}
+	if len(findings) > 0 {
+		fmt.Printf("Found %d unsafe statements, fix them or disable rules by %s directive.\n", len(findings), lintIgnoreDirective)
+		os.Exit(1)
+	}
+	fmt.Println("Found no unsafe statements.")
+}
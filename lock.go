@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// advisoryLockKey identifies session advisory lock held by the run, so concurrent runs against
+// the same database are serialized. Lock is released when connection is closed on exit.
+const advisoryLockKey = 7245042412082705509
+
+// lockOrFollow acquires the lock and reports whether this replica is the leader. When another run holds
+// the lock the replica becomes a follower: it waits up to timeout for the leader to finish and acquires the lock then.
+func lockOrFollow(store VersionStore, timeout time.Duration) (bool, error) {
+	acquired, err := store.Lock(false)
+	if err != nil || acquired {
+		return acquired, err
+	}
+	logrus.Infof("migrations are being applied by another replica, waiting up to %s for it to finish", timeout)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		if acquired, err = store.Lock(false); err != nil || acquired {
+			return false, err
+		}
+	}
+	return false, fmt.Errorf("leader has not finished in %s", timeout)
+}
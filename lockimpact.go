@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Lock modes taken by DDL statements, from the weakest to the strongest one.
+const (
+	lockShareUpdateExclusive = "SHARE UPDATE EXCLUSIVE"
+	lockShare                = "SHARE"
+	lockShareRowExclusive    = "SHARE ROW EXCLUSIVE"
+	lockExclusive            = "EXCLUSIVE"
+	lockAccessExclusive      = "ACCESS EXCLUSIVE"
+)
+
+// lockEffects describe what concurrent sessions can't do while the lock is held.
+var lockEffects = map[string]string{
+	lockShareUpdateExclusive: "blocks other DDL and vacuum",
+	lockShare:                "blocks writes",
+	lockShareRowExclusive:    "blocks writes",
+	lockExclusive:            "blocks writes and row locks",
+	lockAccessExclusive:      "blocks reads and writes",
+}
+
+// tableLock is a lock statement takes on the table.
+type tableLock struct {
+	Table string
+	Mode  string
+}
+
+// classifyLocks returns locks taken by DDL statement. It recognizes common statements only,
+// DML and unknown statements are reported as taking no heavy locks.
+func classifyLocks(sql string) []tableLock {
+	t := strings.Fields(canonicalSQL(sql))
+	is := func(i int, words ...string) bool {
+		for j, w := range words {
+			if i+j >= len(t) || !strings.EqualFold(t[i+j], w) {
+				return false
+			}
+		}
+		return true
+	}
+	// name returns name of the table at i, skipping optional words before it
+	name := func(i int, optional ...string) (string, int) {
+		for i < len(t) {
+			skipped := false
+			for _, w := range optional {
+				if n := len(strings.Fields(w)); is(i, strings.Fields(w)...) {
+					i += n
+					skipped = true
+				}
+			}
+			if !skipped {
+				break
+			}
+		}
+		if i >= len(t) {
+			return "", i
+		}
+		return strings.Trim(t[i], `"`), i + 1
+	}
+	// names returns comma separated list of tables
+	names := func(i int, optional ...string) []string {
+		var result []string
+		for {
+			var table string
+			if table, i = name(i, optional...); table == "" {
+				return result
+			}
+			result = append(result, table)
+			if !is(i, ",") {
+				return result
+			}
+			i++
+		}
+	}
+	lock := func(mode string, tables ...string) []tableLock {
+		locks := make([]tableLock, len(tables))
+		for i := range tables {
+			locks[i] = tableLock{Table: tables[i], Mode: mode}
+		}
+		return locks
+	}
+	rest := strings.ToUpper(strings.Join(t, " "))
+	referenced := func() []string {
+		var tables []string
+		for i := range t {
+			if is(i, "REFERENCES") {
+				if table, _ := name(i + 1); table != "" {
+					tables = append(tables, table)
+				}
+			}
+		}
+		return tables
+	}
+
+	switch {
+	case is(0, "ALTER", "TABLE"):
+		table, _ := name(2, "IF EXISTS", "ONLY")
+		switch {
+		case strings.Contains(rest, " VALIDATE CONSTRAINT "), strings.Contains(rest, " SET STATISTICS "):
+			return lock(lockShareUpdateExclusive, table)
+		case strings.Contains(rest, " ADD CONSTRAINT ") && strings.Contains(rest, " FOREIGN KEY "),
+			strings.Contains(rest, " ADD FOREIGN KEY "):
+			return lock(lockShareRowExclusive, append([]string{table}, referenced()...)...)
+		}
+		return lock(lockAccessExclusive, table)
+	case is(0, "CREATE", "TABLE"):
+		return lock(lockShareRowExclusive, referenced()...)
+	case is(0, "DROP", "TABLE"):
+		return lock(lockAccessExclusive, names(2, "IF EXISTS")...)
+	case is(0, "TRUNCATE"):
+		return lock(lockAccessExclusive, names(1, "TABLE", "ONLY")...)
+	case is(0, "CREATE", "INDEX"), is(0, "CREATE", "UNIQUE", "INDEX"):
+		for i := range t {
+			if is(i, "ON") {
+				table, _ := name(i+1, "ONLY")
+				if strings.Contains(rest, " INDEX CONCURRENTLY ") {
+					return lock(lockShareUpdateExclusive, table)
+				}
+				return lock(lockShare, table)
+			}
+		}
+	case is(0, "DROP", "INDEX", "CONCURRENTLY"):
+		return lock(lockShareUpdateExclusive, names(3, "IF EXISTS")...)
+	case is(0, "DROP", "INDEX"):
+		// Table of the index is locked, index name is reported as it is known
+		return lock(lockAccessExclusive, names(2, "IF EXISTS")...)
+	case is(0, "REINDEX", "TABLE", "CONCURRENTLY"):
+		return lock(lockShareUpdateExclusive, names(3)...)
+	case is(0, "REINDEX", "TABLE"):
+		return lock(lockShare, names(2)...)
+	case is(0, "CREATE", "TRIGGER"), is(0, "CREATE", "OR", "REPLACE", "TRIGGER"):
+		for i := range t {
+			if is(i, "ON") {
+				table, _ := name(i + 1)
+				return lock(lockShareRowExclusive, table)
+			}
+		}
+	case is(0, "LOCK"):
+		table, i := name(1, "TABLE", "ONLY")
+		for mode := range lockEffects {
+			if is(i, append(append([]string{"IN"}, strings.Fields(mode)...), "MODE")...) {
+				return lock(mode, table)
+			}
+		}
+		return lock(lockAccessExclusive, table)
+	case is(0, "VACUUM", "FULL"):
+		return lock(lockAccessExclusive, names(2)...)
+	case is(0, "CLUSTER"):
+		return lock(lockAccessExclusive, names(1)...)
+	case is(0, "REFRESH", "MATERIALIZED", "VIEW", "CONCURRENTLY"):
+		return lock(lockExclusive, names(4)...)
+	case is(0, "REFRESH", "MATERIALIZED", "VIEW"):
+		return lock(lockAccessExclusive, names(3)...)
+	}
+	return nil
+}
+
+// tableActivity is current activity on the table, which would wait for the lock or delay it.
+type tableActivity struct {
+	Exists   bool
+	Rows     int64   // estimated number of rows
+	Sessions int64   // sessions holding locks on the table
+	Oldest   float64 // age in seconds of the oldest transaction of them
+}
+
+func currentActivity(db *gorm.DB, table string) (tableActivity, error) {
+	var a tableActivity
+	err := db.Raw(`SELECT to_regclass(?) IS NOT NULL AS exists,
+		coalesce((SELECT greatest(reltuples, 0)::bigint FROM pg_class WHERE oid = to_regclass(?)), 0) AS rows,
+		count(DISTINCT l.pid) AS sessions, coalesce(max(extract(epoch FROM now() - a.xact_start)), 0) AS oldest
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.relation = to_regclass(?) AND l.pid <> pg_backend_pid()`, table, table, table).Scan(&a).Error
+	if err != nil {
+		return a, fmt.Errorf("can't get activity on %s: %w", table, err)
+	}
+	return a, nil
+}
+
+// describeActivity returns current activity on the table in human readable form.
+func describeActivity(db *gorm.DB, table string) (string, error) {
+	a, err := currentActivity(db, table)
+	if err != nil || !a.Exists {
+		return "doesn't exist yet", err
+	}
+	activity := fmt.Sprintf("~%d rows, %d sessions hold locks", a.Rows, a.Sessions)
+	if a.Sessions > 0 {
+		activity += fmt.Sprintf(", oldest transaction is %.0fs old", a.Oldest)
+	}
+	return activity, nil
+}
+
+// statementLock is a lock taken by statement of migration at the line.
+type statementLock struct {
+	Line int
+	tableLock
+}
+
+// migrationLocks returns locks taken by statements of migration in order of execution.
+func migrationLocks(m migrationFile) []statementLock {
+	var locks []statementLock
+	for _, stmt := range splitStatements(m.Body) {
+		for _, l := range classifyLocks(stmt.SQL) {
+			locks = append(locks, statementLock{Line: stmt.Line, tableLock: l})
+		}
+	}
+	return locks
+}
+
+// printLockImpact prints locks taken by pending migrations together with current activity on the tables,
+// so operators may decide whether to proceed now.
+func printLockImpact(db *gorm.DB, pending []migrationFile) error {
+	fmt.Println("Locks of pending migrations:")
+	found := false
+	for _, m := range pending {
+		m, err := m.loaded()
+		if err != nil {
+			return err
+		}
+		for _, l := range migrationLocks(m) {
+			found = true
+			activity, err := describeActivity(db, l.Table)
+			if err != nil {
+				return err
+			}
+			fmt.Printf(" -  %s:%d %s on %s (%s), %s\n", m.Path(), l.Line, l.Mode, l.Table, lockEffects[l.Mode], activity)
+		}
+	}
+	if !found {
+		fmt.Println(" -  no heavy locks")
+	}
+	return nil
+}
@@ -1,37 +1,128 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
 	"log"
 	"os"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/andreyvit/diff"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+
+	"migrator/extend"
 )
 
-//go:embed "migrations/*.sql"
+//go:embed "migrations"
 var Embed embed.FS
 
 const migrationsDirName = "migrations"
 
+// version of the binary, it is set at build time by -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
 type Config struct {
 	LogLevel string `yaml:"logLevel" binding:"required"`
-	Database struct {
-		Name     string `yaml:"name"     binding:"required"`
-		Host     string `yaml:"host"     binding:"required"`
-		Port     int    `yaml:"port"     binding:"min=1,max=65535"`
-		User     string `yaml:"user"     binding:"required"`
-		Password string `yaml:"password" binding:"required"`
-	}
+	// Name of the environment checked by environments directive of migrations
+	Environment string   `yaml:"environment"`
+	Modules     []string `yaml:"modules"  binding:"dive,required,excludesall=/\\,ne=schema,ne=data"`
+	Sources     []string `yaml:"sources"  binding:"dive,required"`
+	// Migrations not applicable to the environment, they are recorded as skipped without execution
+	Skip []string `yaml:"skip" binding:"dive,required"`
+	// Default search_path of migrations, i.e. "app" or "billing,public"
+	Schema string `yaml:"schema"`
+	// Directory with hotfix migrations added to embedded ones at runtime, it is ignored if missing
+	Overlay string `yaml:"overlay"`
+	// Migration files of this size in bytes and larger are streamed instead of being read into memory, 0 disables it
+	StreamThreshold int64 `yaml:"streamThreshold"`
+	// How checksums of migration bodies are computed, sha256 of raw body by default
+	Checksum ChecksumConfig `yaml:"checksum"`
+	// How bodies of applied migrations are kept in tracking table
+	Body BodyStorage `yaml:"body"`
+	// Coordination of concurrent runs, advisory lock by default
+	Lock LockConfig `yaml:"lock"`
+	// Replication lag is checked before the run and replicas are waited for after it
+	Replicas Replicas `yaml:"replicas"`
+	// Budgets of canary run on a sampled clone of the database
+	Canary Canary `yaml:"canary"`
+	// Schemas of tenants migrations are applied to one by one in schema-per-tenant mode
+	Tenants Tenants `yaml:"tenants"`
+	// Retries of migrations failed by transient errors, disabled by default
+	Retry Retry `yaml:"retry"`
+	// When pending migrations may be applied, any time by default
+	Window MaintenanceWindow `yaml:"window"`
+	// Free disk space is checked before migrations rewriting tables
+	Disk DiskCheck `yaml:"disk"`
+	// Pauses and load checks between consecutive migrations, disabled by default
+	Pacing Pacing `yaml:"pacing"`
+	// Named sets of settings overriding the rest of config, selected by --profile flag, i.e. database and environment of prod-eu
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+	// Older binaries refuse to run, i.e. "1.4" after migrations relying on features of that version
+	MinMigratorVersion string `yaml:"minMigratorVersion"`
+	// Ordered stages executed by pipeline command, i.e. schema migrations, seeds, drift check and notification
+	Pipeline []PipelineStage `yaml:"pipeline" binding:"dive"`
+	// Enables substitution of ${VAR} placeholders, values missed here are taken from environment
+	Variables     map[string]string `yaml:"variables"`
+	Verification  string            `yaml:"verification" binding:"omitempty,oneof=strict relaxed"`
+	FailurePolicy string            `yaml:"failurePolicy" binding:"omitempty,oneof=abort next-target continue"`
+	Normalization Normalization     `yaml:"normalization"`
+	Naming        Naming            `yaml:"naming"`
+	Hooks         Hooks             `yaml:"hooks"`
+	Permissions   Permissions       `yaml:"permissions"`
+	Slow          SlowThresholds    `yaml:"slow"`
+	Backup        Backup            `yaml:"backup"`
+	Audit         Audit             `yaml:"audit"`
+	Daemon        Daemon            `yaml:"daemon"`
+	Diff          struct {
+		Output string `yaml:"output"`
+		// Rendering of mismatch diff: unified (default), line or character
+		Format string `yaml:"format"`
+		// External command rendering diff instead, paths of applied body and file are appended to it
+		Command string `yaml:"command"`
+	} `yaml:"diff"`
+	Report struct {
+		Output string `yaml:"output"`
+	} `yaml:"report"`
+	Signatures struct {
+		Required bool   `yaml:"required"`
+		Keyring  string `yaml:"keyring"`
+		Sigstore struct {
+			Identity string `yaml:"identity"`
+			Issuer   string `yaml:"issuer"`
+		} `yaml:"sigstore"`
+	} `yaml:"signatures"`
+	Database DatabaseConfig `yaml:"database"`
+	// Settings inherited by database and targets, i.e. port, user, sslMode and timeouts shared by many databases
+	Defaults DatabaseConfig `yaml:"defaults" binding:"-"`
+	// Databases up applies migrations to one by one instead of database, i.e. one per tenant
+	Targets []DatabaseConfig `yaml:"targets" binding:"dive"`
+}
+
+// DatabaseConfig is connection to the target database.
+type DatabaseConfig struct {
+	Name     string `yaml:"name"     binding:"required"`
+	Host     string `yaml:"host"     binding:"required"`
+	Port     int    `yaml:"port"     binding:"min=1,max=65535"`
+	User     string `yaml:"user"     binding:"required"`
+	Password string `yaml:"password" binding:"required_without=PasswordFile,excluded_with=PasswordFile"`
+	// File with password, i.e. Docker or Kubernetes secret, trailing newlines are trimmed
+	PasswordFile string `yaml:"passwordFile"`
+	// Runs against older server are refused, i.e. "14" or "15.4"
+	MinServerVersion string `yaml:"minServerVersion"`
+	// Role set after connecting, user needs only membership in it while objects are owned by the role
+	Role string `yaml:"role"`
+	// sslmode of libpq like require or verify-full
+	SSLMode        string        `yaml:"sslMode" binding:"omitempty,oneof=disable allow prefer require verify-ca verify-full"`
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// Session timeouts of connection, server defaults are kept if not set
+	StatementTimeout time.Duration `yaml:"statementTimeout"`
+	LockTimeout      time.Duration `yaml:"lockTimeout"`
 }
 
 // ConnURL returns string URL, which may be used for connect to postgres database.
@@ -44,47 +135,186 @@ func (c *Config) ConnURL() string {
 		c.Database.Port,
 		c.Database.Name,
 	)
+	if params := c.Database.params().Encode(); params != "" {
+		url += "?" + params
+	}
 	return url
 }
 
+// Target returns address of the database without credentials, i.e. "db.example.com:5432/app".
+func (c *Config) Target() string {
+	return c.Database.Address()
+}
+
+// configPath returns path of config file, it may be overridden by MIGRATOR_CONFIG environment variable.
+func configPath() string {
+	if path := os.Getenv("MIGRATOR_CONFIG"); path != "" {
+		return path
+	}
+	return "./config.example.yaml"
+}
+
 func initConfig(path string) Config {
-	file, err := os.Open(path)
+	config, err := loadConfig(path)
 	if err != nil {
-		logrus.WithError(err).WithField("path", path).Fatal("can't read config file")
+		logrus.WithError(err).WithField("path", path).Fatal("invalid config")
 	}
-	var config Config
+	level, _ := logrus.ParseLevel(config.LogLevel)
+	logrus.SetLevel(level)
+	logrus.SetReportCaller(true) // adds line number to log message
+	if profile := os.Getenv(profileEnv); profile != "" {
+		// Shown before anything is done, so the wrong database is noticed
+		logrus.Infof("profile %s targets %s", profile, config.Target())
+	}
+	bodyStorage = config.Body
+	lockConfig = config.Lock
+
+	return config
+}
+
+// loadConfig reads and validates config file without applying it.
+func loadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("can't read config file: %w", err)
+	}
+	if data, err = decryptConfig(path, data); err != nil {
+		return Config{}, err
+	}
+	config := Config{Normalization: defaultNormalization, StreamThreshold: defaultStreamThreshold}
 	// Init new YAML decode
-	d := yaml.NewDecoder(file)
+	d := yaml.NewDecoder(bytes.NewReader(data))
 	// Start YAML decoding from file
 	if err := d.Decode(&config); err != nil {
-		logrus.WithError(err).Fatal("can't decode config file")
+		return Config{}, fmt.Errorf("can't decode config file: %w", err)
 	}
 
+	if err := applyProfile(&config, os.Getenv(profileEnv)); err != nil {
+		return Config{}, err
+	}
+	if err := selectTarget(&config); err != nil {
+		return Config{}, err
+	}
 	if err := binding.Validator.ValidateStruct(config); err != nil {
-		logrus.WithError(err).Fatal("config validation failed")
+		return Config{}, fmt.Errorf("config validation failed: %w", err)
 	}
 
-	level, err := logrus.ParseLevel(config.LogLevel)
-	if err != nil {
-		logrus.Fatal("invalid 'logLevel' parameter in configuration. Available values: ", logrus.AllLevels)
+	if _, err := logrus.ParseLevel(config.LogLevel); err != nil {
+		return Config{}, fmt.Errorf("invalid 'logLevel' parameter in configuration. Available values: %v", logrus.AllLevels)
 	}
-	logrus.SetLevel(level)
-	logrus.SetReportCaller(true) // adds line number to log message
-	logrus.SetFormatter(&logrus.TextFormatter{ForceColors: true})
+	if _, ok := diffRenderers[config.Diff.Format]; config.Diff.Format != "" && !ok {
+		return Config{}, fmt.Errorf("unknown diff format %q", config.Diff.Format)
+	}
+	if _, ok := parseMigratorVersion(config.MinMigratorVersion); config.MinMigratorVersion != "" && !ok {
+		return Config{}, fmt.Errorf("invalid minMigratorVersion %q, expected version like 1.4", config.MinMigratorVersion)
+	}
+	if _, err := parseMaintenanceWindow(config.Window); err != nil {
+		return Config{}, err
+	}
+	for _, size := range []string{config.Disk.Capacity, config.Disk.Reserve} {
+		if _, err := parseBytes(size); err != nil {
+			return Config{}, fmt.Errorf("invalid disk config: %w", err)
+		}
+	}
+	if config.Database.PasswordFile != "" {
+		password, err := os.ReadFile(config.Database.PasswordFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("can't read password file: %w", err)
+		}
+		config.Database.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	// Stages of pipeline write their reports to be combined
+	if path := os.Getenv("MIGRATOR_REPORT"); path != "" {
+		config.Report.Output = path
+	}
+	return config, nil
+}
 
-	return config
+// Migration is a row of tracking table.
+type Migration = extend.Migration
+
+func main() {
+	// Command may be omitted for backward compatibility, migrations are applied then
+	command, args := "up", profileArgs(os.Args[1:])
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "up":
+		runUp(args)
+	case "bundle":
+		runBundle(args)
+	case "history":
+		runHistory(args)
+	case "import":
+		runImport(args)
+	case "diff-models":
+		runDiffModels(args)
+	case "squash":
+		runSquash(args)
+	case "selftest":
+		runSelftest(args)
+	case "snapshot":
+		runSnapshot(args)
+	case "daemon":
+		runDaemon(args)
+	case "status":
+		runStatus(args)
+	case "plan":
+		runPlan(args)
+	case "apply":
+		runApply(args)
+	case "lint":
+		runLint(args)
+	case "repair":
+		runRepair(args)
+	case "validate":
+		runValidate(args)
+	case "check":
+		runCheck(args)
+	case "coordinate":
+		runCoordinate(args)
+	case "down":
+		runDown(args)
+	case "redo":
+		runRedo(args)
+	case "create":
+		runCreate(args)
+	case "pipeline":
+		runPipeline(args)
+	case "export-applied":
+		runExportApplied(args)
+	default:
+		logrus.Fatalf("unknown command %q, available commands: up, bundle, history, import, diff-models, squash, selftest, "+
+			"snapshot, daemon, status, plan, apply, lint, repair, validate, check, coordinate, down, redo, create, pipeline, export-applied", command)
+	}
 }
 
-type Migration struct {
-	ID        int
-	CreatedAt time.Time
-	Name      string
-	Body      string
+// connectDB opens the configured database and prepares tracking table.
+func connectDB(config Config) *gorm.DB {
+	if err := requireTarget(config); err != nil {
+		logrus.Fatal(err)
+	}
+	db := openDB(config)
+	if err := upgradeTrackingTable(db); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := checkMigratorVersion(db, config); err != nil {
+		logrus.Fatal(err)
+	}
+	return db
 }
 
-func main() {
-	config := initConfig("./config.example.yaml")
+func openDB(config Config) *gorm.DB {
+	db, err := dial(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return db
+}
 
+// dial connects to the configured database.
+func dial(config Config) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(config.ConnURL()), &gorm.Config{
 		Logger: logger.New(
 			log.New(os.Stderr, "\r\n", log.LstdFlags), // io writer
@@ -92,80 +322,24 @@ func main() {
 				SlowThreshold:             time.Second / 5, // Slow SQL threshold
 				LogLevel:                  logger.Silent,   // Log level
 				IgnoreRecordNotFoundError: true,
-				Colorful:                  true,
+				Colorful:                  false,
 			},
 		),
 	})
 	if err != nil {
-		logrus.Fatal(err)
-	}
-	if !db.Migrator().HasTable(&Migration{}) {
-		if err := db.Migrator().CreateTable(&Migration{}); err != nil {
-			logrus.Fatal(err)
-		}
+		return nil, err
 	}
-
-	var applied []Migration
-	if err := db.Order("name").Find(&applied).Error; err != nil {
-		logrus.Fatal(err)
-	}
-	dir, err := Embed.ReadDir(migrationsDirName)
+	// Migrations are applied sequentially, the only connection makes session settings of hooks
+	// like "SET ROLE migrator" effective for all of them
+	sqlDB, err := db.DB()
 	if err != nil {
-		logrus.WithError(err).Fatal("can't read migrations dir")
-	}
-	sort.Slice(dir, func(i, j int) bool {
-		return dir[i].Name() < dir[j].Name()
-	})
-	var files = make([]string, len(dir))
-	for i := range dir {
-		file, err := Embed.ReadFile(migrationsDirName + "/" + dir[i].Name())
-		if err != nil {
-			logrus.WithError(err).WithField("filename", dir[i].Name()).Fatal("can't read migration file")
-		}
-		files[i] = string(file)
-	}
-	logrus.SetFormatter(&logrus.TextFormatter{DisableQuote: true})
-	for i := range applied {
-		if len(files) <= i {
-			logrus.Fatalf("migration %s was removed", applied[i].Name)
-		}
-
-		// Support multi-platform line-separator
-		applied[i].Body = strings.Replace(applied[i].Body, "\r\n", "\r", -1)
-		files[i] = strings.Replace(files[i], "\r\n", "\r", -1)
-		if files[i] != applied[i].Body {
-			logrus.
-				WithField("diff", diff.CharacterDiff(applied[i].Body, files[i])).
-				Fatalf("migration %s was changed", applied[i].Name)
-		}
+		return nil, err
 	}
-
-	// Trim from box migrations whose already applied
-	files = files[len(applied):]
-	dir = dir[len(applied):]
-	if len(files) == 0 {
-		fmt.Println("Found no one new migration, your database is up to date.")
-		return
-	}
-
-	// Next migrations expected as new and will be incremental applied now
-	for i := range files {
-		tx := db.Begin()
-		if err := tx.Create(&Migration{Name: dir[i].Name(), Body: files[i]}).Error; err != nil {
-			tx.Rollback()
-			logrus.WithError(err).Fatal("can't init migration stat")
+	sqlDB.SetMaxOpenConns(1)
+	if config.Database.Role != "" {
+		if err := db.Exec("SELECT set_config('role', ?, false)", config.Database.Role).Error; err != nil {
+			return nil, fmt.Errorf("can't set role %s: %w", config.Database.Role, err)
 		}
-		if err := tx.Exec(files[i]).Error; err != nil {
-			tx.Rollback()
-			logrus.WithError(err).Fatalf("can't execute migration %s", dir[i].Name())
-		}
-		if err := tx.Commit().Error; err != nil {
-			logrus.WithError(err).Fatal("can't commit transaction")
-		}
-	}
-
-	fmt.Println("Has applied migrations:")
-	for i := range dir {
-		fmt.Println(" - ", dir[i].Name())
 	}
+	return db, nil
 }
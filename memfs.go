@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// memFS is a read-only file system kept in memory.
+// It is used for migrations downloaded from remote sources.
+type memFS map[string][]byte // file path to its content
+
+// Sub returns file system of the directory.
+func (m memFS) Sub(dir string) (memFS, error) {
+	dir = path.Clean(dir)
+	if dir == "." {
+		return m, nil
+	}
+	sub := make(memFS)
+	for name, data := range m {
+		if strings.HasPrefix(name, dir+"/") {
+			sub[strings.TrimPrefix(name, dir+"/")] = data
+		}
+	}
+	if len(sub) == 0 {
+		return nil, fmt.Errorf("directory %s is not found", dir)
+	}
+	return sub, nil
+}
+
+// Open implements fs.FS.
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if data, ok := m[name]; ok {
+		return &memFile{info: memInfo{name: path.Base(name), size: int64(len(data))}, Reader: bytes.NewReader(data)}, nil
+	}
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{info: memInfo{name: path.Base(name), dir: true}, entries: entries, Reader: bytes.NewReader(nil)}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for filePath, data := range m {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(filePath, prefix)
+		entryName, _, isDir := strings.Cut(rest, "/")
+		if seen[entryName] {
+			continue
+		}
+		seen[entryName] = true
+		info := memInfo{name: entryName, dir: isDir}
+		if !isDir {
+			info.size = int64(len(data))
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+type memFile struct {
+	*bytes.Reader
+	info    memInfo
+	entries []fs.DirEntry
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// ReadDir implements fs.ReadDirFile.
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memInfo) Name() string { return i.name }
+func (i memInfo) Size() int64  { return i.size }
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
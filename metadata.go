@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// metadataField matches header line of migration describing it, i.e. "-- ticket: BILL-42".
+var metadataField = regexp.MustCompile(`(?i)^--\s*(author|ticket|description):\s*(.*?)\s*$`)
+
+// metadata links migration to its author and issue tracker ticket.
+type metadata struct {
+	Author      string
+	Ticket      string
+	Description string
+}
+
+// parseMetadata reads fields from header block of migration, which is comment lines before the first statement.
+func parseMetadata(body string) metadata {
+	var md metadata
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		match := metadataField.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		switch strings.ToLower(match[1]) {
+		case "author":
+			md.Author = match[2]
+		case "ticket":
+			md.Ticket = match[2]
+		case "description":
+			md.Description = match[2]
+		}
+	}
+	return md
+}
+
+// String returns short summary of metadata for listings, i.e. "[BILL-42 by alice] add invoices".
+func (md metadata) String() string {
+	var tags []string
+	if md.Ticket != "" {
+		tags = append(tags, md.Ticket)
+	}
+	if md.Author != "" {
+		tags = append(tags, "by "+md.Author)
+	}
+	s := ""
+	if len(tags) > 0 {
+		s = "[" + strings.Join(tags, " ") + "]"
+	}
+	if md.Description != "" {
+		s = strings.TrimSpace(s + " " + md.Description)
+	}
+	return s
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Migrations may be split into independent tracks placed in subdirectories of migrations dir,
+// so schema migrations can be applied during deploy and data migrations from a separate job.
+// Files placed directly into migrations dir belong to the default track.
+const (
+	trackDefault = ""
+	trackSchema  = "schema"
+	trackData    = "data"
+)
+
+// allTracks are tracks in order they are applied when no one is chosen.
+var allTracks = []string{trackDefault, trackSchema, trackData}
+
+// Several services may keep their migrations in one repository. Each such module is a
+// subdirectory of migrations dir declared in config, which has its own sequence of migrations
+// and optionally its own tracks. Files placed directly into migrations dir belong to the default module.
+const moduleDefault = ""
+
+// migrationFile is a migration read from migrations dir.
+type migrationFile struct {
+	Name   string
+	Body   string
+	Track  string
+	Module string
+
+	// Opens body of migration larger than stream threshold, Body keeps only its header then
+	stream func() (io.ReadCloser, error)
+	sum    string // checksum of streamed body
+	size   int64  // size of streamed body
+	// Computes checksum of body, sha256 of raw body by default
+	checksums checksummer
+	// CSV files of copy directives by their paths
+	copies map[string][]byte
+	// Source migration is listed in, body is not read until lazy is reset by loader
+	src    source
+	lazy   bool
+	loader *loader
+}
+
+// Path returns migration path relative to migrations dir, i.e. "billing/schema/0001_init.sql".
+func (m migrationFile) Path() string {
+	return path.Join(m.Module, m.Track, m.Name)
+}
+
+// Checksum returns checksum of migration body by configured scheme.
+func (m migrationFile) Checksum() string {
+	if m.stream != nil {
+		return m.sum
+	}
+	// Scheme of loaded migrations is validated by config
+	sum, _ := m.checksums.Sum(m.Body)
+	return sum
+}
+
+// ChecksumAs returns checksum of migration body by scheme of applied migration.
+func (m migrationFile) ChecksumAs(scheme string) (string, error) {
+	c := m.checksums.As(scheme)
+	switch {
+	case m.stream == nil:
+		return c.Sum(m.Body)
+	case c.Scheme() == m.checksums.Scheme():
+		return m.sum, nil
+	}
+	return streamSum(m.stream, c)
+}
+
+// matchesApplied reports whether checksum of migration file is the same as of applied migration.
+func matchesApplied(m Migration, f migrationFile) (bool, error) {
+	sum, err := f.ChecksumAs(m.ChecksumAlgorithm)
+	return sum == appliedChecksum(m), err
+}
+
+// Size returns size of migration body in bytes.
+func (m migrationFile) Size() int64 {
+	if m.stream != nil {
+		return m.size
+	}
+	return int64(len(m.Body))
+}
+
+// Record returns tracking row of the migration, only header of streamed migration is stored as its body.
+func (m migrationFile) Record() *Migration {
+	md := parseMetadata(m.Body)
+	return &Migration{Name: m.Name, Body: m.Body, Track: m.Track, Module: m.Module, Checksum: m.Checksum(),
+		Author: md.Author, Ticket: md.Ticket, Description: md.Description,
+		Kind: migrationKind(m.Body, m.Track), Size: m.Size(), ChecksumAlgorithm: m.checksums.Scheme()}
+}
+
+// gzipExt is extension of compressed migration files, they are decompressed before verification and execution.
+const gzipExt = ".gz"
+
+// downExt is extension of files reverting migrations.
+const downExt = ".down.sql"
+
+// loader reads migration files from sources and prepares their bodies to be verified and executed.
+type loader struct {
+	sources   []source
+	verifier  *signatureVerifier
+	variables map[string]string // nil if substitution is disabled
+	warnGaps  bool
+	pattern   *regexp.Regexp // file names must match it if set
+	// Files of this size and larger are streamed, zero disables streaming
+	streamThreshold int64
+	checksums       checksummer
+}
+
+// Read returns migrations of the module track merged from all sources and sorted by name.
+func (l *loader) Read(module, track string) ([]migrationFile, error) {
+	files, err := l.List(module, track)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		if err := l.Load(&files[i]); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// List returns migrations of the module track merged from all sources and sorted by name without reading them,
+// their bodies are read by Load.
+func (l *loader) List(module, track string) ([]migrationFile, error) {
+	dirName := path.Join(".", module, track)
+	var (
+		files []migrationFile
+		found bool
+		from  = make(map[string]string) // migration name to source it was read from
+	)
+	for _, src := range l.sources {
+		names, err := src.List(dirName)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s migrations dir: %w", src.Name, err)
+		}
+		found = true
+		for _, name := range names {
+			// Signatures, down files and others are read along with migrations when needed
+			if !isMigrationFile(name) {
+				continue
+			}
+			if l.pattern != nil && !l.pattern.MatchString(name) {
+				return nil, fmt.Errorf("migration file %s doesn't match naming pattern %s", path.Join(dirName, name), l.pattern)
+			}
+			m := migrationFile{Name: name, Track: track, Module: module, src: src, lazy: true, loader: l}
+			if other, ok := from[m.Name]; ok {
+				return nil, fmt.Errorf("migration %s is found in both %s and %s sources", m.Path(), other, src.Name)
+			}
+			from[m.Name] = src.Name
+			if src.overlay {
+				logrus.Infof("migration %s is taken from %s", m.Path(), src.Name)
+			}
+			files = append(files, m)
+		}
+	}
+	// Files of module itself must be present, while tracks are optional
+	if !found && track == trackDefault {
+		return nil, fmt.Errorf("migrations dir %s is not found", dirName)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	if err := checkVersions(files, l.warnGaps); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// loaded returns the migration with body read by loader which has listed it. Lists of pending migrations keep
+// them not loaded, so only one body at a time is held in memory.
+func (m migrationFile) loaded() (migrationFile, error) {
+	if !m.lazy || m.loader == nil {
+		return m, nil
+	}
+	err := m.loader.Load(&m)
+	return m, err
+}
+
+// Load reads body of migration returned by List, loaded migration is left as is.
+func (l *loader) Load(m *migrationFile) error {
+	if !m.lazy {
+		return nil
+	}
+	src, name := m.src, path.Join(".", m.Path())
+	m.checksums = l.checksums
+	if streamed, err := l.readStreamed(src, name, m); err != nil {
+		return fmt.Errorf("can't read migration file %s from %s source: %w", m.Path(), src.Name, err)
+	} else if streamed {
+		m.lazy = false
+		return nil
+	}
+	file, err := src.Read(name)
+	if err != nil {
+		return fmt.Errorf("can't read migration file %s from %s source: %w", m.Path(), src.Name, err)
+	}
+	if err := l.verifier.Verify(src, name, file); err != nil {
+		return err
+	}
+	if strings.HasSuffix(m.Name, gzipExt) {
+		if file, err = decompress(file); err != nil {
+			return fmt.Errorf("can't decompress migration file %s: %w", m.Path(), err)
+		}
+	}
+	if m.Body, err = l.inline(src, string(file), 0); err != nil {
+		return fmt.Errorf("can't read migration %s: %w", m.Path(), err)
+	}
+	if err := l.readCopies(src, m); err != nil {
+		return fmt.Errorf("can't read migration %s: %w", m.Path(), err)
+	}
+	if m.Body, err = l.render(*m, m.Body); err != nil {
+		return fmt.Errorf("can't render migration %s: %w", m.Path(), err)
+	}
+	m.lazy = false
+	return nil
+}
+
+// render prepares migration body read from file. The result is what is verified against applied migration
+// and what is executed, so the same file may be rendered differently in different environments.
+func (l *loader) render(m migrationFile, body string) (string, error) {
+	if strings.HasSuffix(strings.TrimSuffix(m.Name, gzipExt), templateExt) {
+		var err error
+		data := templateData{Vars: l.variables, Name: m.Name, Module: m.Module, Track: m.Track}
+		if body, err = renderTemplate(body, data); err != nil {
+			return "", err
+		}
+	}
+	if l.variables != nil {
+		return substituteVariables(body, l.variables)
+	}
+	return body, nil
+}
+
+// checksum returns hex encoded sha256 sum of migration body.
+func checksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// appliedChecksum returns checksum of applied migration, rows recorded by older versions have only body.
+func appliedChecksum(m Migration) string {
+	if m.Checksum != "" {
+		return m.Checksum
+	}
+	return checksum(m.Body)
+}
+
+// trimMigrationExt returns migration name without extensions.
+func trimMigrationExt(name string) string {
+	name = strings.TrimSuffix(name, gzipExt)
+	return strings.TrimSuffix(strings.TrimSuffix(name, templateExt), ".sql")
+}
+
+// isMigrationFile reports whether file is SQL script or its template, both may be gzipped.
+// Files like 0001_init.down.sql are reserved for rollbacks and are not migrations.
+func isMigrationFile(name string) bool {
+	name = strings.TrimSuffix(name, gzipExt)
+	if strings.HasSuffix(strings.TrimSuffix(name, templateExt), downExt) {
+		return false
+	}
+	return path.Ext(strings.TrimSuffix(name, templateExt)) == ".sql"
+}
+
+// decompress returns content of gzipped migration file.
+func decompress(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// verifyAppliedLazily checks applied migrations against listed files reading them one by one. Bodies are compared
+// only if checksums differ. All files are read at once only if names don't match, i.e. to find renamed ones.
+func verifyAppliedLazily(db *gorm.DB, l *loader, store VersionStore, applied []Migration, files []migrationFile, config Config) error {
+	matched := len(applied) <= len(files)
+	for i := 0; matched && i < len(applied); i++ {
+		matched = applied[i].Name == files[i].Name
+	}
+	if !matched {
+		for i := range files {
+			if err := l.Load(&files[i]); err != nil {
+				return err
+			}
+		}
+		verifyApplied(db, store, applied, files, config)
+		return nil
+	}
+	for i := range applied {
+		f := files[i]
+		if err := l.Load(&f); err != nil {
+			return err
+		}
+		if ok, err := matchesApplied(applied[i], f); err != nil {
+			return err
+		} else if !ok {
+			verifyApplied(db, store, applied[i:i+1], []migrationFile{f}, config)
+		}
+	}
+	return nil
+}
+
+// verifyApplied checks that applied migrations are the same as the first migration files.
+// Bodies of applied migrations are fetched from the store only if checksums differ. In interactive mode changed
+// migration may be accepted instead of failing.
+func verifyApplied(db *gorm.DB, store VersionStore, applied []Migration, files []migrationFile, config Config) {
+	checkRenames(store, applied, files, config)
+	for i := range applied {
+		if len(files) <= i {
+			logrus.Fatalf("migration %s was removed", applied[i].Name)
+		}
+		ok, err := matchesApplied(applied[i], files[i])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ok {
+			continue
+		}
+
+		if files[i].stream != nil {
+			events.OnChecksumMismatch(files[i].Path())
+			annotate("error", files[i].Path(), 0, fmt.Sprintf("migration %s was changed after it had been applied", files[i].Path()))
+			logrus.Fatalf("migration %s was changed, checksum of streamed file doesn't match", applied[i].Name)
+		}
+		body, err := appliedBody(store, applied[i])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if body == "" && applied[i].Checksum != "" {
+			// Body is not stored, it is taken from history of the file
+			var found bool
+			if body, found = newVCSInfo(config, "").AppliedBody(applied[i], files[i]); !found {
+				events.OnChecksumMismatch(files[i].Path())
+				annotate("error", files[i].Path(), 0, fmt.Sprintf("migration %s was changed after it had been applied", files[i].Path()))
+				logrus.Fatalf("migration %s was changed, its applied body isn't stored nor found in git history to diff", applied[i].Name)
+			}
+		}
+		appliedBody := config.Normalization.Apply(body)
+		fileBody := config.Normalization.Apply(files[i].Body)
+		if config.Verification == verificationRelaxed && canonicalSQL(appliedBody) == canonicalSQL(fileBody) {
+			continue
+		}
+		if fileBody != appliedBody {
+			reportChange(config, files[i].Path(), appliedBody, fileBody)
+			events.OnChecksumMismatch(files[i].Path())
+			if interactive && resolveMismatch(db, store, applied[i], files[i], appliedBody, fileBody) {
+				continue
+			}
+			annotate("error", files[i].Path(), firstChangedLine(appliedBody, fileBody), fmt.Sprintf("migration %s was changed after it had been applied", files[i].Path()))
+			logrus.Fatalf("migration %s was changed", applied[i].Name)
+		}
+	}
+}
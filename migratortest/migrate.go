@@ -0,0 +1,273 @@
+package migratortest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	_ "github.com/jackc/pgx/v4/stdlib" // registers pgx driver
+)
+
+// Package is import path of migrator main package, it is built with embedded migrations into the binary
+// applying them to test databases, so tests run the code of the module they depend on.
+// MIGRATOR_BIN environment variable overrides path of the binary instead.
+var Package = "migrator"
+
+var (
+	templates sync.Map // server DSN and binary checksum to name of template database
+	server    struct {
+		once sync.Once
+		dsn  string
+		err  error
+	}
+	built struct {
+		once sync.Once
+		path string
+		err  error
+	}
+)
+
+// Connection parameters of DSN set in config of the binary, numbers are durations in the unit
+var configParams = map[string]struct{ name, unit string }{
+	"sslmode":           {"sslMode", ""},
+	"connect_timeout":   {"connectTimeout", "s"},
+	"statement_timeout": {"statementTimeout", "ms"},
+	"lock_timeout":      {"lockTimeout", "ms"},
+}
+
+// Connection parameters of DSN passed to the binary by environment of libpq
+var envParams = map[string]string{
+	"sslrootcert":      "PGSSLROOTCERT",
+	"sslcert":          "PGSSLCERT",
+	"sslkey":           "PGSSLKEY",
+	"application_name": "PGAPPNAME",
+}
+
+// MigrateTestDB creates a database with all migrations applied on the server given by DSN and returns its DSN.
+// Migrations are applied once into a template database, which is reused until the binary changes,
+// so every test gets its own database quickly. The database is dropped on test cleanup.
+func MigrateTestDB(t testing.TB, dsn string) string {
+	t.Helper()
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	template, err := templateDB(admin, dsn)
+	if err != nil {
+		t.Fatalf("can't prepare migrated template database: %v", err)
+	}
+	name := "migrator_test_" + randomSuffix()
+	if _, err := admin.Exec(fmt.Sprintf("CREATE DATABASE %q TEMPLATE %q", name, template)); err != nil {
+		t.Fatalf("can't create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		admin, err := sql.Open("pgx", dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %q", name)); err != nil {
+			t.Errorf("can't drop test database %s: %v", name, err)
+		}
+	})
+	return withDatabase(dsn, name)
+}
+
+// WithMigratedDB calls fn with connection to a migrated test database. Server is taken from
+// MIGRATOR_TEST_DSN environment variable, otherwise disposable one is started once for all tests.
+func WithMigratedDB(t testing.TB, fn func(db *sql.DB)) {
+	t.Helper()
+	server.once.Do(func() {
+		if server.dsn = os.Getenv("MIGRATOR_TEST_DSN"); server.dsn != "" {
+			return
+		}
+		// Container is left running for the following tests, it is removed by testcontainers reaper
+		var p *Postgres
+		if p, server.err = StartPostgres(context.Background(), DefaultImage); server.err == nil {
+			server.dsn = p.DSN()
+		}
+	})
+	if server.err != nil {
+		t.Fatal(server.err)
+	}
+	db, err := sql.Open("pgx", MigrateTestDB(t, server.dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	fn(db)
+}
+
+// templateDB returns name of template database migrated by current binary, creating it if needed.
+func templateDB(admin *sql.DB, dsn string) (string, error) {
+	binary, err := binaryPath()
+	if err != nil {
+		return "", err
+	}
+	sum, err := fileChecksum(binary)
+	if err != nil {
+		return "", err
+	}
+	key := dsn + "#" + sum
+	if name, ok := templates.Load(key); ok {
+		return name.(string), nil
+	}
+
+	name := "migrator_template_" + sum[:16]
+	var exists bool
+	if err := admin.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+		return "", err
+	}
+	if !exists {
+		// Template is migrated under temporary name and renamed, so concurrent test binaries never see it half-done
+		tmp := name + "_" + randomSuffix()
+		if _, err := admin.Exec(fmt.Sprintf("CREATE DATABASE %q", tmp)); err != nil {
+			return "", err
+		}
+		if err := migrate(binary, withDatabase(dsn, tmp)); err != nil {
+			_, _ = admin.Exec(fmt.Sprintf("DROP DATABASE %q", tmp))
+			return "", err
+		}
+		if _, err := admin.Exec(fmt.Sprintf("ALTER DATABASE %q RENAME TO %q", tmp, name)); err != nil {
+			// Another process has created the same template meanwhile
+			_, _ = admin.Exec(fmt.Sprintf("DROP DATABASE %q", tmp))
+		} else if _, err := admin.Exec(fmt.Sprintf("ALTER DATABASE %q WITH IS_TEMPLATE true ALLOW_CONNECTIONS false", name)); err != nil {
+			// Copying fails while anyone is connected to the template, so connections to it are disallowed
+			return "", err
+		}
+	}
+	templates.Store(key, name)
+	return name, nil
+}
+
+// migrate runs migrator binary against database given by DSN with generated config. Connection parameters
+// of DSN are passed to the binary too.
+func migrate(binary, dsn string) error {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return err
+	}
+	password, _ := u.User.Password()
+	port := u.Port()
+	if port == "" {
+		port = "5432"
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return fmt.Errorf("invalid port in DSN: %w", err)
+	}
+	config := fmt.Sprintf("logLevel: error\ndatabase:\n  name: %q\n  host: %q\n  port: %s\n  user: %q\n  password: %q\n",
+		u.Path[1:], u.Hostname(), port, u.User.Username(), password)
+	env := os.Environ()
+	for key, values := range u.Query() {
+		value := values[0]
+		if name, ok := envParams[key]; ok {
+			env = append(env, name+"="+value)
+			continue
+		}
+		setting, ok := configParams[key]
+		if !ok {
+			return fmt.Errorf("parameter %s of DSN isn't supported by migrator config", key)
+		}
+		if setting.unit != "" {
+			if _, err := strconv.Atoi(value); err != nil {
+				return fmt.Errorf("invalid %s of DSN %q", key, value)
+			}
+		}
+		config += fmt.Sprintf("  %s: %q\n", setting.name, value+setting.unit)
+	}
+
+	dir, err := os.MkdirTemp("", "migratortest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		return err
+	}
+	cmd := exec.Command(binary, "up", "-quiet")
+	cmd.Env = append(env, "MIGRATOR_CONFIG="+path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("migrator has failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// binaryPath returns path of the binary given by MIGRATOR_BIN or builds Package once per process.
+func binaryPath() (string, error) {
+	if path := os.Getenv("MIGRATOR_BIN"); path != "" {
+		return path, nil
+	}
+	built.once.Do(func() {
+		built.path, built.err = build()
+	})
+	return built.path, built.err
+}
+
+// build builds Package into user cache directory. The binary is written under temporary name and renamed,
+// so concurrent test binaries don't see it half-written.
+func build() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	dir := filepath.Join(cache, "migratortest")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "migrator")
+	tmp := path + "-" + randomSuffix()
+	cmd := exec.Command("go", "build", "-trimpath", "-o", tmp, Package)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("can't build %s: %w: %s", Package, err, out)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// withDatabase returns DSN with database name replaced.
+func withDatabase(dsn, name string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	u.Path = "/" + name
+	return u.String()
+}
+
+func randomSuffix() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
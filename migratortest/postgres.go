@@ -0,0 +1,85 @@
+// Package migratortest helps to run tests against disposable postgres servers.
+package migratortest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// DefaultImage is docker image of postgres started by default.
+const DefaultImage = "postgres:16-alpine"
+
+// Postgres is a disposable server started in docker by testcontainers.
+type Postgres struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+
+	container *testcontainers.DockerContainer
+	stop      sync.Once
+}
+
+// StartPostgres starts postgres of the given image and waits until it accepts connections.
+func StartPostgres(ctx context.Context, image string) (*Postgres, error) {
+	p := &Postgres{User: "migrator", Password: "migrator", Database: "migrator"}
+	container, err := testcontainers.Run(ctx, image,
+		testcontainers.WithExposedPorts("5432/tcp"),
+		testcontainers.WithEnv(map[string]string{
+			"POSTGRES_USER":     p.User,
+			"POSTGRES_PASSWORD": p.Password,
+			"POSTGRES_DB":       p.Database,
+		}),
+		// Server is restarted once after initialization, so the message is printed twice
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		if container != nil {
+			_ = testcontainers.TerminateContainer(container)
+		}
+		return nil, fmt.Errorf("can't start postgres container: %w", err)
+	}
+	p.container = container
+	if p.Host, err = container.Host(ctx); err != nil {
+		p.Stop()
+		return nil, err
+	}
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		p.Stop()
+		return nil, err
+	}
+	p.Port = int(port.Num())
+	return p, nil
+}
+
+// DSN returns URL to connect to the server.
+func (p *Postgres) DSN() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable", p.User, p.Password, p.Host, p.Port, p.Database)
+}
+
+// Stop terminates the container, it is safe to call it several times.
+func (p *Postgres) Stop() {
+	p.stop.Do(func() {
+		_ = testcontainers.TerminateContainer(p.container)
+	})
+}
+
+// PostgresDSN starts disposable postgres for the test and returns its DSN, the server is stopped on cleanup.
+func PostgresDSN(t testing.TB) string {
+	t.Helper()
+	p, err := StartPostgres(context.Background(), DefaultImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Stop)
+	return p.DSN()
+}
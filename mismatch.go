@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// interactive enables resolution of checksum mismatches on terminal, it is set by -interactive flag.
+var interactive bool
+
+// resolveMismatch asks on terminal what to do with applied migration changed in its file: show full diff,
+// accept the file recording its body and checksum instead of applied ones, i.e. after reformatting, or abort.
+// It reports whether the file is accepted.
+func resolveMismatch(db *gorm.DB, store VersionStore, m Migration, f migrationFile, appliedBody, fileBody string) bool {
+	if !isTerminal(os.Stdin) {
+		logrus.Error("can't resolve mismatch interactively without terminal")
+		return false
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Migration %s was changed after it had been applied: [d]iff in full, [a]ccept file, a[b]ort? ", f.Path())
+		answer, err := in.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "d", "diff":
+			fmt.Print(unifiedDiffContext("applied/"+f.Path(), f.Path(), appliedBody, fileBody, -1))
+		case "a", "accept":
+			if err := replaceApplied(db, store, m, f); err != nil {
+				logrus.WithError(err).Errorf("can't accept migration %s", f.Path())
+				return false
+			}
+			logrus.Warnf("changed migration %s is accepted, its tracking row is updated", f.Path())
+			return true
+		case "b", "abort", "":
+			return false
+		}
+	}
+}
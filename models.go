@@ -0,0 +1,5 @@
+package main
+
+// models of the application are listed here to be compared against the live schema by diff-models command,
+// i.e. []interface{}{&User{}, &Order{}}.
+var models []interface{}
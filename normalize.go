@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Normalization is applied to migration bodies before they are compared with applied ones,
+// so files edited on different platforms don't trigger false "migration was changed" failures.
+type Normalization struct {
+	StripBOM               bool `yaml:"stripBOM"`
+	LineEndings            bool `yaml:"lineEndings"` // CRLF and CR are replaced by LF
+	TrimTrailingWhitespace bool `yaml:"trimTrailingWhitespace"`
+}
+
+// defaultNormalization is used unless config overrides it.
+var defaultNormalization = Normalization{StripBOM: true, LineEndings: true}
+
+// Apply returns normalized body.
+func (n Normalization) Apply(body string) string {
+	if n.StripBOM {
+		body = strings.TrimPrefix(body, bom)
+	}
+	if n.LineEndings {
+		body = strings.Replace(body, "\r\n", "\n", -1)
+		body = strings.Replace(body, "\r", "\n", -1)
+	}
+	if n.TrimTrailingWhitespace {
+		lines := strings.Split(body, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " \t\r")
+		}
+		body = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	}
+	return body
+}
+
+// Writer returns writer normalizing body written to it in chunks the same way as Apply does into w.
+// Close has to be called after the last chunk to write trailing part of the body.
+func (n Normalization) Writer(w io.Writer) io.WriteCloser {
+	return &normalizer{n: n, w: w, started: !n.StripBOM}
+}
+
+// normalizer normalizes streamed body, only the current line is kept when trailing whitespace is trimmed.
+type normalizer struct {
+	n        Normalization
+	w        io.Writer
+	started  bool   // leading BOM has been checked
+	head     []byte // leading bytes of body which may be BOM
+	cr       bool   // previous byte was CR ending a line
+	line     []byte // current line if trailing whitespace is trimmed
+	newlines int    // line breaks written only if not trailing
+	out      []byte
+}
+
+// bom is byte order mark stripped from the beginning of body
+const bom = "\uFEFF"
+
+func (n *normalizer) Write(p []byte) (int, error) {
+	size := len(p)
+	if !n.started {
+		n.head = append(n.head, p...)
+		if len(n.head) < len(bom) && strings.HasPrefix(bom, string(n.head)) {
+			return size, nil
+		}
+		p, n.head, n.started = bytes.TrimPrefix(n.head, []byte(bom)), nil, true
+	}
+	n.out = n.out[:0]
+	for _, b := range p {
+		if n.n.LineEndings {
+			if n.cr {
+				n.cr = false
+				if b == '\n' {
+					continue
+				}
+			}
+			if b == '\r' {
+				n.cr = true
+				n.endLine()
+				continue
+			}
+		}
+		if b == '\n' {
+			n.endLine()
+			continue
+		}
+		if n.n.TrimTrailingWhitespace {
+			n.line = append(n.line, b)
+		} else {
+			n.out = append(n.out, b)
+		}
+	}
+	if _, err := n.w.Write(n.out); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (n *normalizer) endLine() {
+	if !n.n.TrimTrailingWhitespace {
+		n.out = append(n.out, '\n')
+		return
+	}
+	n.writeLine()
+	n.newlines++
+}
+
+// writeLine writes current line without trailing whitespace preceded by pending line breaks unless it is empty.
+func (n *normalizer) writeLine() {
+	if line := bytes.TrimRight(n.line, " \t\r"); len(line) > 0 {
+		for ; n.newlines > 0; n.newlines-- {
+			n.out = append(n.out, '\n')
+		}
+		n.out = append(n.out, line...)
+	}
+	n.line = n.line[:0]
+}
+
+func (n *normalizer) Close() error {
+	if !n.started {
+		head := n.head
+		n.head, n.started = nil, true
+		if _, err := n.Write(head); err != nil {
+			return err
+		}
+	}
+	n.out = n.out[:0]
+	if n.n.TrimTrailingWhitespace {
+		n.writeLine()
+	}
+	_, err := n.w.Write(n.out)
+	return err
+}
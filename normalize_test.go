@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizationApply(t *testing.T) {
+	all := Normalization{StripBOM: true, LineEndings: true, TrimTrailingWhitespace: true}
+	tests := []struct {
+		name string
+		n    Normalization
+		body string
+		want string
+	}{
+		{"nothing", Normalization{}, bom + "a \r\nb\r", bom + "a \r\nb\r"},
+		{"bom", Normalization{StripBOM: true}, bom + "SELECT 1;" + bom, "SELECT 1;" + bom},
+		{"crlf", Normalization{LineEndings: true}, "a\r\nb\rc\r\r\nd", "a\nb\nc\n\nd"},
+		{"trailing whitespace", Normalization{TrimTrailingWhitespace: true}, "a  \nb\t\r\n\n  \n", "a\nb"},
+		{"leading lines kept", Normalization{TrimTrailingWhitespace: true}, "\n\n  a \n", "\n\n  a"},
+		{"default", defaultNormalization, bom + "a \r\nb\r\n", "a \nb\n"},
+		{"all", all, bom + "a \r\n\r\nb\t\r", "a\n\nb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.n.Apply(tt.body); got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizationWriter(t *testing.T) {
+	bodies := []string{
+		"", bom, "\xef\xbb", bom + bom + "x", bom + "a\r\nb  \r\n\r\n  \n",
+		"a\r\r\nb\rc", "\n\nx \t\n\n", "abc\r", "  \r\n", "a\tb \t\r\nc",
+	}
+	var normalizations []Normalization
+	for i := 0; i < 8; i++ {
+		normalizations = append(normalizations, Normalization{StripBOM: i&1 != 0, LineEndings: i&2 != 0,
+			TrimTrailingWhitespace: i&4 != 0})
+	}
+	for _, n := range normalizations {
+		for _, body := range bodies {
+			// Body is written by chunks of each size, so line breaks and BOM are split between writes
+			for size := 1; size <= len(body)+1; size++ {
+				var out strings.Builder
+				w := n.Writer(&out)
+				for i := 0; i < len(body); i += size {
+					if _, err := w.Write([]byte(body[i:min(i+size, len(body))])); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if want := n.Apply(body); out.String() != want {
+					t.Errorf("%+v writer of %q by %d bytes = %q, want %q", n, body, size, out.String(), want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Media types of migrations packaged as OCI artifact.
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociArtifactType = "application/vnd.migrator.migrations.v1"
+	ociLayerType    = "application/vnd.migrator.migrations.layer.v1.tar+gzip"
+	ociEmptyType    = "application/vnd.oci.empty.v1+json"
+)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	ArtifactType  string          `json:"artifactType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociRegistry is a client of OCI distribution API for a single repository.
+// Credentials are taken from docker config, so "docker login" is enough to use a private registry.
+type ociRegistry struct {
+	baseURL    string
+	host       string
+	repository string
+	reference  string // tag or digest
+	client     *http.Client
+	username   string
+	password   string
+	token      string
+}
+
+// newOCIRegistry parses reference like oci://registry.example.com/app/migrations:1.4.0 or pinned by digest
+// oci://registry.example.com/app/migrations@sha256:<hex>.
+func newOCIRegistry(ref string) (*ociRegistry, error) {
+	name := strings.TrimPrefix(ref, "oci://")
+	host, repository, ok := strings.Cut(name, "/")
+	if !ok || host == "" || repository == "" {
+		return nil, fmt.Errorf("invalid artifact reference %s", ref)
+	}
+	r := &ociRegistry{host: host, client: &http.Client{Timeout: 5 * time.Minute}}
+	if i := strings.LastIndex(repository, "@"); i >= 0 {
+		// Tag of reference like app/migrations:1.4.0@sha256:... printed by push is ignored, digest pins the manifest
+		r.repository, r.reference = repository[:i], repository[i+1:]
+		if j := strings.LastIndex(r.repository, ":"); j > strings.LastIndex(r.repository, "/") {
+			r.repository = r.repository[:j]
+		}
+	} else if i := strings.LastIndex(repository, ":"); i >= 0 {
+		r.repository, r.reference = repository[:i], repository[i+1:]
+	} else {
+		r.repository, r.reference = repository, "latest"
+	}
+
+	scheme := "https"
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		scheme = "http"
+	}
+	apiHost := host
+	if host == "docker.io" {
+		apiHost = "registry-1.docker.io"
+	}
+	r.baseURL = fmt.Sprintf("%s://%s/v2/%s", scheme, apiHost, r.repository)
+
+	var err error
+	if r.username, r.password, err = dockerCredentials(host); err != nil {
+		return nil, fmt.Errorf("can't get registry credentials: %w", err)
+	}
+	return r, nil
+}
+
+// Pull downloads migrations layer of the artifact and verifies its digest. Manifest of reference pinned
+// by digest is verified against it before its layers are trusted.
+func (r *ociRegistry) Pull() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, r.baseURL+"/manifests/"+r.reference, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ociManifestType)
+	body, _, err := r.do(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't get manifest: %w", err)
+	}
+	if strings.HasPrefix(r.reference, "sha256:") && digest(body) != r.reference {
+		return nil, fmt.Errorf("digest of downloaded manifest doesn't match %s", r.reference)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("can't decode manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != ociLayerType {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodGet, r.baseURL+"/blobs/"+layer.Digest, nil)
+		if err != nil {
+			return nil, err
+		}
+		data, _, err := r.do(req, nil)
+		if err != nil {
+			return nil, fmt.Errorf("can't download layer: %w", err)
+		}
+		if digest(data) != layer.Digest {
+			return nil, fmt.Errorf("digest of downloaded layer doesn't match %s", layer.Digest)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("artifact has no layer of %s type", ociLayerType)
+}
+
+// Push uploads layer and tags the artifact manifest, returning manifest digest.
+func (r *ociRegistry) Push(layer []byte) (string, error) {
+	config := []byte("{}")
+	for _, blob := range [][]byte{config, layer} {
+		if err := r.uploadBlob(blob); err != nil {
+			return "", err
+		}
+	}
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		ArtifactType:  ociArtifactType,
+		Config:        ociDescriptor{MediaType: ociEmptyType, Digest: digest(config), Size: int64(len(config))},
+		Layers:        []ociDescriptor{{MediaType: ociLayerType, Digest: digest(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPut, r.baseURL+"/manifests/"+r.reference, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", ociManifestType)
+	if _, _, err := r.do(req, manifest); err != nil {
+		return "", fmt.Errorf("can't put manifest: %w", err)
+	}
+	return digest(manifest), nil
+}
+
+func (r *ociRegistry) uploadBlob(blob []byte) error {
+	// Registry may already have the blob
+	req, err := http.NewRequest(http.MethodHead, r.baseURL+"/blobs/"+digest(blob), nil)
+	if err != nil {
+		return err
+	}
+	if _, _, err := r.do(req, nil); err == nil {
+		return nil
+	}
+
+	req, err = http.NewRequest(http.MethodPost, r.baseURL+"/blobs/uploads/", nil)
+	if err != nil {
+		return err
+	}
+	_, header, err := r.do(req, nil)
+	if err != nil {
+		return fmt.Errorf("can't start blob upload: %w", err)
+	}
+	location, err := req.URL.Parse(header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest(blob))
+	location.RawQuery = query.Encode()
+	req, err = http.NewRequest(http.MethodPut, location.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if _, _, err := r.do(req, blob); err != nil {
+		return fmt.Errorf("can't upload blob: %w", err)
+	}
+	return nil
+}
+
+// do sends request with the body, authorizing by bearer token or basic credentials on demand.
+func (r *ociRegistry) do(req *http.Request, body []byte) ([]byte, http.Header, error) {
+	for attempt := 0; ; attempt++ {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		switch {
+		case r.token != "":
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		case r.username != "":
+			req.SetBasicAuth(r.username, r.password)
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := r.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return nil, nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, data)
+		}
+		return data, resp.Header, nil
+	}
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize gets bearer token as requested by registry challenge.
+func (r *ociRegistry) authorize(challenge string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		if r.username == "" {
+			return fmt.Errorf("registry requires authorization, use docker login")
+		}
+		// Basic credentials are already sent
+		return fmt.Errorf("registry rejected credentials")
+	}
+	params := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	u, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid authorization challenge %q", challenge)
+	}
+	query := u.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + r.repository + ":pull,push"
+	}
+	query.Set("scope", scope)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't get registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't get registry token: unexpected response status %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("can't decode registry token: %w", err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	return nil
+}
+
+// dockerCredentials returns credentials of the registry stored by "docker login",
+// either in docker config itself or in configured credential helper.
+func dockerCredentials(host string) (string, string, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", fmt.Errorf("can't decode docker config: %w", err)
+	}
+
+	key := host
+	if host == "docker.io" {
+		key = "https://index.docker.io/v1/"
+	}
+	helper := config.CredsStore
+	if h, ok := config.CredHelpers[key]; ok {
+		helper = h
+	}
+	if helper != "" {
+		cmd := exec.Command("docker-credential-"+helper, "get")
+		cmd.Stdin = strings.NewReader(key)
+		out, err := cmd.Output()
+		if err != nil {
+			// Helper fails when it has no credentials for the registry
+			return "", "", nil
+		}
+		var creds struct {
+			Username string
+			Secret   string
+		}
+		if err := json.Unmarshal(out, &creds); err != nil {
+			return "", "", fmt.Errorf("can't decode credentials of docker-credential-%s: %w", helper, err)
+		}
+		return creds.Username, creds.Secret, nil
+	}
+
+	auth, ok := config.Auths[key]
+	if !ok {
+		auth, ok = config.Auths["https://"+key]
+	}
+	if !ok || auth.Auth == "" {
+		return "", "", nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid auth of %s in docker config: %w", key, err)
+	}
+	username, password, _ := strings.Cut(string(decoded), ":")
+	return username, password, nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// maxPacingPoll limits doubling interval of load checks while the next migration waits.
+const maxPacingPoll = time.Minute
+
+// Pacing spreads a large batch of migrations over time, so a busy primary isn't loaded continuously.
+type Pacing struct {
+	// Pause between consecutive migrations
+	Pause time.Duration `yaml:"pause"`
+	// Next migration waits while more client sessions are active on the primary, 0 disables the check
+	MaxActiveSessions int `yaml:"maxActiveSessions" binding:"gte=0"`
+	// Next migration waits while lag of any replica of replicas section exceeds it
+	MaxReplicaLag time.Duration `yaml:"maxReplicaLag"`
+	// Longest wait for load to drop, the run goes on with a warning after it, unlimited by default
+	MaxWait time.Duration `yaml:"maxWait"`
+}
+
+// pace pauses before the next migration and waits while the load exceeds limits, polling it with backoff
+// starting from the pause.
+func pace(db *gorm.DB, replicas []replica, c Pacing) error {
+	if c.Pause > 0 {
+		logrus.Debugf("pausing for %s before the next migration", c.Pause)
+		time.Sleep(c.Pause)
+	}
+	if c.MaxActiveSessions <= 0 && c.MaxReplicaLag <= 0 {
+		return nil
+	}
+	started := time.Now()
+	poll := c.Pause
+	if poll <= 0 {
+		poll = time.Second
+	}
+	for {
+		busy, err := overloaded(db, replicas, c)
+		if err != nil || busy == "" {
+			return err
+		}
+		if c.MaxWait > 0 && time.Since(started) >= c.MaxWait {
+			logrus.Warnf("%s for %s, the next migration is applied anyway", busy, c.MaxWait)
+			return nil
+		}
+		logrus.Infof("%s, the next migration waits for %s", busy, poll)
+		time.Sleep(poll)
+		if poll *= 2; poll > maxPacingPoll {
+			poll = maxPacingPoll
+		}
+	}
+}
+
+// overloaded describes load exceeding limits of pacing, empty if there is no such load.
+func overloaded(db *gorm.DB, replicas []replica, c Pacing) (string, error) {
+	if c.MaxActiveSessions > 0 {
+		var active int
+		err := db.Raw("SELECT count(*) FROM pg_stat_activity WHERE state = 'active' AND backend_type = 'client backend' " +
+			"AND pid <> pg_backend_pid()").Scan(&active).Error
+		if err != nil {
+			return "", fmt.Errorf("can't count active sessions: %w", err)
+		}
+		if active > c.MaxActiveSessions {
+			return fmt.Sprintf("%d sessions are active, more than %d", active, c.MaxActiveSessions), nil
+		}
+	}
+	if c.MaxReplicaLag > 0 {
+		for _, r := range replicas {
+			lag, err := r.Lag()
+			if err != nil {
+				return "", err
+			}
+			if lag > c.MaxReplicaLag {
+				return fmt.Sprintf("lag of replica %s is %s, more than %s", r.name, lag.Round(time.Second), c.MaxReplicaLag), nil
+			}
+		}
+	}
+	return "", nil
+}
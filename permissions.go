@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Permissions are ownership and grant expectations verified after migrations are applied,
+// so migration which forgot grants is caught by the run itself.
+type Permissions struct {
+	// Violations either fail the run or are only logged: fail or warn
+	Policy       string                  `yaml:"policy"       binding:"omitempty,oneof=fail warn"`
+	Expectations []PermissionExpectation `yaml:"expectations" binding:"dive"`
+}
+
+// PermissionExpectation applies to all tables and views of the schema.
+type PermissionExpectation struct {
+	Schema string `yaml:"schema" binding:"required"`
+	Owner  string `yaml:"owner"`
+	// Privileges by roles, i.e. app_rw: [SELECT, INSERT]
+	Grants map[string][]string `yaml:"grants"`
+}
+
+// verifyPermissions logs or fails on violations of configured permissions.
+func verifyPermissions(db *gorm.DB, config Permissions) error {
+	var violations []string
+	for _, e := range config.Expectations {
+		found, err := permissionViolations(db, e)
+		if err != nil {
+			return err
+		}
+		violations = append(violations, found...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	if config.Policy == "warn" {
+		for _, v := range violations {
+			logrus.Warn(v)
+		}
+		return nil
+	}
+	return fmt.Errorf("%d permission expectations are violated:\n%s", len(violations), strings.Join(violations, "\n"))
+}
+
+// relationsOfSchema selects tables, views and foreign tables of the schema.
+const relationsOfSchema = `FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = ? AND c.relkind IN ('r', 'p', 'v', 'm', 'f')`
+
+func permissionViolations(db *gorm.DB, e PermissionExpectation) ([]string, error) {
+	var violations []string
+	if e.Owner != "" {
+		var rows []struct{ Name, Owner string }
+		err := db.Raw("SELECT c.relname AS name, pg_get_userbyid(c.relowner) AS owner "+relationsOfSchema+
+			" AND pg_get_userbyid(c.relowner) <> ? ORDER BY c.relname", e.Schema, e.Owner).Scan(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("can't get owners of schema %s: %w", e.Schema, err)
+		}
+		for _, row := range rows {
+			violations = append(violations, fmt.Sprintf("%s.%s is owned by %s, expected %s", e.Schema, row.Name, row.Owner, e.Owner))
+		}
+	}
+
+	roles := make([]string, 0, len(e.Grants))
+	for role := range e.Grants {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		for _, privilege := range e.Grants[role] {
+			var names []string
+			err := db.Raw("SELECT c.relname "+relationsOfSchema+" AND NOT has_table_privilege(?, c.oid, ?) ORDER BY c.relname",
+				e.Schema, role, privilege).Scan(&names).Error
+			if err != nil {
+				return nil, fmt.Errorf("can't check %s privilege of %s in schema %s: %w", privilege, role, e.Schema, err)
+			}
+			for _, name := range names {
+				violations = append(violations, fmt.Sprintf("%s has no %s privilege on %s.%s", role, privilege, e.Schema, name))
+			}
+		}
+	}
+	return violations, nil
+}
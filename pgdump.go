@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// pgDumpCommand returns pg_dump command against configured database writing its errors into stderr.
+func pgDumpCommand(config Config, stderr io.Writer, args ...string) *exec.Cmd {
+	args = append([]string{
+		"--host", config.Database.Host,
+		"--port", strconv.Itoa(config.Database.Port),
+		"--username", config.Database.User,
+		"--dbname", config.Database.Name,
+		"--no-password",
+	}, args...)
+	cmd := exec.Command("pg_dump", args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Database.Password)
+	if config.Database.SSLMode != "" {
+		cmd.Env = append(cmd.Env, "PGSSLMODE="+config.Database.SSLMode)
+	}
+	cmd.Stderr = stderr
+	return cmd
+}
+
+// pgDump runs pg_dump against configured database and returns its output.
+func pgDump(config Config, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	out, err := pgDumpCommand(config, &stderr, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("pg_dump has failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// pgDumpStream starts pg_dump against configured database and returns its output as it is written.
+// Failure of pg_dump is returned by the read of the end of output instead of io.EOF, so a partial dump
+// isn't taken for a complete one. Close stops pg_dump if its output isn't read to the end.
+func pgDumpStream(config Config, args ...string) (io.ReadCloser, error) {
+	r := &dumpReader{}
+	r.cmd = pgDumpCommand(config, &r.stderr, args...)
+	var err error
+	if r.out, err = r.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := r.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("can't run pg_dump: %w", err)
+	}
+	return r, nil
+}
+
+// dumpReader reads output of running pg_dump.
+type dumpReader struct {
+	cmd    *exec.Cmd
+	out    io.Reader
+	stderr bytes.Buffer
+	done   bool
+	err    error // returned by reads after pg_dump has exited
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, r.err
+	}
+	n, err := r.out.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	r.done, r.err = true, io.EOF
+	if err := r.cmd.Wait(); err != nil {
+		r.err = fmt.Errorf("pg_dump has failed: %w: %s", err, strings.TrimSpace(r.stderr.String()))
+	}
+	return n, r.err
+}
+
+func (r *dumpReader) Close() error {
+	if r.done {
+		return nil
+	}
+	r.done, r.err = true, io.ErrClosedPipe
+	_ = r.cmd.Process.Kill()
+	_ = r.cmd.Wait()
+	return nil
+}
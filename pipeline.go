@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PipelineStage is a step of pipeline: command of migrator itself or shell command.
+type PipelineStage struct {
+	Name string `yaml:"name" binding:"required"`
+	// Command of migrator with its flags, i.e. [up, -track, data]
+	Run []string `yaml:"run" binding:"required_without=Command"`
+	// Shell command, i.e. notification, it gets MIGRATOR_PIPELINE_STATUS and MIGRATOR_FAILED_STAGES variables
+	Command string `yaml:"command" binding:"required_without=Run"`
+	// Stage runs even if earlier ones have failed, otherwise it is skipped
+	Always bool `yaml:"always"`
+}
+
+// pipelineReport is combined report of pipeline with reports of migrator stages.
+type pipelineReport struct {
+	Status     string          `json:"status"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	Stages     []pipelineStage `json:"stages"`
+}
+
+type pipelineStage struct {
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	ExitCode int             `json:"exitCode"`
+	Duration float64         `json:"durationSeconds"`
+	Report   json.RawMessage `json:"report,omitempty"` // run report of migrator command
+}
+
+// runPipeline executes stages of pipeline config in order as one command, i.e. schema migrations, seeds,
+// drift check and notification. Stage failure skips the following stages except ones marked always.
+func runPipeline(args []string) {
+	flags := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	path := configPath()
+	config := initConfig(path)
+	opts.Setup()
+	if len(config.Pipeline) == 0 {
+		logrus.Fatal("pipeline is not configured")
+	}
+	dir, err := os.MkdirTemp("", "migrator-pipeline")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := pipelineReport{Status: statusApplied, StartedAt: time.Now().UTC()}
+	var failed []string
+	for i, stage := range config.Pipeline {
+		result := pipelineStage{Name: stage.Name, Status: statusApplied}
+		if len(failed) > 0 && !stage.Always {
+			result.Status = statusSkipped
+			report.Stages = append(report.Stages, result)
+			logrus.Warnf("stage %s is skipped", stage.Name)
+			continue
+		}
+		logrus.Infof("stage %s is started", stage.Name)
+		started := time.Now()
+		var cmd *exec.Cmd
+		reportPath := filepath.Join(dir, fmt.Sprintf("stage-%d.json", i+1))
+		if len(stage.Run) > 0 {
+			cmd = exec.Command(os.Args[0], stage.Run...)
+			cmd.Env = append(os.Environ(), "MIGRATOR_CONFIG="+path, "MIGRATOR_REPORT="+reportPath)
+		} else {
+			status := statusApplied
+			if len(failed) > 0 {
+				status = statusFailed
+			}
+			cmd = exec.Command("sh", "-c", stage.Command)
+			cmd.Env = append(os.Environ(), "MIGRATOR_PIPELINE_STATUS="+status, "MIGRATOR_FAILED_STAGES="+strings.Join(failed, ","))
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			result.Status, result.ExitCode = statusFailed, -1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				result.ExitCode = exitErr.ExitCode()
+			}
+			failed = append(failed, stage.Name)
+			logrus.WithError(err).Errorf("stage %s has failed", stage.Name)
+		}
+		result.Duration = time.Since(started).Seconds()
+		if data, err := os.ReadFile(reportPath); err == nil && json.Valid(data) {
+			result.Report = data
+		}
+		report.Stages = append(report.Stages, result)
+	}
+	report.FinishedAt = time.Now().UTC()
+	if len(failed) > 0 {
+		report.Status = statusFailed
+	}
+
+	if config.Report.Output != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(config.Report.Output, data, 0644)
+		}
+		if err != nil {
+			logrus.WithError(err).Error("can't write pipeline report")
+		}
+	}
+	fmt.Println("Pipeline stages:")
+	for _, s := range report.Stages {
+		fmt.Printf(" -  %s: %s (%.1fs)\n", s.Name, s.Status, s.Duration)
+	}
+	if len(failed) > 0 {
+		logrus.Fatalf("stages have failed: %s", strings.Join(failed, ", "))
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// plan is what up would do against the database, written by plan command to be reviewed and attached to change requests.
+type plan struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Database  string    `json:"database"`
+	Version   string    `json:"version"` // version of migrator binary
+	Tracks    []string  `json:"tracks"`
+	From      string    `json:"from,omitempty"`
+	// Migrations applied at the moment of planning, the plan is valid only for the same set
+	Applied     []plannedMigration `json:"applied"`
+	Pending     []plannedMigration `json:"pending"`
+	EstimatedMs int64              `json:"estimatedMs"`
+}
+
+type plannedMigration struct {
+	Name        string        `json:"name"`
+	Checksum    string        `json:"checksum"`
+	Kind        string        `json:"kind,omitempty"`
+	EstimatedMs int64         `json:"estimatedMs,omitempty"` // zero if there is no history of similar migrations
+	Locks       []plannedLock `json:"locks,omitempty"`
+	// Estimates of UPDATE and DELETE statements, filled with -explain flag
+	Explained []explainedStatement `json:"explained,omitempty"`
+	Body      string               `json:"body,omitempty"`
+}
+
+type plannedLock struct {
+	Line     int    `json:"line"`
+	Table    string `json:"table"`
+	Mode     string `json:"mode"`
+	Effect   string `json:"effect"`
+	Activity string `json:"activity"` // activity on the table at the moment of planning
+}
+
+// runPlan computes migrations up would apply with their locks and estimated durations and writes them into plan file.
+// Nothing is changed in the database.
+func runPlan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	output := flags.String("output", "migrator-plan.json", "write plan into the file")
+	track := flags.String("track", "", "plan migrations of the only track: schema or data (all tracks by default)")
+	from := flags.String("from", "", "read migrations only from the given source")
+	explain := flags.Bool("explain", false, "run EXPLAIN on UPDATE and DELETE statements to estimate rows they change")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	tracks := allTracks
+	if *track != "" {
+		if *track != trackSchema && *track != trackData {
+			logrus.Fatalf("unknown track %q, available values: %s, %s", *track, trackSchema, trackData)
+		}
+		tracks = []string{*track}
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	db := connectDB(config)
+	// Planning is done in a transaction which is rolled back, so nothing explained by it is saved
+	tx := db.Begin()
+	if tx.Error != nil {
+		logrus.WithError(tx.Error).Fatal("can't begin transaction")
+	}
+	defer tx.Rollback()
+	store := newVersionStore(tx)
+	applied, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	pending := readPending(tx, store, newLoader(config, *from), config, tracks)
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := checkRequirements(all, pending); err != nil {
+		logrus.Fatal(err)
+	}
+
+	p := plan{CreatedAt: time.Now().UTC(), Database: config.Target(), Version: version, Tracks: tracks, From: *from,
+		Applied: plannedApplied(applied), Pending: []plannedMigration{}}
+	e := newEstimator(all)
+	r := &runner{schema: config.Schema}
+	for _, m := range pending {
+		if m, err = m.loaded(); err != nil {
+			logrus.Fatal(err)
+		}
+		d, _ := e.Estimate(m)
+		planned := plannedMigration{Name: m.Path(), Checksum: m.Checksum(), Kind: migrationKind(m.Body, m.Track),
+			EstimatedMs: d.Milliseconds(), Body: m.Body}
+		for _, l := range migrationLocks(m) {
+			activity, err := describeActivity(tx, l.Table)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			planned.Locks = append(planned.Locks, plannedLock{Line: l.Line, Table: l.Table, Mode: l.Mode,
+				Effect: lockEffects[l.Mode], Activity: activity})
+		}
+		if *explain {
+			if planned.Explained, err = explainMigration(tx, r, m); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		p.Pending = append(p.Pending, planned)
+		p.EstimatedMs += planned.EstimatedMs
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err == nil {
+		err = os.WriteFile(*output, data, 0644)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("can't write plan")
+	}
+	printPlan(p, e, pending, opts.Quiet)
+	fmt.Printf("Plan is written to %s.\n", *output)
+}
+
+// runApply executes previously approved plan, refusing to do anything if the database state
+// or migrations differ from planned ones.
+func runApply(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	path := flags.String("plan", "", "plan file written by plan command")
+	o := upOptions{}
+	flags.BoolVar(&o.SingleTransaction, "single-transaction", false, "apply all planned migrations in one transaction")
+	flags.BoolVar(&o.Rollback, "rollback-on-failure", false, "roll back migrations applied in the run by their down files when one fails")
+	o.cliOptions = addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if *path == "" {
+		logrus.Fatal("plan file must be given by -plan flag")
+	}
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		logrus.WithError(err).Fatal("can't read plan")
+	}
+	o.Plan = &plan{}
+	if err := json.Unmarshal(data, o.Plan); err != nil {
+		logrus.WithError(err).Fatal("can't decode plan")
+	}
+	o.Tracks, o.From = o.Plan.Tracks, o.Plan.From
+
+	config := initConfig(configPath())
+	o.Setup()
+	up(config, o)
+}
+
+// Verify checks the plan is made for the database in its current state and for the same pending migrations.
+func (p *plan) Verify(config Config, applied []Migration, pending []migrationFile) error {
+	if p.Database != config.Target() {
+		return fmt.Errorf("plan is made for %s, not for %s", p.Database, config.Target())
+	}
+	if err := compareMigrations("applied", p.Applied, plannedApplied(applied)); err != nil {
+		return err
+	}
+	actual := make([]plannedMigration, len(pending))
+	for i, m := range pending {
+		m, err := m.loaded()
+		if err != nil {
+			return err
+		}
+		actual[i] = plannedMigration{Name: m.Path(), Checksum: m.Checksum()}
+	}
+	return compareMigrations("pending", p.Pending, actual)
+}
+
+// compareMigrations returns error describing the first difference of planned and actual migrations.
+func compareMigrations(kind string, planned, actual []plannedMigration) error {
+	for i := 0; i < len(planned) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			return fmt.Errorf("%s migration %s of the plan is missed", kind, planned[i].Name)
+		case i >= len(planned):
+			return fmt.Errorf("%s migration %s is not in the plan", kind, actual[i].Name)
+		case planned[i].Name != actual[i].Name:
+			return fmt.Errorf("%s migration %s is found instead of planned %s", kind, actual[i].Name, planned[i].Name)
+		case planned[i].Checksum != actual[i].Checksum:
+			return fmt.Errorf("%s migration %s has changed since planning", kind, actual[i].Name)
+		}
+	}
+	return nil
+}
+
+// plannedApplied returns applied migrations identified by paths and checksums.
+func plannedApplied(applied []Migration) []plannedMigration {
+	result := make([]plannedMigration, len(applied))
+	for i, m := range applied {
+		sum := appliedChecksum(m)
+		file := migrationFile{Name: m.Name, Track: m.Track, Module: m.Module}
+		result[i] = plannedMigration{Name: file.Path(), Checksum: sum}
+	}
+	return result
+}
+
+// printPlan prints pending migrations as additions with their locks and estimates, bodies are omitted in quiet mode.
+func printPlan(p plan, e estimator, pending []migrationFile, quiet bool) {
+	if len(pending) == 0 {
+		fmt.Println("Found no one new migration, your database is up to date.")
+		return
+	}
+	fmt.Printf("Migrations to apply to %s:\n", p.Database)
+	for i, m := range pending {
+		m, err := m.loaded()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		fmt.Printf(" +  %s %s\n", m.Path(), e.Format(m))
+		for _, l := range p.Pending[i].Locks {
+			fmt.Printf("      line %d: %s on %s (%s), %s\n", l.Line, l.Mode, l.Table, l.Effect, l.Activity)
+		}
+		for _, e := range p.Pending[i].Explained {
+			fmt.Println("      " + describeExplained(e))
+		}
+		if quiet {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(m.Body, "\n"), "\n") {
+			fmt.Println("    + " + line)
+		}
+	}
+	fmt.Printf("Plan: %d migrations to apply, estimated duration is ~%s.\n",
+		len(pending), roundDuration(time.Duration(p.EstimatedMs)*time.Millisecond))
+}
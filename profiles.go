@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// profileEnv selects named profile of config, it is set by --profile flag given before command,
+// so runs started by this one use the same profile.
+const profileEnv = "MIGRATOR_PROFILE"
+
+// profileArgs takes --profile flag given before command, i.e. "migrator --profile prod-eu up", and returns the rest.
+func profileArgs(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	name := strings.TrimLeft(args[0], "-")
+	switch {
+	case len(args) > 1 && args[0] != name && name == "profile":
+		_ = os.Setenv(profileEnv, args[1])
+		return args[2:]
+	case args[0] != name && strings.HasPrefix(name, "profile="):
+		_ = os.Setenv(profileEnv, strings.TrimPrefix(name, "profile="))
+		return args[1:]
+	}
+	return args
+}
+
+// applyProfile overrides config by settings of the named profile, i.e. its database, environment and notify hooks.
+// Settings absent in the profile are kept, lists replace configured ones and credentials of database replace each other.
+func applyProfile(config *Config, name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(config.Profiles))
+		for n := range config.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q is not configured, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	// Password and password file replace each other like in Inherit
+	if database, ok := profile["database"].(map[interface{}]interface{}); ok {
+		_, password := database["password"]
+		_, passwordFile := database["passwordFile"]
+		if password || passwordFile {
+			config.Database.Password, config.Database.PasswordFile = "", ""
+		}
+	}
+	data, err := yaml.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("invalid profile %s: %w", name, err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("invalid profile %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	progressBarWidth = 30
+	// How often state of long-running migration is reported into the log.
+	progressLogInterval = 10 * time.Second
+)
+
+// progress prints state of migrations batch while it is applying.
+// In TTY mode the line of running migration is shown with a progress bar.
+type progress struct {
+	out   *os.File
+	tty   bool
+	quiet bool
+	total int
+	done  int
+
+	mu        sync.Mutex
+	name      string
+	started   time.Time
+	statement int // number of current statement inside of running migration
+	stmtTotal int
+	stmtStart time.Time
+	broken    bool // line of running migration is interrupted by other output
+	stop      chan struct{}
+	watcher   sync.WaitGroup
+}
+
+// newProgress returns progress printing nothing in quiet mode.
+func newProgress(out *os.File, total int, quiet bool) *progress {
+	return &progress{out: out, tty: isTerminal(out), total: total, quiet: quiet}
+}
+
+// Start reports that migration with given name is being applied.
+func (p *progress) Start(name string) {
+	p.mu.Lock()
+	p.name = name
+	p.started = time.Now()
+	p.statement, p.stmtTotal = 0, 0
+	p.broken = false
+	p.stop = make(chan struct{})
+	if p.quiet {
+		p.mu.Unlock()
+		return
+	}
+	if p.tty {
+		p.drawBar()
+	} else {
+		fmt.Fprintf(p.out, "%s ... ", p.prefix())
+	}
+	p.mu.Unlock()
+
+	p.watcher.Add(1)
+	go p.watch(p.stop)
+}
+
+// Statement reports that statement n of total is being executed inside of running migration.
+func (p *progress) Statement(n, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statement, p.stmtTotal = n, total
+	p.stmtStart = time.Now()
+	if p.tty && !p.quiet {
+		p.drawBar()
+	}
+	logrus.Debugf("migration %s: executing statement %d of %d", p.name, n, total)
+}
+
+// Println prints message while migration is running, for example executed statement.
+func (p *progress) Println(message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.quiet {
+		return
+	}
+	if p.tty {
+		fmt.Fprintf(p.out, "\r\033[K%s\n", message)
+		p.drawBar()
+		return
+	}
+	// Line of running migration is left open until it is finished
+	if !p.broken {
+		fmt.Fprintln(p.out)
+		p.broken = true
+	}
+	fmt.Fprintln(p.out, message)
+}
+
+// Done reports that the last started migration was applied.
+func (p *progress) Done() {
+	p.finish(fmt.Sprintf("%.1fs", time.Since(p.started).Seconds()))
+}
+
+// Skip reports that the last started migration was recorded without execution.
+func (p *progress) Skip(reason string) {
+	p.finish("skipped: " + reason)
+}
+
+// Fail reports that the last started migration was not applied.
+// It should be called before error output to keep the terminal clean.
+func (p *progress) Fail() {
+	p.finish("failed")
+}
+
+// finish prints result of the last started migration after its watcher has exited and counts it as done.
+func (p *progress) finish(result string) {
+	close(p.stop)
+	p.watcher.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer func() { p.done++ }()
+	if p.quiet {
+		return
+	}
+	switch {
+	case p.tty:
+		fmt.Fprintf(p.out, "\r\033[K%s ... ", p.prefix())
+	case p.broken:
+		fmt.Fprintf(p.out, "%s ... ", p.prefix())
+	}
+	fmt.Fprintln(p.out, result)
+}
+
+// watch periodically reports state of running migration until stop is closed,
+// so it is possible to tell whether a long migration is advancing or stuck.
+func (p *progress) watch(stop chan struct{}) {
+	defer p.watcher.Done()
+	interval := progressLogInterval
+	if p.tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		if p.tty {
+			p.drawBar()
+		} else {
+			// Log entry starts on its own line, the line of running migration is reprinted when it is finished
+			if !p.broken {
+				fmt.Fprintln(p.out)
+				p.broken = true
+			}
+			logrus.Infof("migration %s: %s", p.name, p.state())
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *progress) drawBar() {
+	filled := progressBarWidth * p.done / p.total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(p.out, "\r\033[K[%s] %s ... %s", bar, p.prefix(), p.state())
+}
+
+// state describes running migration, i.e. "statement 3 of 12 (2m10s), elapsed 5m3s".
+func (p *progress) state() string {
+	elapsed := time.Since(p.started).Round(time.Second)
+	if p.stmtTotal <= 1 {
+		return fmt.Sprintf("elapsed %s", elapsed)
+	}
+	return fmt.Sprintf("statement %d of %d (%s), elapsed %s",
+		p.statement, p.stmtTotal, time.Since(p.stmtStart).Round(time.Second), elapsed)
+}
+
+func (p *progress) prefix() string {
+	return fmt.Sprintf("[%d/%d] %s", p.done+1, p.total, p.name)
+}
+
+// isTerminal reports whether file is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// runRedo rolls back the last applied migration or the named one and migrations applied after it, then applies
+// them again from current files. It is the workflow of iterating on a freshly written migration on dev database,
+// so changed files are applied without verification against their rows.
+func runRedo(args []string) {
+	flags := flag.NewFlagSet("redo", flag.ExitOnError)
+	from := flags.String("from", "", "read migrations only from the given source")
+	yes := flags.Bool("yes", false, "redo without confirmation")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if flags.NArg() > 1 {
+		logrus.Fatal("only one migration may be given, i.e. migrator redo 0042_add_orders")
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	report := newReport(config, "redo", time.Now())
+	db := connectDB(config)
+	store := newVersionStore(db)
+	if _, err := store.Lock(true); err != nil {
+		logrus.Fatal(err)
+	}
+	defer unlock(store)
+	applied, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	sortApplied(applied)
+	if len(applied) == 0 {
+		logrus.Fatal("there is no applied migration to redo")
+	}
+	selected := applied[len(applied)-1:]
+	if flags.NArg() == 1 {
+		name := flags.Arg(0)
+		after, err := appliedAfter(applied, name)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		selected = applied[len(applied)-len(after)-1:]
+	}
+
+	l := newLoader(config, *from)
+	steps, err := planRollback(l, store, selected)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	files := make([]migrationFile, len(selected))
+	for i, m := range selected {
+		if files[i], err = findFile(l, m); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	printRollback(config, steps)
+	if !*yes && len(steps) > 1 && !confirm(fmt.Sprintf("Roll back and apply again %d migrations?", len(steps))) {
+		logrus.Fatal("redo is not confirmed")
+	}
+
+	version, err := serverVersion(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	r := &runner{
+		db:       db,
+		store:    store,
+		progress: newProgress(os.Stdout, len(steps)*2, opts.Quiet),
+		verbose:  opts.Verbose || logrus.IsLevelEnabled(logrus.DebugLevel),
+		version:  version,
+		skipList: newSkipList(config.Skip),
+		env:      config.Environment,
+		schema:   config.Schema,
+		dial:     func() (*gorm.DB, error) { return dial(config) },
+		vcs:      newVCSInfo(config, ""),
+	}
+	rollBack(r, steps, report)
+	for _, m := range files {
+		r.progress.Start(m.Path())
+		started := time.Now()
+		reason, err := r.Run(m)
+		report.Add(m, started, reason, err)
+		if err != nil {
+			r.progress.Fail()
+			logrus.WithError(err).Fatalf("can't apply migration %s again", m.Path())
+		}
+		if reason != "" {
+			r.progress.Skip(reason)
+			continue
+		}
+		r.progress.Done()
+	}
+	report.Finish(statusRolledBack)
+	fmt.Println("Has applied migrations again:")
+	for _, m := range files {
+		fmt.Println(" - ", m.Path())
+	}
+}
+
+// findFile returns loaded file of applied migration.
+func findFile(l *loader, m Migration) (migrationFile, error) {
+	files, err := l.List(m.Module, m.Track)
+	if err != nil {
+		return migrationFile{}, err
+	}
+	for _, f := range files {
+		if f.Name == m.Name {
+			return f, l.Load(&f)
+		}
+	}
+	file := migrationFile{Name: m.Name, Module: m.Module, Track: m.Track}
+	return migrationFile{}, fmt.Errorf("file of migration %s is not found", file.Path())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+)
+
+// Verification modes of applied migrations.
+const (
+	verificationStrict = "strict"
+	// Whitespace and comment-only edits are ignored, so formatting cleanups don't brick deployments
+	verificationRelaxed = "relaxed"
+)
+
+// canonicalSQL returns SQL tokens joined by single space with comments removed.
+// Quoted strings and identifiers are kept as is, content of dollar-quoted bodies is tokenized too.
+func canonicalSQL(sql string) string {
+	var tokens []string
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			depth := 0
+			for ; i < len(sql); i++ {
+				if strings.HasPrefix(sql[i:], "/*") {
+					depth++
+					i++
+				} else if strings.HasPrefix(sql[i:], "*/") {
+					depth--
+					i++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		case c == '\'' || c == '"':
+			start := i
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			for i++; i < len(sql); i++ {
+				if escapes && sql[i] == '\\' {
+					i++
+					continue
+				}
+				if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			end := i + 1
+			if end > len(sql) {
+				end = len(sql)
+			}
+			// E'...' prefix is a part of the string token
+			if escapes && len(tokens) > 0 && strings.EqualFold(tokens[len(tokens)-1], "E") {
+				tokens = tokens[:len(tokens)-1]
+				start--
+			}
+			tokens = append(tokens, sql[start:end])
+		case c == '$':
+			tag, ok := dollarTag(sql[i:])
+			if !ok {
+				tokens = append(tokens, string(c))
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			closing := tag
+			if end < 0 {
+				// Unterminated body lasts till the end of script, it doesn't get closing tag
+				end, closing = len(sql)-i-len(tag), ""
+			}
+			body := sql[i+len(tag) : i+len(tag)+end]
+			tokens = append(tokens, tag+canonicalSQL(body)+closing)
+			i += len(tag) + end + len(closing) - 1
+		case isWordChar(c):
+			start := i
+			for i+1 < len(sql) && isWordChar(sql[i+1]) {
+				i++
+			}
+			tokens = append(tokens, sql[start:i+1])
+		default:
+			tokens = append(tokens, string(c))
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
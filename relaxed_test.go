@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestCanonicalSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"whitespace", "CREATE  TABLE\ta (\r\n  id int\n);", "CREATE TABLE a ( id int ) ;"},
+		{"line comments", "-- header\nSELECT 1; -- trailing\n", "SELECT 1 ;"},
+		{"nested comments", "SELECT /* a /* b */ c */ 1;", "SELECT 1 ;"},
+		{"strings kept", "SELECT 'a  b', 'it''s  x', \"Col  Name\";", "SELECT 'a  b' , 'it''s  x' , \"Col  Name\" ;"},
+		{"escape string", "SELECT E'a\\'  b', e'\\\\';", "SELECT E'a\\'  b' , e'\\\\' ;"},
+		{"comment markers in strings", "SELECT '-- not  a comment', '/* neither */';", "SELECT '-- not  a comment' , '/* neither */' ;"},
+		{
+			"dollar quoted body",
+			"DO $$\nBEGIN\n  -- comment\n  PERFORM  1;\nEND\n$$;",
+			"DO $$BEGIN PERFORM 1 ; END$$ ;",
+		},
+		{"tagged dollar quoted body", "SELECT $fn$ a  $$ b $$ $fn$;", "SELECT $fn$a $$b$$$fn$ ;"},
+		{"unterminated dollar quoted body", "SELECT $fn$ a  $$ b $fn$;", "SELECT $fn$a $$b$fn$ ;"},
+		{"positional parameter", "SELECT $1,  a.b;", "SELECT $ 1 , a.b ;"},
+		{"empty", "  -- nothing\n/* at all */", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalSQL(tt.sql); got != tt.want {
+				t.Errorf("canonicalSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalSQLIgnoresFormatting(t *testing.T) {
+	a := "CREATE TABLE users (\n  id bigint PRIMARY KEY,\n  name text -- display name\n);\n"
+	b := "-- users of the app\ncreate table users (id bigint PRIMARY KEY, name text);"
+	if canonicalSQL(a) == canonicalSQL(b) {
+		t.Errorf("canonicalSQL doesn't keep keyword case: %q", canonicalSQL(b))
+	}
+	c := "/* users */ CREATE TABLE users (id bigint PRIMARY KEY,\n\tname text\n);"
+	if canonicalSQL(a) != canonicalSQL(c) {
+		t.Errorf("canonicalSQL(%q) = %q differs from canonicalSQL(%q) = %q", a, canonicalSQL(a), c, canonicalSQL(c))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// findRenames returns files applied migrations were renamed to by their applied names. Applied migration
+// missed among files is matched to not applied file with the same normalized body.
+func findRenames(store VersionStore, applied []Migration, files []migrationFile, n Normalization) (map[string]migrationFile, error) {
+	names := make(map[string]bool, len(files))
+	for _, f := range files {
+		names[f.Name] = true
+	}
+	appliedNames := make(map[string]bool, len(applied))
+	for _, m := range applied {
+		appliedNames[m.Name] = true
+	}
+	candidates := make(map[string]migrationFile)
+	for _, f := range files {
+		// Bodies of streamed migrations are not known
+		if !appliedNames[f.Name] && f.stream == nil {
+			candidates[n.Apply(f.Body)] = f
+		}
+	}
+
+	renames := make(map[string]migrationFile)
+	for _, m := range applied {
+		if names[m.Name] {
+			continue
+		}
+		body, err := appliedBody(store, m)
+		if err != nil {
+			return nil, err
+		}
+		if body == "" && m.Checksum != "" {
+			// Body is not stored, renamed file is found by checksum
+			for key, f := range candidates {
+				if ok, _ := matchesApplied(m, f); ok {
+					renames[m.Name] = f
+					delete(candidates, key)
+					break
+				}
+			}
+			continue
+		}
+		if f, ok := candidates[n.Apply(body)]; ok {
+			renames[m.Name] = f
+			delete(candidates, n.Apply(body))
+		}
+	}
+	return renames, nil
+}
+
+// checkRenames fails with a hint to repair tracking table if applied migrations were renamed.
+func checkRenames(store VersionStore, applied []Migration, files []migrationFile, config Config) {
+	renames, err := findRenames(store, applied, files, config.Normalization)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	for _, m := range applied {
+		if f, ok := renames[m.Name]; ok {
+			logrus.Fatalf("migration %s was renamed to %s (%d renames in total), update tracking table by \"migrator repair -renames\"",
+				m.Name, f.Name, len(renames))
+		}
+	}
+}
+
+// runRepair fixes tracking table after changes of migration files which don't change applied migrations.
+func runRepair(args []string) {
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	renames := flags.Bool("renames", false, "update names of applied migrations whose files were renamed without changes")
+	dryRun := flags.Bool("dry-run", false, "only print what would be repaired")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if !*renames {
+		logrus.Fatal("nothing to repair, available flags: -renames")
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	var report *runReport
+	if !*dryRun {
+		report = newReport(config, "repair", time.Now())
+	}
+	db := connectDB(config)
+	store := newVersionStore(db)
+	if _, err := store.Lock(true); err != nil {
+		logrus.Fatal(err)
+	}
+	defer unlock(store)
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	l := newLoader(config, "")
+
+	count := 0
+	for _, track := range allTracks {
+		for _, module := range append([]string{moduleDefault}, config.Modules...) {
+			files, err := l.Read(module, track)
+			if err != nil {
+				logrus.WithError(err).Fatal("can't read migrations")
+			}
+			applied := appliedOf(all, module, track)
+			found, err := findRenames(store, applied, files, config.Normalization)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			for _, m := range applied {
+				f, ok := found[m.Name]
+				if !ok {
+					continue
+				}
+				count++
+				fmt.Printf(" -  %s -> %s\n", migrationFile{Name: m.Name, Track: m.Track, Module: m.Module}.Path(), f.Path())
+				if *dryRun {
+					continue
+				}
+				if err := replaceApplied(db, store, m, f); err != nil {
+					logrus.WithError(err).Fatalf("can't rename migration %s", m.Name)
+				}
+			}
+		}
+	}
+	report.Finish(statusRepaired)
+	if *dryRun {
+		fmt.Printf("Would rename %d applied migrations.\n", count)
+		return
+	}
+	fmt.Printf("Has renamed %d applied migrations.\n", count)
+}
+
+// replaceApplied replaces tracking row of migration by the row of its renamed or changed file keeping history of applying.
+func replaceApplied(db *gorm.DB, store VersionStore, m Migration, f migrationFile) error {
+	record := f.Record()
+	record.CreatedAt, record.DurationMs, record.SkipReason = m.CreatedAt, m.DurationMs, m.SkipReason
+	record.GitSHA, record.GitAuthor = m.GitSHA, m.GitAuthor
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := store.Remove(tx, m); err != nil {
+			return err
+		}
+		return store.Record(tx, record)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Statuses of run and its migrations in report.
+const (
+	statusApplied    = "applied"
+	statusSkipped    = "skipped"
+	statusFailed     = "failed"
+	statusUpToDate   = "up-to-date"
+	statusRolledBack = "rolled-back"
+	statusTested     = "tested"
+	statusBlocked    = "blocked"
+	statusPending    = "pending"
+	statusSquashed   = "squashed"
+	statusImported   = "imported"
+	statusRepaired   = "repaired"
+)
+
+// runReport is a summary of run written as JSON to be attached to deployment records.
+type runReport struct {
+	Command       string    `json:"command"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	FailurePolicy string    `json:"failurePolicy"`
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	Database      struct {
+		Host          string `json:"host"`
+		Port          int    `json:"port"`
+		Name          string `json:"name"`
+		User          string `json:"user"`
+		ServerVersion string `json:"serverVersion,omitempty"`
+	} `json:"database"`
+	Binary struct {
+		Version string `json:"version"`
+		GitSHA  string `json:"gitSHA,omitempty"`
+	} `json:"binary"`
+	Migrations []reportMigration `json:"migrations"`
+
+	path   string
+	config Config // audit record is inserted into the target database
+	tenant string // tenant schema migrations are applied to in tenants mode
+	once   sync.Once
+}
+
+type reportMigration struct {
+	Name       string    `json:"name"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Status     string    `json:"status"`
+	SkipReason string    `json:"skipReason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	Duration   float64   `json:"durationSeconds"`
+	// Fields of metadata header of migration file
+	Author      string `json:"author,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// newReport returns report of the command which is written to configured path and audit table when run is over,
+// including the case of fatal error. Nil is returned if neither report nor audit is configured.
+func newReport(config Config, command string, started time.Time) *runReport {
+	if config.Report.Output == "" && !config.Audit.Table {
+		return nil
+	}
+	r := &runReport{Command: command, Status: statusFailed, StartedAt: started, Migrations: []reportMigration{},
+		path: config.Report.Output, config: config}
+	r.FailurePolicy = newFailures(config.FailurePolicy).policy
+	r.Database.Host = config.Database.Host
+	r.Database.Port = config.Database.Port
+	r.Database.Name = config.Database.Name
+	r.Database.User = config.Database.User
+	r.Binary.Version = version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				r.Binary.GitSHA = setting.Value
+			}
+		}
+	}
+	logrus.AddHook(r)
+	logrus.RegisterExitHandler(r.Write)
+	return r
+}
+
+// SetServerVersion records version of target server.
+func (r *runReport) SetServerVersion(num int) {
+	if r != nil {
+		r.Database.ServerVersion = formatPostgresVersion(num)
+	}
+}
+
+// SetTenant records following migrations as applied to the tenant schema.
+func (r *runReport) SetTenant(tenant string) {
+	if r != nil {
+		r.tenant = tenant
+	}
+}
+
+// Add records result of migration run. Empty status means the migration has failed with err.
+func (r *runReport) Add(m migrationFile, started time.Time, skipReason string, err error) {
+	if r == nil {
+		return
+	}
+	md := parseMetadata(m.Body)
+	entry := reportMigration{Name: m.Path(), Tenant: r.tenant, Status: statusApplied, StartedAt: started, Duration: time.Since(started).Seconds(),
+		Author: md.Author, Ticket: md.Ticket, Description: md.Description}
+	switch {
+	case err != nil:
+		entry.Status, entry.Error = statusFailed, err.Error()
+	case skipReason != "":
+		entry.Status, entry.SkipReason = statusSkipped, skipReason
+	}
+	r.Migrations = append(r.Migrations, entry)
+}
+
+// Block records migration not applied because of earlier failures.
+func (r *runReport) Block(m migrationFile, reason string) {
+	if r != nil {
+		r.Migrations = append(r.Migrations, reportMigration{Name: m.Path(), Tenant: r.tenant, Status: statusBlocked,
+			SkipReason: reason, StartedAt: time.Now()})
+	}
+}
+
+// RollBack marks migration applied earlier in the run as rolled back.
+func (r *runReport) RollBack(m migrationFile) {
+	if r == nil {
+		return
+	}
+	for i := range r.Migrations {
+		if r.Migrations[i].Name == m.Path() && r.Migrations[i].Tenant == r.tenant {
+			r.Migrations[i].Status = statusRolledBack
+		}
+	}
+}
+
+// Finish sets final status of successful run and writes the report.
+func (r *runReport) Finish(status string) {
+	if r == nil {
+		return
+	}
+	r.Status = status
+	r.Write()
+}
+
+// Write writes report to configured path and audit table once.
+func (r *runReport) Write() {
+	r.once.Do(func() {
+		r.FinishedAt = time.Now()
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err == nil && r.path != "" {
+			err = os.WriteFile(r.path, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "can't write run report:", err)
+		}
+		if r.config.Audit.Table {
+			if err := recordRun(r.config, r, data); err != nil {
+				fmt.Fprintln(os.Stderr, "can't record run into audit table:", err)
+			}
+		}
+	})
+}
+
+// Levels implements logrus.Hook, fatal errors are recorded as the reason of failed run.
+func (r *runReport) Levels() []logrus.Level {
+	return []logrus.Level{logrus.FatalLevel}
+}
+
+// Fire implements logrus.Hook.
+func (r *runReport) Fire(entry *logrus.Entry) error {
+	r.Error = entry.Message
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		r.Error += ": " + err.Error()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const requiresDirective = "requires"
+
+// checkRequirements verifies that every migration declaring "-- migrator:requires <name>..."
+// is going to be applied after its prerequisites. Prerequisite may be already applied
+// in any track or be pending earlier in the same run. Names may be given without .sql extension
+// and may be qualified by module and track, i.e. "billing/schema/0005_create_accounts".
+func checkRequirements(applied []Migration, pending []migrationFile) error {
+	available := make(map[string]bool, 2*(len(applied)+len(pending)))
+	provide := func(m migrationFile) {
+		available[trimMigrationExt(m.Name)] = true
+		available[trimMigrationExt(m.Path())] = true
+	}
+	for _, m := range applied {
+		provide(migrationFile{Name: m.Name, Track: m.Track, Module: m.Module})
+	}
+
+	for _, m := range pending {
+		m, err := m.loaded()
+		if err != nil {
+			return err
+		}
+		for _, d := range parseDirectives(m.Body) {
+			if d.Name != requiresDirective {
+				continue
+			}
+			for _, required := range strings.Fields(d.Args) {
+				if !available[trimMigrationExt(required)] {
+					return fmt.Errorf("migration %s requires %s (line %d), which is neither applied nor going to be applied before it",
+						m.Path(), required, d.Line)
+				}
+			}
+		}
+		provide(m)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	// Classes of transient failures
+	retrySerialization = "serialization"
+	retryLockTimeout   = "lock timeout"
+	retryConnection    = "connection"
+)
+
+// RetryPolicy configures retries of migrations failed by transient errors of a class.
+type RetryPolicy struct {
+	// Retries after the first attempt, 0 disables retries
+	Attempts int `yaml:"attempts" binding:"gte=0"`
+	// Delay before the first retry doubled for each next one, 1s by default
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// Delay returns delay before the retry of the attempt starting from 1.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = defaultRetryBackoff
+	}
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// Retry configures retries of transactional migrations by classes of transient failures,
+// migrations with concurrent-index or batched directives are never retried as a whole.
+type Retry struct {
+	// Serialization failures (40001) and deadlocks (40P01)
+	Serialization RetryPolicy `yaml:"serialization"`
+	// Locks not acquired within lock_timeout (55P03)
+	LockTimeout RetryPolicy `yaml:"lockTimeout"`
+	// Connection resets and failures (08xxx), lock of the run is acquired again after reconnect
+	Connection RetryPolicy `yaml:"connection"`
+}
+
+// classify returns class of transient failure with its policy, false if the error is not transient.
+func (c Retry) classify(err error) (string, RetryPolicy, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "40001", pgErr.Code == "40P01":
+			return retrySerialization, c.Serialization, true
+		case pgErr.Code == "55P03":
+			return retryLockTimeout, c.LockTimeout, true
+		case strings.HasPrefix(pgErr.Code, "08"):
+			return retryConnection, c.Connection, true
+		}
+		return "", RetryPolicy{}, false
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr) || pgconn.SafeToRetry(err) {
+		return retryConnection, c.Connection, true
+	}
+	return "", RetryPolicy{}, false
+}
+
+// retrying calls apply until it succeeds, fails by not transient error or attempts of the class are exhausted.
+// Apply must roll back everything on failure. Connection failures are not retried in a single transaction,
+// as the transaction is lost together with connection.
+func (r *runner) retrying(m migrationFile, apply func() error) error {
+	attempts := make(map[string]int)
+	for {
+		err := apply()
+		if err == nil {
+			return nil
+		}
+		class, policy, ok := r.retry.classify(err)
+		if !ok || attempts[class] >= policy.Attempts {
+			return err
+		}
+		if _, inTx := r.db.Statement.ConnPool.(gorm.TxCommitter); inTx && class == retryConnection {
+			return err
+		}
+		attempts[class]++
+		delay := policy.Delay(attempts[class])
+		logrus.WithError(err).Warnf("migration %s has failed by %s, retrying in %s (%d of %d)",
+			m.Path(), class, delay, attempts[class], policy.Attempts)
+		time.Sleep(delay)
+		if class == retryConnection && r.reconnect != nil {
+			if err := r.reconnect(); err != nil {
+				return err
+			}
+			// Another run may have taken the lock while the connection was lost
+			applied, err := r.store.List()
+			if err != nil {
+				return err
+			}
+			for _, a := range applied {
+				if a.Module == m.Module && a.Track == m.Track && a.Name == m.Name {
+					return fmt.Errorf("migration %s is recorded by another run while connection was lost", m.Path())
+				}
+			}
+		}
+	}
+}
+
+// reacquire restores what is lost together with connection: role of config and advisory lock of the run.
+// Session settings made by hooks are not restored.
+func reacquire(db *gorm.DB, store VersionStore, config Config) error {
+	if config.Database.Role != "" {
+		if err := db.Exec("SELECT set_config('role', ?, false)", config.Database.Role).Error; err != nil {
+			return fmt.Errorf("can't set role %s: %w", config.Database.Role, err)
+		}
+	}
+	if lockConfig.Mode == lockRow {
+		// Lease is kept by its own connection
+		return nil
+	}
+	_, err := store.Lock(true)
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ReadDown returns body of the down file reverting migration, i.e. 0001_init.down.sql for 0001_init.sql.
+// False is returned when there is no down file in any source.
+func (l *loader) ReadDown(m migrationFile) (string, bool, error) {
+	down := migrationFile{Name: trimMigrationExt(m.Name) + downExt, Module: m.Module, Track: m.Track}
+	name := path.Join(".", down.Path())
+	for _, src := range l.sources {
+		file, err := src.Read(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("can't read down file %s from %s source: %w", down.Path(), src.Name, err)
+		}
+		if err := l.verifier.Verify(src, name, file); err != nil {
+			return "", false, err
+		}
+		body, err := l.inline(src, string(file), 0)
+		if err != nil {
+			return "", false, fmt.Errorf("can't read down file %s: %w", down.Path(), err)
+		}
+		if body, err = l.render(down, body); err != nil {
+			return "", false, fmt.Errorf("can't render down file %s: %w", down.Path(), err)
+		}
+		return body, true, nil
+	}
+	return "", false, nil
+}
+
+// Rollback executes down file of migration in a transaction together with removal of its record.
+// Skipped migration has nothing to revert, only its row is removed.
+func (r *runner) Rollback(m migrationFile, down string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Down file works with schema of the migration
+		restore, err := r.setSession(tx, m)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range splitStatements(down) {
+			if err := r.exec(tx, stmt.SQL).Error; err != nil {
+				return fmt.Errorf("can't execute statement of down file at line %d: %w", stmt.Line, err)
+			}
+		}
+		if err := restore(); err != nil {
+			return err
+		}
+		if err := r.store.Remove(tx, Migration{Module: m.Module, Track: m.Track, Name: m.Name}); err != nil {
+			return fmt.Errorf("can't remove migration stat: %w", err)
+		}
+		return nil
+	})
+}
+
+// rollbackRun reverts migrations applied earlier in the failed run in reverse order and prints what was undone.
+// It stops at the first migration which can't be reverted, leaving it and all before it applied.
+func rollbackRun(r *runner, l *loader, done []migrationFile, skipped map[string]string, report *runReport) error {
+	var undone []string
+	defer func() {
+		fmt.Println("Has rolled back migrations:")
+		for _, name := range undone {
+			fmt.Println(" - ", name)
+		}
+	}()
+	for i := len(done) - 1; i >= 0; i-- {
+		m, err := done[i].loaded()
+		if err != nil {
+			return err
+		}
+		var down string
+		if _, ok := skipped[m.Path()]; !ok {
+			var found bool
+			var err error
+			if down, found, err = l.ReadDown(m); err != nil {
+				return err
+			}
+			if !found {
+				return fmt.Errorf("migration %s has no down file, it and %d migrations before it are left applied", m.Path(), i)
+			}
+		}
+		if err := r.Rollback(m, down); err != nil {
+			return fmt.Errorf("can't roll back migration %s: %w", m.Path(), err)
+		}
+		logrus.Infof("migration %s is rolled back", m.Path())
+		report.RollBack(m)
+		events.OnMigrationRolledBack(m.Path())
+		undone = append(undone, m.Path())
+	}
+	return nil
+}
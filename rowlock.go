@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Coordination modes of concurrent runs. Session advisory locks don't work behind poolers in transaction mode
+// like pgbouncer, row lock works with any pooler: a lease is taken on a dedicated row by SELECT ... FOR UPDATE
+// and kept by heartbeats, lease of a crashed run is taken over once it is stale.
+const (
+	lockAdvisory = "advisory"
+	lockRow      = "row"
+
+	defaultLockHeartbeat  = 5 * time.Second
+	defaultLockStaleAfter = 30 * time.Second
+)
+
+// LockConfig configures coordination of concurrent runs.
+type LockConfig struct {
+	Mode string `yaml:"mode" binding:"omitempty,oneof=advisory row"`
+	// How often lease of row lock is renewed and free lock is checked by waiting runs
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// Lease not renewed for this long is considered left by a crashed run and is taken over
+	StaleAfter time.Duration `yaml:"staleAfter"`
+}
+
+// lockConfig is coordination of the run, it is set by initConfig.
+var lockConfig LockConfig
+
+// rowLock is lease on the single row of migrator_lock table. It is taken and renewed by a dedicated connection,
+// so heartbeats are not blocked by long migrations executed on the main one.
+type rowLock struct {
+	db        *gorm.DB
+	holder    string
+	heartbeat time.Duration
+	stale     time.Duration
+
+	held bool
+	stop chan struct{}
+	once sync.Once
+}
+
+// newRowLock opens dedicated connection to the database of db and prepares lock table.
+func newRowLock(db *gorm.DB, c LockConfig) (*rowLock, error) {
+	conn, err := gorm.Open(db.Dialector, &gorm.Config{Logger: db.Logger})
+	if err != nil {
+		return nil, fmt.Errorf("can't open lock connection: %w", err)
+	}
+	host, _ := os.Hostname()
+	l := &rowLock{
+		db:        conn,
+		holder:    fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+		heartbeat: c.Heartbeat,
+		stale:     c.StaleAfter,
+		stop:      make(chan struct{}),
+	}
+	if l.heartbeat <= 0 {
+		l.heartbeat = defaultLockHeartbeat
+	}
+	if l.stale <= 0 {
+		l.stale = defaultLockStaleAfter
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS migrator_lock (id int PRIMARY KEY, holder text NOT NULL DEFAULT '', heartbeat_at timestamptz)",
+		"INSERT INTO migrator_lock (id) VALUES (1) ON CONFLICT DO NOTHING",
+	} {
+		if err := conn.Exec(stmt).Error; err != nil {
+			return nil, fmt.Errorf("can't prepare lock table: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// Acquire takes the lease, without wait it reports whether the lease is free instead of waiting for it.
+func (l *rowLock) Acquire(wait bool) (bool, error) {
+	if l.held {
+		return true, nil
+	}
+	logged := false
+	for {
+		holder, err := l.try()
+		if err != nil {
+			return false, err
+		}
+		if holder == "" {
+			l.held = true
+			go l.renew()
+			logrus.RegisterExitHandler(l.Release)
+			return true, nil
+		}
+		if !wait {
+			return false, nil
+		}
+		if !logged {
+			logrus.Infof("waiting for row lock held by %s", holder)
+			logged = true
+		}
+		time.Sleep(l.heartbeat)
+	}
+}
+
+// try takes the lease if it is free or stale and returns its live holder otherwise.
+func (l *rowLock) try() (string, error) {
+	var holder string
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		var row struct {
+			Holder string
+			Stale  bool
+		}
+		err := tx.Raw("SELECT holder, coalesce(heartbeat_at < now() - make_interval(secs => ?), true) AS stale "+
+			"FROM migrator_lock WHERE id = 1 FOR UPDATE", l.stale.Seconds()).Scan(&row).Error
+		if err != nil {
+			return err
+		}
+		if row.Holder != "" && row.Holder != l.holder && !row.Stale {
+			holder = row.Holder
+			return nil
+		}
+		if row.Holder != "" && row.Holder != l.holder {
+			logrus.Warnf("row lock of %s is stale for %s, taking it over", row.Holder, l.stale)
+		}
+		return tx.Exec("UPDATE migrator_lock SET holder = ?, heartbeat_at = now() WHERE id = 1", l.holder).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't acquire row lock: %w", err)
+	}
+	return holder, nil
+}
+
+// renew extends the lease until it is released. The run is aborted if the lease is lost, i.e. taken over
+// after heartbeats failed for too long, as another run may be applying migrations already.
+func (l *rowLock) renew() {
+	ticker := time.NewTicker(l.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		res := l.db.Exec("UPDATE migrator_lock SET heartbeat_at = now() WHERE id = 1 AND holder = ?", l.holder)
+		switch {
+		case res.Error != nil:
+			logrus.WithError(res.Error).Warn("can't renew row lock")
+		case res.RowsAffected == 0:
+			logrus.Fatal("row lock is taken over by another run")
+		}
+	}
+}
+
+// Release frees the lease, so waiting runs don't have to wait for it to become stale.
+func (l *rowLock) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		if err := l.db.Exec("UPDATE migrator_lock SET holder = '' WHERE id = 1 AND holder = ?", l.holder).Error; err != nil {
+			logrus.WithError(err).Warn("can't release row lock")
+		}
+	})
+}
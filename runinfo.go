@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxApplicationName is the length postgres truncates application_name to.
+const maxApplicationName = 63
+
+// runID identifies the run of migrator in application_name and comments of executed statements.
+var runID = newRunID()
+
+func newRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// applicationName returns application_name of session applying migration, i.e. "migrator 0042_add_orders.sql #1a2b3c4d".
+// Name of migration is shortened to fit the limit of postgres.
+func applicationName(m migrationFile) string {
+	suffix := " #" + runID
+	name := m.Path()
+	if limit := maxApplicationName - len("migrator ") - len(suffix); len(name) > limit {
+		name = name[len(name)-limit:]
+	}
+	return "migrator " + name + suffix
+}
+
+// statementComment returns comment prefixed to executed statements of migration, so they are attributed to it
+// in pg_stat_activity and server logs.
+func statementComment(m migrationFile) string {
+	sanitize := strings.NewReplacer("*/", "* /", "/*", "/ *").Replace
+	return fmt.Sprintf("/* migrator migration=%s run=%s actor=%s */ ", sanitize(m.Path()), runID, sanitize(actor()))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"migrator/migratortest"
+)
+
+// runSelftest applies all migrations from zero to a disposable postgres started in docker,
+// so every change proves the full chain still applies cleanly.
+func runSelftest(args []string) {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	image := flags.String("image", migratortest.DefaultImage, "docker image of postgres")
+	golden := flags.String("golden", "", "compare resulting schema with golden file")
+	update := flags.Bool("update", false, "rewrite golden file by resulting schema")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	config := initConfig(configPath())
+	opts.Setup()
+	pg, err := migratortest.StartPostgres(context.Background(), *image)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	// Container is terminated on fatal errors as well
+	logrus.RegisterExitHandler(pg.Stop)
+	defer pg.Stop()
+
+	config.Database.Host, config.Database.Port = pg.Host, pg.Port
+	config.Database.User, config.Database.Password, config.Database.Name = pg.User, pg.Password, pg.Database
+	// Backup, hooks and report belong to the real database
+	config.Backup, config.Hooks, config.Report.Output, config.Audit = Backup{}, Hooks{}, "", Audit{}
+	up(config, upOptions{cliOptions: opts, Tracks: allTracks})
+	if *golden != "" {
+		if err := compareGolden(connectDB(config), *golden, *update); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	fmt.Println("Self-test has passed, all migrations apply cleanly from zero.")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Directives changing session settings for statements of migration and its down file.
+// "-- migrator:schema billing,public" sets search_path, it overrides default schema given by config or -schema flag.
+// "-- migrator:role app_owner" sets role, so objects created by migration are owned by it.
+const (
+	schemaDirective = "schema"
+	roleDirective   = "role"
+)
+
+// setSession applies session settings of migration and returns function restoring the previous ones.
+// Tracking table is accessed with the previous settings, so they must be restored before recording migration.
+// If transaction is rolled back, the changes are reverted by postgres itself.
+func (r *runner) setSession(tx *gorm.DB, m migrationFile) (func() error, error) {
+	type setting struct{ name, value string }
+	// application_name attributes the session to migration and run in pg_stat_activity
+	settings := []setting{{name: "search_path", value: r.schema}, {name: "role"}, {name: "application_name", value: applicationName(m)}}
+	for _, d := range parseDirectives(m.Body) {
+		switch d.Name {
+		case schemaDirective:
+			schemas := strings.FieldsFunc(d.Args, func(r rune) bool { return r == ',' || r == ' ' })
+			if len(schemas) == 0 {
+				return nil, fmt.Errorf("invalid %s directive at line %d, expected: %s <name>[,<name>...]", d.Name, d.Line, d.Name)
+			}
+			settings[0].value = strings.Join(schemas, ", ")
+		case roleDirective:
+			if len(strings.Fields(d.Args)) != 1 {
+				return nil, fmt.Errorf("invalid %s directive at line %d, expected: %s <name>", d.Name, d.Line, d.Name)
+			}
+			settings[1].value = strings.TrimSpace(d.Args)
+		}
+	}
+
+	var previous []setting
+	restore := func() error {
+		for i := len(previous) - 1; i >= 0; i-- {
+			if err := tx.Exec("SELECT set_config(?, ?, false)", previous[i].name, previous[i].value).Error; err != nil {
+				return fmt.Errorf("can't restore %s: %w", previous[i].name, err)
+			}
+		}
+		return nil
+	}
+	for _, s := range settings {
+		if s.value == "" {
+			continue
+		}
+		var value string
+		if err := tx.Raw("SELECT current_setting(?)", s.name).Scan(&value).Error; err != nil {
+			return nil, fmt.Errorf("can't get %s: %w", s.name, err)
+		}
+		if err := tx.Exec("SELECT set_config(?, ?, false)", s.name, s.value).Error; err != nil {
+			_ = restore()
+			return nil, fmt.Errorf("can't set %s to %s: %w", s.name, s.value, err)
+		}
+		previous = append(previous, setting{name: s.name, value: value})
+	}
+	r.comment = statementComment(m)
+	return func() error {
+		r.comment = ""
+		return restore()
+	}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// shadowRun applies pending migrations to a temporary database cloned from the target one, so failures
+// specific to its schema are caught before the target is touched. Schema and tracking table are cloned,
+// data of other tables is not. Shadow database is dropped afterwards in any case.
+func shadowRun(db *gorm.DB, config Config, pgVersion int, pending []migrationFile) (err error) {
+	schema, err := pgDump(config, "--schema-only", "--no-owner", "--no-privileges")
+	if err != nil {
+		return fmt.Errorf("can't dump schema: %w", err)
+	}
+	tracking, err := pgDump(config, "--data-only", "--inserts", "--table", "migrations", "--table", "migrations_id_seq")
+	if err != nil {
+		return fmt.Errorf("can't dump tracking table: %w", err)
+	}
+
+	shadow, drop, err := cloneDatabase(db, config, "shadow")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if dropErr := drop(); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+	for _, dump := range [][]byte{schema, tracking} {
+		if err := restoreDump(shadow, dump); err != nil {
+			return err
+		}
+	}
+
+	r := &runner{
+		db:       shadow,
+		store:    &tableStore{db: shadow}, // tracking table is cloned into shadow database
+		progress: newProgress(os.Stdout, len(pending), true),
+		version:  pgVersion,
+		skipList: newSkipList(config.Skip),
+		env:      config.Environment,
+		schema:   config.Schema,
+	}
+	for _, m := range pending {
+		if m, err = m.loaded(); err != nil {
+			return err
+		}
+		if _, err := r.Run(m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Path(), err)
+		}
+	}
+	return nil
+}
+
+// cloneDatabase creates empty temporary database of the kind, i.e. "shadow", on the server of db.
+// Returned drop function closes connection to the clone and drops it.
+func cloneDatabase(db *gorm.DB, config Config, kind string) (*gorm.DB, func() error, error) {
+	cloneConfig := config
+	cloneConfig.Database.Name = fmt.Sprintf("%s_%s_%d", config.Database.Name, kind, time.Now().Unix())
+	if err := db.Exec(fmt.Sprintf("CREATE DATABASE %q", cloneConfig.Database.Name)).Error; err != nil {
+		return nil, nil, fmt.Errorf("can't create %s database: %w", kind, err)
+	}
+	logrus.Infof("%s database %s is created", kind, cloneConfig.Database.Name)
+	clone := openDB(cloneConfig)
+	drop := func() error {
+		if sqlDB, err := clone.DB(); err == nil {
+			sqlDB.Close()
+		}
+		if err := db.Exec(fmt.Sprintf("DROP DATABASE %q", cloneConfig.Database.Name)).Error; err != nil {
+			return fmt.Errorf("can't drop %s database: %w", kind, err)
+		}
+		return nil
+	}
+	return clone, drop, nil
+}
+
+// restoreDump executes statements of pg_dump output in db.
+func restoreDump(db *gorm.DB, dump []byte) error {
+	for _, stmt := range splitStatements(cleanDump(string(dump))) {
+		if err := db.Exec(stmt.SQL).Error; err != nil {
+			return fmt.Errorf("can't clone schema at line %d of dump: %w", stmt.Line, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// Suffixes of detached signature files placed next to signed file.
+const (
+	gpgBinarySuffix  = ".sig"
+	gpgArmoredSuffix = ".asc"
+	sigstoreSuffix   = ".sigstore.json"
+	bundleSignedFile = checksumsFile
+)
+
+// isSignatureFile reports whether file is a detached signature of another one.
+func isSignatureFile(name string) bool {
+	for _, suffix := range []string{gpgBinarySuffix, gpgArmoredSuffix, sigstoreSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureVerifier checks detached signatures of migration files.
+// File is signed either by its own signature (0001_init.sql.asc) or by signature of SHA256SUMS file
+// placed into root of its source, which lists checksum of the file.
+type signatureVerifier struct {
+	required bool
+	keyring  openpgp.EntityList
+	identity string                       // sigstore certificate identity
+	issuer   string                       // sigstore certificate OIDC issuer
+	bundles  map[string]map[string]string // verified checksums of source bundles
+}
+
+// newSignatureVerifier returns nil when signatures verification is not configured.
+func newSignatureVerifier(config Config) (*signatureVerifier, error) {
+	c := config.Signatures
+	if c.Keyring == "" && c.Sigstore.Identity == "" {
+		if c.Required {
+			return nil, fmt.Errorf("signatures are required, but neither keyring nor sigstore identity is configured")
+		}
+		return nil, nil
+	}
+	v := &signatureVerifier{
+		required: c.Required,
+		identity: c.Sigstore.Identity,
+		issuer:   c.Sigstore.Issuer,
+		bundles:  make(map[string]map[string]string),
+	}
+	if c.Keyring != "" {
+		data, err := os.ReadFile(c.Keyring)
+		if err != nil {
+			return nil, fmt.Errorf("can't read keyring: %w", err)
+		}
+		if v.keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data)); err != nil {
+			if v.keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("can't parse keyring: %w", err)
+			}
+		}
+	}
+	return v, nil
+}
+
+// Verify checks signature of file read from the source.
+// Unsigned file is rejected only when signatures are required, invalid signature is always rejected.
+func (v *signatureVerifier) Verify(src source, name string, data []byte) error {
+	if v == nil {
+		return nil
+	}
+	signed, err := v.verifyFile(src, name, data)
+	if err != nil {
+		return err
+	}
+	if !signed {
+		if signed, err = v.verifyBundle(src, name, data); err != nil {
+			return err
+		}
+	}
+	if !signed && v.required {
+		return fmt.Errorf("file %s of %s source is not signed", name, src.Name)
+	}
+	return nil
+}
+
+// verifyFile checks own detached signature of the file if it is present.
+func (v *signatureVerifier) verifyFile(src source, name string, data []byte) (bool, error) {
+	for _, suffix := range []string{gpgArmoredSuffix, gpgBinarySuffix, sigstoreSuffix} {
+		signature, err := src.Read(name + suffix)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := v.check(suffix, data, signature); err != nil {
+			return false, fmt.Errorf("invalid signature of file %s of %s source: %w", name, src.Name, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// verifyBundle checks the file against signed checksums file of the source.
+func (v *signatureVerifier) verifyBundle(src source, name string, data []byte) (bool, error) {
+	sums, ok := v.bundles[src.Name]
+	if !ok {
+		content, err := src.Read(bundleSignedFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			v.bundles[src.Name] = nil
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		signed, err := v.verifyFile(src, bundleSignedFile, content)
+		if err != nil {
+			return false, err
+		}
+		if signed {
+			sums = parseSHA256Sums(content)
+		}
+		v.bundles[src.Name] = sums
+	}
+	if sums == nil {
+		return false, nil
+	}
+	sum := sha256.Sum256(data)
+	if expected, ok := sums[name]; !ok || expected != hex.EncodeToString(sum[:]) {
+		return false, fmt.Errorf("file %s of %s source doesn't match signed %s", name, src.Name, bundleSignedFile)
+	}
+	return true, nil
+}
+
+func (v *signatureVerifier) check(suffix string, data, signature []byte) error {
+	switch suffix {
+	case gpgArmoredSuffix, gpgBinarySuffix:
+		if v.keyring == nil {
+			return fmt.Errorf("keyring is not configured")
+		}
+		var err error
+		if suffix == gpgArmoredSuffix {
+			_, err = openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(signature))
+		} else {
+			_, err = openpgp.CheckDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(signature))
+		}
+		return err
+	default:
+		return v.checkSigstore(data, signature)
+	}
+}
+
+// checkSigstore verifies sigstore bundle by cosign command, as files may be read from memory they are
+// written into temporary dir first.
+func (v *signatureVerifier) checkSigstore(data, bundle []byte) error {
+	if v.identity == "" {
+		return fmt.Errorf("sigstore identity is not configured")
+	}
+	dir, err := os.MkdirTemp("", "migrator-sigstore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	blob, bundlePath := filepath.Join(dir, "blob"), filepath.Join(dir, "bundle.json")
+	if err := os.WriteFile(blob, data, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(bundlePath, bundle, 0600); err != nil {
+		return err
+	}
+	cmd := exec.Command("cosign", "verify-blob", "--bundle", bundlePath,
+		"--certificate-identity", v.identity, "--certificate-oidc-issuer", v.issuer, blob)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// parseSHA256Sums parses output of sha256sum utility into file path to checksum map.
+func parseSHA256Sums(sums []byte) map[string]string {
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// Binary mode mark is allowed before file name
+		result[path.Clean(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+	return result
+}
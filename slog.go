@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"migrator/extend"
+)
+
+var installLogHandlerOnce sync.Once
+
+// installLogHandler routes log entries into handler given to extend.SetLogHandler if it is set.
+func installLogHandler() {
+	handler := extend.LogHandler()
+	if handler == nil {
+		return
+	}
+	installLogHandlerOnce.Do(func() {
+		logrus.AddHook(slogHook{handler})
+		logrus.SetOutput(io.Discard)
+	})
+}
+
+// slogHook passes log entries to slog handler.
+type slogHook struct {
+	handler slog.Handler
+}
+
+func (h slogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h slogHook) Fire(entry *logrus.Entry) error {
+	level := slogLevel(entry.Level)
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !h.handler.Enabled(ctx, level) {
+		return nil
+	}
+	record := slog.NewRecord(entry.Time, level, entry.Message, 0)
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		record.AddAttrs(slog.Any(key, entry.Data[key]))
+	}
+	return h.handler.Handle(ctx, record)
+}
+
+func slogLevel(level logrus.Level) slog.Level {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return slog.LevelError
+	case logrus.WarnLevel:
+		return slog.LevelWarn
+	case logrus.InfoLevel:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SlowThresholds are durations of migration, exceeding of which is logged and notified while it is still running.
+// Zero threshold is disabled.
+type SlowThresholds struct {
+	Warn  time.Duration `yaml:"warn"`
+	Error time.Duration `yaml:"error"`
+}
+
+// slowDirective overrides thresholds for migration expected to be slow, i.e. "-- migrator:slow warn=5m error=1h".
+const slowDirective = "slow"
+
+// slowThresholdsOf returns thresholds of migration, overridden by its directive.
+func slowThresholdsOf(t SlowThresholds, m migrationFile) (SlowThresholds, error) {
+	d, ok := findDirective(parseDirectives(m.Body), slowDirective)
+	if !ok {
+		return t, nil
+	}
+	for key, value := range d.Params() {
+		var err error
+		switch key {
+		case "warn":
+			t.Warn, err = time.ParseDuration(value)
+		case "error":
+			t.Error, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid %q parameter of %s directive at line %d: %w", key, slowDirective, d.Line, err)
+		}
+	}
+	return t, nil
+}
+
+// watchSlow starts reporting migration exceeding thresholds by log and notify hooks, returned function stops it.
+func watchSlow(t SlowThresholds, m migrationFile, hooks []Hook) func() {
+	var timers []*time.Timer
+	watch := func(threshold time.Duration, level logrus.Level) {
+		if threshold <= 0 {
+			return
+		}
+		timers = append(timers, time.AfterFunc(threshold, func() {
+			message := fmt.Sprintf("migration %s is running longer than %s", m.Path(), threshold)
+			logrus.StandardLogger().Log(level, message)
+			if err := notify(hooks, "slow", message); err != nil {
+				logrus.WithError(err).Error("can't notify about slow migration")
+			}
+		}))
+	}
+	watch(t.Warn, logrus.WarnLevel)
+	watch(t.Error, logrus.ErrorLevel)
+	return func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Objects of the schema excluding system ones.
+const snapshotFilter = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%'
+	AND n.nspname NOT LIKE 'pg_temp%'`
+
+// Tracking and audit tables of the migrator with their sequences, like ones excluded from baseline by squash.
+const snapshotOwnTables = `NOT (cl.relname IN ('migrations', 'migrations_id_seq', 'migration_runs', 'migration_runs_id_seq')
+	AND n.nspname = current_schema())`
+
+var snapshotQueries = []struct {
+	Object string // format of object header, filled by the first columns
+	Params int    // number of columns in the header
+	SQL    string // rows of other columns are lines of the object
+}{
+	{"%s %s.%s", 3, `SELECT CASE cl.relkind WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized view' ELSE 'table' END,
+		n.nspname, cl.relname,
+		concat_ws(' ', a.attname, format_type(a.atttypid, a.atttypmod), CASE WHEN a.attnotnull THEN 'not null' END,
+			'default ' || pg_get_expr(d.adbin, d.adrelid))
+		FROM pg_attribute a
+		JOIN pg_class cl ON cl.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE cl.relkind IN ('r', 'p', 'v', 'm') AND a.attnum > 0 AND NOT a.attisdropped AND ` + snapshotFilter + `
+		AND ` + snapshotOwnTables + `
+		ORDER BY n.nspname, cl.relname, a.attnum`},
+	{"table %s.%s", 2, `SELECT n.nspname, cl.relname, 'constraint ' || con.conname || ' ' || pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class cl ON cl.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE ` + snapshotFilter + ` AND ` + snapshotOwnTables + `
+		ORDER BY 1, 2, con.conname`},
+	{"table %s.%s", 2, `SELECT n.nspname, cl.relname, 'index ' || pg_get_indexdef(i.indexrelid)
+		FROM pg_index i
+		JOIN pg_class cl ON cl.oid = i.indrelid
+		JOIN pg_class ic ON ic.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE ` + snapshotFilter + ` AND ` + snapshotOwnTables + `
+		ORDER BY 1, 2, ic.relname`},
+	{"%s %s.%s", 3, `SELECT CASE cl.relkind WHEN 'v' THEN 'view' ELSE 'materialized view' END, n.nspname, cl.relname,
+		pg_get_viewdef(cl.oid)
+		FROM pg_class cl
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE cl.relkind IN ('v', 'm') AND ` + snapshotFilter + `
+		ORDER BY 2, 3`},
+	{"function %s.%s(%s)", 3, `SELECT n.nspname, p.proname, pg_get_function_identity_arguments(p.oid), pg_get_functiondef(p.oid)
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.prokind IN ('f', 'p') AND ` + snapshotFilter + `
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY 1, 2, 3`},
+	{"type %s.%s", 2, `SELECT n.nspname, t.typname, 'enum ' || string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder)
+		FROM pg_enum e
+		JOIN pg_type t ON t.oid = e.enumtypid
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		GROUP BY 1, 2
+		ORDER BY 1, 2`},
+	{"extension %s", 1, `SELECT extname, '' FROM pg_extension ORDER BY 1`},
+}
+
+// schemaSnapshot serializes schema of the database into canonical text, which doesn't depend on
+// order objects were created in, so it may be compared with golden file.
+func schemaSnapshot(db *gorm.DB) (string, error) {
+	objects := make(map[string][]string)
+	for _, q := range snapshotQueries {
+		rows, err := db.Raw(q.SQL).Rows()
+		if err != nil {
+			return "", fmt.Errorf("can't read schema: %w", err)
+		}
+		for rows.Next() {
+			values := make([]interface{}, q.Params+1)
+			columns := make([]string, q.Params+1)
+			for i := range values {
+				values[i] = &columns[i]
+			}
+			if err := rows.Scan(values...); err != nil {
+				rows.Close()
+				return "", err
+			}
+			header := make([]interface{}, q.Params)
+			for i := range header {
+				header[i] = columns[i]
+			}
+			object := fmt.Sprintf(q.Object, header...)
+			if line := columns[q.Params]; line != "" {
+				objects[object] = append(objects[object], line)
+			} else if _, ok := objects[object]; !ok {
+				objects[object] = nil
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+	}
+
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "\n")
+		for _, line := range objects[name] {
+			b.WriteString("  " + strings.ReplaceAll(strings.TrimSpace(line), "\n", "\n  ") + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// compareGolden compares schema snapshot of the database with golden file, printing diff on mismatch.
+// With update the golden file is rewritten instead.
+func compareGolden(db *gorm.DB, golden string, update bool) error {
+	snapshot, err := schemaSnapshot(db)
+	if err != nil {
+		return err
+	}
+	if update {
+		return os.WriteFile(golden, []byte(snapshot), 0644)
+	}
+	expected, err := os.ReadFile(golden)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("golden file %s is not found, create it by -update flag", golden)
+	}
+	if err != nil {
+		return err
+	}
+	if string(expected) == snapshot {
+		return nil
+	}
+	fmt.Fprint(os.Stderr, unifiedDiff(golden, "database", string(expected), snapshot))
+	return fmt.Errorf("schema doesn't match golden file %s, review the diff and update the file by -update flag", golden)
+}
+
+// runSnapshot compares schema of configured database with golden file.
+func runSnapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	golden := flags.String("golden", "schema.golden", "golden file with expected schema")
+	update := flags.Bool("update", false, "rewrite golden file by the current schema")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	config := initConfig(configPath())
+	opts.Setup()
+	db := connectDB(config)
+	if err := compareGolden(db, *golden, *update); err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Println("Schema matches golden file.")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"migrator/extend"
+)
+
+// checksumsFile may be placed into root of a remote source to verify its files,
+// the format is the same as of sha256sum utility output.
+const checksumsFile = "SHA256SUMS"
+
+// Source provides migration files, see extend.Source.
+type Source = extend.Source
+
+// source is a place migrations are read from.
+type source struct {
+	Name string
+	Source
+	overlay bool // files are logged as they supplement embedded ones
+}
+
+// fsSource provides files of embedded, local or downloaded into memory file system.
+type fsSource struct {
+	fsys fs.FS
+}
+
+func (s fsSource) List(dir string) ([]string, error) {
+	entries, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s fsSource) Read(name string) ([]byte, error) {
+	return fs.ReadFile(s.fsys, name)
+}
+
+// initSources returns embedded migrations followed by sources declared in config and overlay dir.
+// Files of all sources are merged into one ordered stream.
+func initSources(config Config) ([]source, error) {
+	embedded, err := fs.Sub(Embed, migrationsDirName)
+	if err != nil {
+		return nil, err
+	}
+	sources := []source{{Name: "embedded", Source: fsSource{embedded}}}
+	for _, location := range config.Sources {
+		src, err := openSource(location)
+		if err != nil {
+			return nil, fmt.Errorf("can't open migrations source %s: %w", redactURL(location), err)
+		}
+		sources = append(sources, src)
+	}
+	if config.Overlay != "" {
+		if _, err := os.Stat(config.Overlay); errors.Is(err, fs.ErrNotExist) {
+			logrus.Debugf("overlay dir %s is not found", config.Overlay)
+			return sources, nil
+		}
+		src, err := openSource(config.Overlay)
+		if err != nil {
+			return nil, fmt.Errorf("can't open overlay %s: %w", config.Overlay, err)
+		}
+		src.Name, src.overlay = "overlay "+config.Overlay, true
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// openSource opens local directory or remote source given by URL.
+func openSource(location string) (source, error) {
+	scheme, _, isURL := strings.Cut(location, "://")
+	if !isURL {
+		info, err := os.Stat(location)
+		if err != nil {
+			return source{}, err
+		}
+		if !info.IsDir() {
+			if strings.HasSuffix(location, ".tgz") || strings.HasSuffix(location, ".tar.gz") {
+				return openBundle(location)
+			}
+			return source{}, fmt.Errorf("neither a directory nor a bundle")
+		}
+		return source{Name: location, Source: fsSource{os.DirFS(location)}}, nil
+	}
+
+	var (
+		files memFS
+		err   error
+	)
+	if factory := extend.SourceFactory(scheme); factory != nil {
+		src, err := factory(location)
+		if err != nil {
+			return source{}, err
+		}
+		return source{Name: redactURL(location), Source: src}, nil
+	}
+	switch scheme {
+	case "s3", "gs":
+		s3, err := newS3Source(location)
+		if err != nil {
+			return source{}, err
+		}
+		files, err = s3.Download()
+	case "http", "https":
+		files, err = downloadTarball(location)
+	case "git+https", "git+ssh", "git+file":
+		files, err = cloneGit(location)
+	case "oci":
+		var registry *ociRegistry
+		if registry, err = newOCIRegistry(location); err != nil {
+			return source{}, err
+		}
+		var layer []byte
+		if layer, err = registry.Pull(); err != nil {
+			return source{}, err
+		}
+		if files, err = readTarGz(bytes.NewReader(layer)); err == nil {
+			err = verifySHA256Sums(files)
+		}
+	default:
+		return source{}, fmt.Errorf("unsupported source type %q", scheme)
+	}
+	if err != nil {
+		return source{}, err
+	}
+	return source{Name: redactURL(location), Source: fsSource{files}}, nil
+}
+
+// openBundle opens local tarball written by "bundle create" verifying checksums of its files.
+func openBundle(location string) (source, error) {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return source{}, err
+	}
+	files, err := readTarGz(bytes.NewReader(data))
+	if err != nil {
+		return source{}, err
+	}
+	if _, ok := files[checksumsFile]; !ok {
+		return source{}, fmt.Errorf("bundle has no %s file", checksumsFile)
+	}
+	if err := verifySHA256Sums(files); err != nil {
+		return source{}, err
+	}
+	if data, ok := files[bundleManifestFile]; ok {
+		var manifest bundleManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return source{}, fmt.Errorf("invalid manifest of bundle: %w", err)
+		}
+		logrus.Infof("migrations are read from bundle %s of version %s created at %s", location, manifest.Version,
+			manifest.CreatedAt.Format(time.RFC3339))
+	}
+	return source{Name: location, Source: fsSource{files}}, nil
+}
+
+// redactURL hides password given in source URL, so it is not exposed in logs.
+func redactURL(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	return u.Redacted()
+}
+
+// verifySHA256Sums checks files against checksums file if it is present.
+// Every file except signatures must be listed in checksums file then, i.e. migrations, fragments and data.
+func verifySHA256Sums(files memFS) error {
+	sums, ok := files[checksumsFile]
+	if !ok {
+		return nil
+	}
+	expected := parseSHA256Sums(sums)
+	for name, data := range files {
+		if name == checksumsFile {
+			continue
+		}
+		sum, listed := expected[name]
+		if !listed {
+			// Migrations are read along with fragments, down files and data of any names, so only signatures
+			// verifying files by themselves may be unlisted
+			if isSignatureFile(name) {
+				continue
+			}
+			return fmt.Errorf("file %s is not listed in %s", name, checksumsFile)
+		}
+		actual := sha256.Sum256(data)
+		if hex.EncodeToString(actual[:]) != sum {
+			return fmt.Errorf("checksum of file %s doesn't match %s", name, checksumsFile)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// downloadTarball downloads gzipped tarball by URL like https://example.com/migrations.tar.gz#db/migrations,
+// where optional fragment is a directory inside of archive migrations are placed to.
+// Credentials for basic authorization may be given in URL.
+func downloadTarball(rawURL string) (memFS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	dir := u.Fragment
+	u.Fragment = ""
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	files, err := readTarGz(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if files, err = files.Sub(dir); err != nil {
+		return nil, err
+	}
+	return files, verifySHA256Sums(files)
+}
+
+// readTarGz reads all regular files of gzipped tarball.
+func readTarGz(r io.Reader) (memFS, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't decompress archive: %w", err)
+	}
+	defer gz.Close()
+	files := make(memFS)
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid file name %q in archive", header.Name)
+		}
+		if files[name], err = io.ReadAll(archive); err != nil {
+			return nil, fmt.Errorf("can't read %s from archive: %w", name, err)
+		}
+	}
+}
+
+// cloneGit reads files of git repository by URL like git+https://github.com/org/app.git?ref=v1.4.0#db/migrations,
+// where ref is a branch, tag or commit SHA to check out and fragment is a directory migrations are placed to.
+// Only the commit is fetched by git command, so its authentication settings are used. The resolved commit is logged.
+func cloneGit(rawURL string) (memFS, error) {
+	u, err := url.Parse(strings.TrimPrefix(rawURL, "git+"))
+	if err != nil {
+		return nil, err
+	}
+	dir, ref := u.Fragment, u.Query().Get("ref")
+	u.Fragment, u.RawQuery = "", ""
+
+	tmp, err := os.MkdirTemp("", "migrator-git-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// Commits are fetched by SHA as well as by name, unlike clone --branch
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", u.String()},
+		{"fetch", "--quiet", "--depth", "1", "origin", ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir, cmd.Stderr = tmp, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("can't fetch %s of repository: %w", ref, err)
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir, cmd.Stderr = tmp, os.Stderr
+	sha, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve %s of repository: %w", ref, err)
+	}
+	logrus.Infof("migrations are read from %s at commit %s", redactURL(rawURL), strings.TrimSpace(string(sha)))
+
+	files := make(memFS)
+	root := filepath.Join(tmp, filepath.FromSlash(dir))
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = os.ReadFile(p)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't read migrations from repository: %w", err)
+	}
+	return files, verifySHA256Sums(files)
+}
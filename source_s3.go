@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Source reads migrations stored in S3 compatible object storage under the bucket prefix.
+// Credentials are taken from standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
+// environment variables, region from AWS_REGION and custom endpoint from AWS_ENDPOINT_URL.
+// Without credentials requests are sent anonymously.
+// Google Cloud Storage is supported through its S3 compatible API with HMAC keys.
+type s3Source struct {
+	endpoint  *url.URL
+	pathStyle bool
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+// newS3Source parses source URL like s3://bucket/prefix or gs://bucket/prefix.
+func newS3Source(rawURL string) (*s3Source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	s := &s3Source{
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: time.Minute},
+	}
+	if s.bucket == "" {
+		return nil, fmt.Errorf("bucket is not specified in %s", rawURL)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	switch {
+	case u.Scheme == "gs":
+		endpoint, s.region = "https://storage.googleapis.com", "auto"
+	case s.region == "":
+		s.region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.region)
+	} else {
+		s.pathStyle = true
+	}
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	return s, nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string
+		ETag string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// Download lists all objects under the prefix and reads them into memory.
+// Content of each object is verified against its ETag when it is a plain MD5 sum (not multipart upload
+// nor object encrypted by SSE-KMS or SSE-C key) and against SHA256SUMS file if it is present under the prefix.
+func (s *s3Source) Download() (memFS, error) {
+	files := make(memFS)
+	prefix := s.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := s.get("", query)
+		if err != nil {
+			return nil, fmt.Errorf("can't list objects: %w", err)
+		}
+		var list s3ListResult
+		if err := xml.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("can't decode objects list: %w", err)
+		}
+		for _, obj := range list.Contents {
+			if strings.HasSuffix(obj.Key, "/") {
+				continue
+			}
+			data, header, err := s.do(http.MethodGet, obj.Key, nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("can't download %s: %w", obj.Key, err)
+			}
+			etag := strings.Trim(obj.ETag, `"`)
+			if sum := md5.Sum(data); md5ETag(etag, header) && etag != hex.EncodeToString(sum[:]) {
+				return nil, fmt.Errorf("checksum of %s doesn't match its ETag", obj.Key)
+			}
+			files[strings.TrimPrefix(obj.Key, prefix)] = data
+		}
+		if !list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	if err := verifySHA256Sums(files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// md5ETag reports whether ETag of object downloaded with response header is MD5 sum of its content.
+// It is not for multipart uploads and objects encrypted by SSE-KMS or customer provided key.
+func md5ETag(etag string, header http.Header) bool {
+	return etag != "" && !strings.Contains(etag, "-") &&
+		!strings.HasPrefix(header.Get("X-Amz-Server-Side-Encryption"), "aws:kms") &&
+		header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") == ""
+}
+
+// get sends signed GET request for the object key or bucket itself when key is empty.
+func (s *s3Source) get(key string, query url.Values) ([]byte, error) {
+	body, _, err := s.do(http.MethodGet, key, query, nil)
+	return body, err
+}
+
+// put uploads object with the key under the prefix.
+func (s *s3Source) put(key string, data []byte) error {
+	_, _, err := s.do(http.MethodPut, path.Join(s.prefix, key), nil, data)
+	return err
+}
+
+// s3PartSize is size of parts of multipart upload, only one part is held in memory. As there are up to
+// 10000 parts, objects up to 640 GB may be uploaded.
+const s3PartSize = 64 << 20
+
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+// upload writes content read from r into object with the key under the prefix. Content larger than
+// a single part is uploaded by multipart upload, which is aborted if reading or uploading of any part fails.
+func (s *s3Source) upload(key string, r io.Reader) error {
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.put(key, part[:n])
+	}
+	if err != nil {
+		return err
+	}
+
+	key = path.Join(s.prefix, key)
+	body, _, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return fmt.Errorf("can't start multipart upload: %w", err)
+	}
+	var started struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body, &started); err != nil {
+		return fmt.Errorf("can't decode multipart upload: %w", err)
+	}
+	abort := func(err error) error {
+		_, _, _ = s.do(http.MethodDelete, key, url.Values{"uploadId": {started.UploadID}}, nil)
+		return err
+	}
+	var complete s3CompleteUpload
+	for number := 1; n > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {started.UploadID}}
+		_, header, err := s.do(http.MethodPut, key, query, part[:n])
+		if err != nil {
+			return abort(fmt.Errorf("can't upload part %d: %w", number, err))
+		}
+		complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: number, ETag: header.Get("ETag")})
+		if n, err = io.ReadFull(r, part); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+	}
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		return abort(err)
+	}
+	if body, _, err = s.do(http.MethodPost, key, url.Values{"uploadId": {started.UploadID}}, data); err != nil {
+		return abort(fmt.Errorf("can't complete multipart upload: %w", err))
+	}
+	// Completion may fail after response status is sent, then error is in the body
+	var result struct {
+		XMLName xml.Name
+		Message string
+	}
+	if err := xml.Unmarshal(body, &result); err == nil && result.XMLName.Local == "Error" {
+		return abort(fmt.Errorf("can't complete multipart upload: %s", result.Message))
+	}
+	return nil
+}
+
+// do sends signed request and returns body and header of successful response.
+func (s *s3Source) do(method, key string, query url.Values, data []byte) ([]byte, http.Header, error) {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket
+	}
+	if key != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	// Spaces must be encoded as %20 both in request and in its signature
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.accessKey != "" {
+		s.sign(req, time.Now().UTC(), data)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, body)
+	}
+	return body, resp.Header, nil
+}
+
+// sign adds AWS Signature Version 4 headers to the request with the given body.
+func (s *s3Source) sign(req *http.Request, now time.Time, body []byte) {
+	bodyHash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(bodyHash[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := path.Join(date, s.region, "s3", "aws4_request")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+)
+
+// statement is a single SQL statement of migration file.
+type statement struct {
+	SQL  string
+	Line int // line number where statement begins in the file
+}
+
+// splitStatements splits SQL script into separate statements by semicolons.
+// Semicolons inside of quoted strings, identifiers, dollar-quoted bodies and comments are ignored.
+// Statements consisting only of comments and whitespaces are skipped.
+func splitStatements(sql string) []statement {
+	var (
+		result  []statement
+		start   int  // offset of current statement
+		line    = 1  // current line number
+		begin   = 1  // line number of the first meaningful symbol of current statement
+		hasCode bool // current statement contains something besides comments
+	)
+	flush := func(end int) {
+		if hasCode {
+			result = append(result, statement{SQL: strings.TrimSpace(sql[start:end]), Line: begin})
+		}
+		start = end + 1
+		hasCode = false
+	}
+	markCode := func() {
+		if !hasCode {
+			hasCode = true
+			begin = line
+		}
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == ';':
+			flush(i)
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			line++
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			// Block comments in postgres may be nested
+			depth := 0
+			for ; i < len(sql); i++ {
+				switch {
+				case strings.HasPrefix(sql[i:], "/*"):
+					depth++
+					i++
+				case strings.HasPrefix(sql[i:], "*/"):
+					depth--
+					i++
+				case sql[i] == '\n':
+					line++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		case c == '\'' || c == '"':
+			markCode()
+			// Backslash escapes are allowed only in E'...' strings
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			for i++; i < len(sql); i++ {
+				if sql[i] == '\n' {
+					line++
+				}
+				if escapes && sql[i] == '\\' {
+					i++
+					continue
+				}
+				if sql[i] == c {
+					// Doubled quote is an escaped quote
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '$':
+			markCode()
+			tag, ok := dollarTag(sql[i:])
+			if !ok {
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				end = len(sql) - i - len(tag)
+			} else {
+				end += len(tag)
+			}
+			line += strings.Count(sql[i:i+len(tag)+end], "\n")
+			i += len(tag) + end - 1
+		case c == ' ' || c == '\t' || c == '\r':
+		default:
+			markCode()
+		}
+	}
+	flush(len(sql))
+	return result
+}
+
+// dollarTag returns opening tag of dollar-quoted string like $$ or $body$ from beginning of s.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1], true
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+		case c >= '0' && c <= '9' && i > 1:
+		default:
+			// Positional parameter like $1 or just a symbol
+			return "", false
+		}
+	}
+	return "", false
+}
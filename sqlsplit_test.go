@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var splitTests = []struct {
+	name string
+	sql  string
+	want []statement
+}{
+	{
+		name: "semicolons",
+		sql:  "CREATE TABLE a (id int);\nCREATE TABLE b (id int);\n",
+		want: []statement{{"CREATE TABLE a (id int)", 1}, {"CREATE TABLE b (id int)", 2}},
+	},
+	{
+		name: "no trailing semicolon",
+		sql:  "SELECT 1;\n\nSELECT 2",
+		want: []statement{{"SELECT 1", 1}, {"SELECT 2", 3}},
+	},
+	{
+		name: "quoted strings and identifiers",
+		sql:  "INSERT INTO \"a;b\" VALUES ('x;y', 'it''s;');\nSELECT 1;",
+		want: []statement{{"INSERT INTO \"a;b\" VALUES ('x;y', 'it''s;')", 1}, {"SELECT 1", 2}},
+	},
+	{
+		name: "escape string",
+		sql:  "SELECT E'a\\';b';\nSELECT e'\\\\';\nSELECT 'c\\';\nSELECT 2;",
+		want: []statement{{"SELECT E'a\\';b'", 1}, {"SELECT e'\\\\'", 2}, {"SELECT 'c\\'", 3}, {"SELECT 2", 4}},
+	},
+	{
+		name: "dollar quoting",
+		sql: "CREATE FUNCTION f() RETURNS void AS $$\nBEGIN\n  PERFORM 1;\nEND\n$$ LANGUAGE plpgsql;\n" +
+			"DO $body$ BEGIN RAISE NOTICE '$$;'; END $body$;\nSELECT $1;",
+		want: []statement{
+			{"CREATE FUNCTION f() RETURNS void AS $$\nBEGIN\n  PERFORM 1;\nEND\n$$ LANGUAGE plpgsql", 1},
+			{"DO $body$ BEGIN RAISE NOTICE '$$;'; END $body$", 6},
+			{"SELECT $1", 7},
+		},
+	},
+	{
+		name: "comments",
+		sql:  "-- first; comment\nSELECT 1; -- trailing; comment\n/* block; */ SELECT 2;\n-- only comment;\n",
+		want: []statement{{"-- first; comment\nSELECT 1", 2}, {"-- trailing; comment\n/* block; */ SELECT 2", 3}},
+	},
+	{
+		name: "nested comments",
+		sql:  "/* outer /* inner; */ still; comment */\nSELECT 1;\n/* /* */ */ SELECT 2;",
+		want: []statement{{"/* outer /* inner; */ still; comment */\nSELECT 1", 2}, {"/* /* */ */ SELECT 2", 3}},
+	},
+	{
+		name: "empty statements",
+		sql:  ";;\n  ;\n",
+		want: nil,
+	},
+}
+
+func TestSplitStatements(t *testing.T) {
+	for _, tt := range splitTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.sql); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
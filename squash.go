@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// squashesDirective marks baseline file, which replaces all migrations up to the given one,
+// i.e. "-- migrator:squashes 0600_add_orders.sql".
+const squashesDirective = "squashes"
+
+// runSquash collapses migrations up to the chosen one into a single baseline file derived from
+// the schema of configured database, which must have exactly these migrations applied.
+func runSquash(args []string) {
+	flags := flag.NewFlagSet("squash", flag.ExitOnError)
+	to := flags.String("to", "", "the last migration to squash, i.e. 0600_add_orders.sql")
+	dir := flags.String("dir", migrationsDirName, "migrations dir to rewrite")
+	module := flags.String("module", moduleDefault, "module of migrations")
+	track := flags.String("track", trackDefault, "track of migrations")
+	name := flags.String("name", "", "name of baseline file (version of the last squashed migration with _baseline.sql by default)")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if *to == "" {
+		logrus.Fatal("the last migration to squash must be given by -to flag")
+	}
+	if *name == "" {
+		version := versionPrefix.FindString(*to)
+		if version == "" {
+			logrus.Fatalf("migration %s has no version prefix, baseline name must be given by -name flag", *to)
+		}
+		*name = version + "baseline.sql"
+	}
+
+	config := initConfig(configPath())
+	opts.Setup()
+	report := newReport(config, "squash", time.Now())
+	db := connectDB(config)
+
+	l := &loader{sources: []source{{Name: *dir, Source: fsSource{os.DirFS(*dir)}}}, variables: config.Variables}
+	files, err := l.Read(*module, *track)
+	if err != nil {
+		logrus.WithError(err).Fatal("can't read migrations")
+	}
+	store := newVersionStore(db)
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	applied := appliedOf(all, *module, *track)
+	verifyApplied(db, store, applied, files, config)
+	if len(applied) == 0 || applied[len(applied)-1].Name != *to {
+		logrus.Fatalf("database must have %s applied as the last migration to derive baseline from its schema", *to)
+	}
+	if len(files) > len(applied) && files[len(applied)].Name <= *name {
+		logrus.Fatalf("baseline %s must be ordered before the next migration %s", *name, files[len(applied)].Name)
+	}
+
+	schema, err := pgDump(config, "--schema-only", "--no-owner", "--no-privileges",
+		"--exclude-table", "migrations", "--exclude-table", "migrations_id_seq",
+		"--exclude-table", "migration_runs", "--exclude-table", "migration_runs_id_seq")
+	if err != nil {
+		logrus.WithError(err).Fatal("can't dump schema")
+	}
+	body := fmt.Sprintf("-- migrator:%s %s\n-- Baseline of %d migrations derived from schema\n%s",
+		squashesDirective, *to, len(applied), cleanDump(string(schema)))
+
+	trackDir := filepath.Join(*dir, *module, *track)
+	if err := os.WriteFile(filepath.Join(trackDir, *name), []byte(body), 0o644); err != nil {
+		logrus.WithError(err).Fatal("can't write baseline")
+	}
+	for _, m := range applied {
+		for _, file := range []string{m.Name, trimMigrationExt(m.Name) + downExt} {
+			if err := os.Remove(filepath.Join(trackDir, file)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logrus.WithError(err).Fatalf("can't remove squashed migration %s", file)
+			}
+		}
+	}
+
+	baseline := migrationFile{Name: *name, Body: body, Module: *module, Track: *track}
+	if err := rewriteSquashed(db, store, []migrationFile{baseline}, applied); err != nil {
+		logrus.WithError(err).Fatal("can't rewrite tracking table")
+	}
+	report.Finish(statusSquashed)
+	fmt.Printf("Has squashed %d migrations into %s\n", len(applied), baseline.Path())
+}
+
+// cleanDump removes from pg_dump output session settings and psql meta-commands, which must not
+// leak into connection shared with following migrations.
+func cleanDump(dump string) string {
+	lines := strings.Split(dump, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.HasPrefix(line, "SET ") || strings.HasPrefix(line, "SELECT pg_catalog.set_config(") ||
+			strings.HasPrefix(line, `\`) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n")) + "\n"
+}
+
+// squashedApplied returns applied migrations with ones squashed into baseline replaced by a single row
+// of the baseline, so databases which have them applied are not considered changed. Tracking table
+// is not changed, the number of replaced rows is returned.
+func squashedApplied(files []migrationFile, applied []Migration) ([]Migration, int, error) {
+	if len(files) == 0 || len(applied) == 0 || applied[0].Name == files[0].Name {
+		return applied, 0, nil
+	}
+	baseline := files[0]
+	d, ok := findDirective(parseDirectives(baseline.Body), squashesDirective)
+	if !ok {
+		return applied, 0, nil
+	}
+	last := strings.TrimSpace(d.Args)
+	n := 0
+	for n < len(applied) && applied[n].Name <= last {
+		n++
+	}
+	if n == 0 || applied[n-1].Name != last {
+		return nil, 0, fmt.Errorf("database has applied only part of migrations squashed into %s, "+
+			"apply the rest by version of migrations before squash", baseline.Path())
+	}
+	record := baseline.Record()
+	record.CreatedAt = applied[n-1].CreatedAt
+	return append([]Migration{*record}, applied[n:]...), n, nil
+}
+
+// rewriteSquashed replaces tracking rows of migrations squashed into baseline, the first one of files,
+// by the row of the baseline.
+func rewriteSquashed(db *gorm.DB, store VersionStore, files []migrationFile, applied []Migration) error {
+	view, n, err := squashedApplied(files, applied)
+	if err != nil || n == 0 {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range applied[:n] {
+			if err := store.Remove(tx, m); err != nil {
+				return err
+			}
+		}
+		return store.Record(tx, &view[0])
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("%d applied migrations are replaced by baseline %s in tracking table", n, files[0].Path())
+	return nil
+}
+
+// rewriteSquashedApplied rewrites tracking rows of squashed migrations of the tracks. It is done by commands
+// applying migrations under the lock, others only see the same rows in memory.
+func rewriteSquashedApplied(db *gorm.DB, store VersionStore, l *loader, config Config, tracks []string) error {
+	all, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		for _, module := range append([]string{moduleDefault}, config.Modules...) {
+			files, err := l.List(module, track)
+			if err == nil && len(files) > 0 {
+				err = l.Load(&files[0])
+			}
+			if err != nil {
+				return err
+			}
+			if err := rewriteSquashed(db, store, files, appliedOf(all, module, track)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runStatus prints pending migrations with durations estimated by history of applied ones,
+// so maintenance windows may be planned.
+func runStatus(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	track := flags.String("track", "", "show migrations of the only track: schema or data (all tracks by default)")
+	from := flags.String("from", "", "read migrations only from the given source")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+	tracks := allTracks
+	if *track != "" {
+		if *track != trackSchema && *track != trackData {
+			logrus.Fatalf("unknown track %q, available values: %s, %s", *track, trackSchema, trackData)
+		}
+		tracks = []string{*track}
+	}
+
+	path := configPath()
+	config := initConfig(path)
+	opts.Setup()
+	if multiTarget(config) {
+		runTargets(path, config, "status", targetArgs(flags))
+		return
+	}
+	db := connectDB(config)
+	store := newVersionStore(db)
+	pending := readPending(db, store, newLoader(config, *from), config, tracks)
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Applied %d migrations.\n", len(all))
+	if len(pending) == 0 {
+		fmt.Println("Found no one new migration, your database is up to date.")
+		return
+	}
+
+	e := newEstimator(all)
+	var total time.Duration
+	unknown := 0
+	fmt.Printf("Pending %d migrations:\n", len(pending))
+	for _, m := range pending {
+		m, err := m.loaded()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		fmt.Printf(" -  %s %s\n", m.Path(), e.Format(m))
+		if md := parseMetadata(m.Body).String(); md != "" {
+			fmt.Printf("      %s\n", md)
+		}
+		d, n := e.Estimate(m)
+		if n == 0 {
+			unknown++
+		}
+		total += d
+	}
+	fmt.Printf("Estimated duration is ~%s", roundDuration(total))
+	if unknown > 0 {
+		fmt.Printf(" plus %d migrations without history", unknown)
+	}
+	fmt.Println(".")
+}
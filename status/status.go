@@ -0,0 +1,150 @@
+// Package status inspects migrations of a database from another application, i.e. an admin service showing
+// them on its ops page, without running migrator binary. Applied migrations are read from the tracking table,
+// pending ones are files of the same migrations directory not recorded there yet.
+package status
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tracks of migrations in order they are applied, the default one has no subdirectory.
+var tracks = []string{"", "schema", "data"}
+
+// MigrationInfo describes applied or pending migration.
+type MigrationInfo struct {
+	Module string
+	Track  string
+	Name   string // file name, i.e. 0042_add_orders.sql
+	// Fields below are set for applied migrations only
+	AppliedAt   time.Time
+	Duration    time.Duration
+	Checksum    string
+	SkipReason  string // migration is recorded without execution if set
+	Tenant      string
+	Author      string
+	Ticket      string
+	Description string
+	// Version of migrator which has recorded the migration
+	MigratorVersion string
+}
+
+// Path returns path of migration file relative to migrations directory.
+func (m MigrationInfo) Path() string {
+	return path.Join(m.Module, m.Track, m.Name)
+}
+
+// Inspector reads status of migrations, it is safe for concurrent use.
+type Inspector struct {
+	db         *sql.DB
+	migrations fs.FS
+	modules    []string
+	tenant     string
+}
+
+// New returns inspector of the database with migrations directory of the binary, i.e. embedded by the
+// application too or opened by os.DirFS. Modules are ones of migrator config.
+func New(db *sql.DB, migrations fs.FS, modules ...string) *Inspector {
+	return &Inspector{db: db, migrations: migrations, modules: modules}
+}
+
+// ForTenant returns inspector of migrations applied to schema of the tenant in tenants mode.
+func (i *Inspector) ForTenant(tenant string) *Inspector {
+	c := *i
+	c.tenant = tenant
+	return &c
+}
+
+// Applied returns applied and skipped migrations ordered by module, track and name.
+// Nothing is applied to the database without tracking table.
+func (i *Inspector) Applied(ctx context.Context) ([]MigrationInfo, error) {
+	var exists bool
+	if err := i.db.QueryRowContext(ctx, "SELECT to_regclass('migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("can't check tracking table: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	rows, err := i.db.QueryContext(ctx, `SELECT module, track, name, created_at, duration_ms, checksum, skip_reason,
+		tenant, author, ticket, description, migrator_version FROM migrations WHERE tenant = $1 ORDER BY module, track, name`, i.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("can't get applied migrations: %w", err)
+	}
+	defer rows.Close()
+	var applied []MigrationInfo
+	for rows.Next() {
+		var m MigrationInfo
+		var ms int64
+		if err := rows.Scan(&m.Module, &m.Track, &m.Name, &m.AppliedAt, &ms, &m.Checksum, &m.SkipReason,
+			&m.Tenant, &m.Author, &m.Ticket, &m.Description, &m.MigratorVersion); err != nil {
+			return nil, fmt.Errorf("can't get applied migrations: %w", err)
+		}
+		m.Duration = time.Duration(ms) * time.Millisecond
+		applied = append(applied, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't get applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// Pending returns migrations in order migrator applies them. Like migrator, it counts applied migrations
+// of each module track and takes the following files, checksums of applied ones are not verified.
+func (i *Inspector) Pending(ctx context.Context) ([]MigrationInfo, error) {
+	applied, err := i.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count := make(map[string]int)
+	for _, m := range applied {
+		count[path.Join(m.Module, m.Track)]++
+	}
+	var pending []MigrationInfo
+	for _, track := range tracks {
+		// Modules are applied in order they are declared in config
+		for _, module := range append([]string{""}, i.modules...) {
+			dir := path.Join(module, track)
+			names, err := migrationFiles(i.migrations, dir)
+			if err != nil {
+				return nil, err
+			}
+			if n := count[dir]; n < len(names) {
+				for _, name := range names[n:] {
+					pending = append(pending, MigrationInfo{Module: module, Track: track, Name: name})
+				}
+			}
+		}
+	}
+	return pending, nil
+}
+
+// migrationFiles returns sorted names of migrations in the directory, missing directory has none.
+// Down files and signatures are not migrations.
+func migrationFiles(fsys fs.FS, dir string) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := fs.ReadDir(fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read migrations dir %s: %w", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		name := strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".gz"), ".tmpl")
+		if !e.IsDir() && strings.HasSuffix(name, ".sql") && !strings.HasSuffix(name, ".down.sql") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+
+	"migrator/extend"
+)
+
+// VersionStore keeps history of applied migrations, see extend.VersionStore.
+type VersionStore = extend.VersionStore
+
+// newVersionStore returns store of the run for the target database, the one given to extend.SetVersionStore
+// or the tracking table.
+func newVersionStore(db *gorm.DB) VersionStore {
+	if factory := extend.VersionStoreFactory(); factory != nil {
+		return factory(db)
+	}
+	return &tableStore{db: db}
+}
+
+// tableStore keeps history in migrations table of the target database.
+type tableStore struct {
+	db     *gorm.DB
+	rows   *rowLock // lease of row lock mode
+	tenant string   // schema of tenant rows belong to, empty outside of tenants mode
+}
+
+// ForTenant returns store of rows of the tenant in the same table.
+func (s *tableStore) ForTenant(tenant string) VersionStore {
+	return &tableStore{db: s.db, rows: s.rows, tenant: tenant}
+}
+
+// List returns migrations without bodies, they are fetched by Body when needed. Only rows recorded by older
+// versions without checksum have bodies.
+func (s *tableStore) List() ([]Migration, error) {
+	var applied []Migration
+	if err := s.db.Omit("body").Where("tenant = ?", s.tenant).Order("module, track, name").Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("can't get applied migrations: %w", err)
+	}
+	var legacy []Migration
+	if err := s.db.Select("id, body, body_encoding").Where("checksum = '' AND tenant = ?", s.tenant).Find(&legacy).Error; err != nil {
+		return nil, fmt.Errorf("can't get applied migrations: %w", err)
+	}
+	bodies := make(map[int]string, len(legacy))
+	for _, m := range legacy {
+		body, err := decodeBody(m.Body, m.BodyEncoding)
+		if err != nil {
+			return nil, err
+		}
+		bodies[m.ID] = body
+	}
+	for i := range applied {
+		if body, ok := bodies[applied[i].ID]; ok {
+			applied[i].Body = body
+		}
+	}
+	return applied, nil
+}
+
+func (s *tableStore) Body(m Migration) (string, error) {
+	var row Migration
+	if err := s.db.Select("body, body_encoding").Where("id = ?", m.ID).Take(&row).Error; err != nil {
+		return "", fmt.Errorf("can't get body of applied migration %s: %w", m.Name, err)
+	}
+	body, err := decodeBody(row.Body, row.BodyEncoding)
+	if err != nil {
+		return "", fmt.Errorf("can't get body of applied migration %s: %w", m.Name, err)
+	}
+	return body, nil
+}
+
+// Record saves migration with body compressed or omitted by configured body storage.
+func (s *tableStore) Record(tx *gorm.DB, m *Migration) error {
+	m.Tenant, m.MigratorVersion = s.tenant, version
+	row := *m
+	if bodyStorage.Omit {
+		row.Body = ""
+	}
+	var err error
+	if row.Body, err = encodeBody(row.Body, bodyStorage.Compression); err != nil {
+		return fmt.Errorf("can't compress body: %w", err)
+	}
+	if row.Body != "" {
+		row.BodyEncoding = bodyStorage.Compression
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return err
+	}
+	m.ID, m.CreatedAt = row.ID, row.CreatedAt
+	return nil
+}
+
+func (s *tableStore) Remove(tx *gorm.DB, m Migration) error {
+	return tx.Where("module = ? AND track = ? AND name = ? AND tenant = ?", m.Module, m.Track, m.Name, s.tenant).
+		Delete(&Migration{}).Error
+}
+
+func (s *tableStore) Lock(wait bool) (bool, error) {
+	if lockConfig.Mode == lockRow {
+		if s.rows == nil {
+			var err error
+			if s.rows, err = newRowLock(s.db, lockConfig); err != nil {
+				return false, err
+			}
+		}
+		return s.rows.Acquire(wait)
+	}
+	if wait {
+		if err := s.db.Exec("SELECT pg_advisory_lock(?)", advisoryLockKey).Error; err != nil {
+			return false, fmt.Errorf("can't acquire advisory lock: %w", err)
+		}
+		return true, nil
+	}
+	var acquired bool
+	if err := s.db.Raw("SELECT pg_try_advisory_lock(?)", advisoryLockKey).Scan(&acquired).Error; err != nil {
+		return false, fmt.Errorf("can't acquire advisory lock: %w", err)
+	}
+	return acquired, nil
+}
+
+// Unlock releases row lock, advisory lock is released with the connection anyway.
+func (s *tableStore) Unlock() {
+	if s.rows != nil {
+		s.rows.Release()
+	}
+}
+
+// unlocker is implemented by stores whose lock has to be released explicitly, not by exit of the process.
+type unlocker interface {
+	Unlock()
+}
+
+// unlock releases lock of the store if it needs that.
+func unlock(store VersionStore) {
+	if u, ok := store.(unlocker); ok {
+		u.Unlock()
+	}
+}
+
+// bodyFetcher is implemented by stores listing migrations without bodies, they are fetched one by one on demand.
+type bodyFetcher interface {
+	Body(m Migration) (string, error)
+}
+
+// appliedBody returns body of applied migration fetching it from the store if it is not listed.
+func appliedBody(store VersionStore, m Migration) (string, error) {
+	if fetcher, ok := store.(bodyFetcher); ok && m.Body == "" {
+		return fetcher.Body(m)
+	}
+	return m.Body, nil
+}
+
+// appliedOf returns migrations of the module track in order of names.
+func appliedOf(all []Migration, module, track string) []Migration {
+	var applied []Migration
+	for _, m := range all {
+		if m.Module == module && m.Track == track {
+			applied = append(applied, m)
+		}
+	}
+	sort.SliceStable(applied, func(i, j int) bool {
+		return applied[i].Name < applied[j].Name
+	})
+	return applied
+}
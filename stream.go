@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultStreamThreshold is size of migration file starting from which it is streamed instead of being read into memory.
+	defaultStreamThreshold = 64 << 20
+	// maxStreamedHeader limits header of streamed migration kept in memory for its directives and metadata.
+	maxStreamedHeader = 64 << 10
+)
+
+// streamSource is implemented by sources able to open files without reading them into memory.
+type streamSource interface {
+	Open(name string) (fs.File, error)
+}
+
+func (s fsSource) Open(name string) (fs.File, error) {
+	return s.fsys.Open(name)
+}
+
+// readStreamed prepares migration file of the source larger than threshold to be streamed. Only its header, comment lines
+// before the first statement, is kept as body, so directives and metadata of the header take effect. Checksum is computed
+// by reading the whole file once. False is returned for files which has to be read into memory: smaller ones, templates,
+// files with variables substitution or signatures to verify.
+func (l *loader) readStreamed(src source, name string, m *migrationFile) (bool, error) {
+	opener, ok := src.Source.(streamSource)
+	if !ok || l.streamThreshold <= 0 || l.verifier != nil || l.variables != nil ||
+		strings.HasSuffix(strings.TrimSuffix(name, gzipExt), templateExt) {
+		return false, nil
+	}
+	open := func() (io.ReadCloser, error) {
+		f, err := opener.Open(name)
+		if err != nil || !strings.HasSuffix(name, gzipExt) {
+			return f, err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return readCloser{gz, f}, nil
+	}
+	if large, err := atLeast(open, l.streamThreshold); err != nil || !large {
+		return false, err
+	}
+
+	m.stream = open
+	r, err := m.stream()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	m.checksums = l.checksums
+	hash, sum, err := m.checksums.Writer()
+	if err != nil {
+		return false, err
+	}
+	body := bufio.NewReader(io.TeeReader(r, hash))
+	if m.Body, err = readHeader(body); err != nil {
+		return false, err
+	}
+	rest, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return false, err
+	}
+	m.size = int64(len(m.Body)) + rest
+	if m.sum, err = sum(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// atLeast reports whether body opened by open has at least size bytes, compressed files are read up to it,
+// as threshold applies to size of decompressed body.
+func atLeast(open func() (io.ReadCloser, error), size int64) (bool, error) {
+	r, err := open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	if f, ok := r.(fs.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+		return info.Size() >= size, nil
+	}
+	n, err := io.CopyN(io.Discard, r, size)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n >= size, err
+}
+
+// streamSum returns checksum of streamed body reading it anew.
+func streamSum(open func() (io.ReadCloser, error), c checksummer) (string, error) {
+	h, sum, err := c.Writer()
+	if err != nil {
+		return "", err
+	}
+	r, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return sum()
+}
+
+// readCloser closes both decompressing reader and the file.
+type readCloser struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (r readCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if fileErr := r.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// readHeader reads leading comment and empty lines of SQL script.
+func readHeader(r *bufio.Reader) (string, error) {
+	var header strings.Builder
+	for header.Len() < maxStreamedHeader {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			break
+		}
+		if trimmed := strings.TrimSpace(string(line)); trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+			break
+		}
+		header.Write(line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return header.String(), nil
+}
+
+// applyStreamed executes statements of streamed migration one by one as they are read in a transaction
+// together with recording it into the store.
+func (r *runner) applyStreamed(m migrationFile) error {
+	started := time.Now()
+	body, err := m.stream()
+	if err != nil {
+		return fmt.Errorf("can't open migration: %w", err)
+	}
+	defer body.Close()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		restore, err := r.setSession(tx, m)
+		if err != nil {
+			return err
+		}
+		err = streamStatements(body, func(stmt statement) error {
+			if err := r.exec(tx, stmt.SQL).Error; err != nil {
+				return fmt.Errorf("can't execute statement at line %d (%s): %w", stmt.Line, snippet(stmt.SQL), err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := restore(); err != nil {
+			return err
+		}
+		record := r.record(m)
+		record.DurationMs = time.Since(started).Milliseconds()
+		if err := r.store.Record(tx, record); err != nil {
+			return fmt.Errorf("can't init migration stat: %w", err)
+		}
+		return nil
+	})
+}
+
+// streamStatements splits SQL script read from r into statements the same way as splitStatements,
+// holding only the current statement in memory.
+func streamStatements(r io.Reader, fn func(statement) error) error {
+	var (
+		in      = bufio.NewReader(r)
+		sql     strings.Builder // current statement
+		line    = 1
+		begin   = 1
+		hasCode bool
+		prev    byte
+	)
+	flush := func() error {
+		defer func() {
+			sql.Reset()
+			hasCode = false
+		}()
+		if !hasCode {
+			return nil
+		}
+		return fn(statement{SQL: strings.TrimSpace(sql.String()), Line: begin})
+	}
+	markCode := func() {
+		if !hasCode {
+			hasCode = true
+			begin = line
+		}
+	}
+	// next reads the next symbol into current statement
+	next := func() (byte, bool, error) {
+		c, err := in.ReadByte()
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if c == '\n' {
+			line++
+		}
+		sql.WriteByte(c)
+		return c, true, nil
+	}
+	peek := func(s string) bool {
+		b, _ := in.Peek(len(s))
+		return string(b) == s
+	}
+
+	for {
+		c, err := in.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if c == ';' {
+			if err := flush(); err != nil {
+				return err
+			}
+			prev = c
+			continue
+		}
+		sql.WriteByte(c)
+		switch {
+		case c == '\n':
+			line++
+		case c == '-' && peek("-"):
+			for {
+				c, ok, err := next()
+				if err != nil {
+					return err
+				}
+				if !ok || c == '\n' {
+					break
+				}
+			}
+		case c == '/' && peek("*"):
+			// Block comments in postgres may be nested
+			if _, _, err := next(); err != nil {
+				return err
+			}
+			for depth := 1; depth > 0; {
+				c, ok, err := next()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				switch {
+				case c == '/' && peek("*"):
+					depth++
+				case c == '*' && peek("/"):
+					depth--
+				default:
+					continue
+				}
+				if _, _, err := next(); err != nil {
+					return err
+				}
+			}
+		case c == '\'' || c == '"':
+			markCode()
+			// Backslash escapes are allowed only in E'...' strings
+			escapes := c == '\'' && (prev == 'E' || prev == 'e')
+			quote := c
+			for {
+				c, ok, err := next()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				if escapes && c == '\\' {
+					if _, _, err := next(); err != nil {
+						return err
+					}
+					continue
+				}
+				if c == quote {
+					// Doubled quote is an escaped quote
+					if peek(string(quote)) {
+						if _, _, err := next(); err != nil {
+							return err
+						}
+						continue
+					}
+					break
+				}
+			}
+		case c == '$':
+			markCode()
+			ahead, _ := in.Peek(64)
+			tag, ok := dollarTag("$" + string(ahead))
+			if !ok {
+				break
+			}
+			if _, err := in.Discard(len(tag) - 1); err != nil {
+				return err
+			}
+			sql.WriteString(tag[1:])
+			start := sql.Len()
+			for !strings.HasSuffix(sql.String()[start:], tag) {
+				if _, ok, err := next(); err != nil {
+					return err
+				} else if !ok {
+					break
+				}
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+		default:
+			markCode()
+		}
+		prev = c
+	}
+	return flush()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkReader returns at most size bytes by each read, so statements are split across chunks.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.size {
+		p = p[:c.size]
+	}
+	return c.r.Read(p)
+}
+
+func collectStatements(r io.Reader) ([]statement, error) {
+	var result []statement
+	err := streamStatements(r, func(s statement) error {
+		result = append(result, s)
+		return nil
+	})
+	return result, err
+}
+
+func TestStreamStatements(t *testing.T) {
+	for _, tt := range splitTests {
+		for _, size := range []int{1, 2, 3, 7, 4096} {
+			t.Run(fmt.Sprintf("%s/%d", tt.name, size), func(t *testing.T) {
+				got, err := collectStatements(chunkReader{strings.NewReader(tt.sql), size})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("streamStatements(%q) = %q, want %q", tt.sql, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// TestStreamStatementsBufferBoundary checks quotes and comments crossing boundary of the read buffer.
+func TestStreamStatementsBufferBoundary(t *testing.T) {
+	tails := []string{
+		"SELECT $body$a;b$body$;\nSELECT 2;",
+		"SELECT 'a'';b';\nSELECT 2;",
+		"/* a /* b; */ c; */ SELECT 1;\nSELECT 2;",
+		"SELECT E'\\';';\nSELECT 2;",
+	}
+	for _, tail := range tails {
+		for pad := 4080; pad < 4100; pad++ {
+			sql := "SELECT '" + strings.Repeat("x", pad) + "';\n" + tail
+			got, err := collectStatements(iotest.OneByteReader(strings.NewReader(sql)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := splitStatements(sql); !reflect.DeepEqual(got, want) {
+				t.Errorf("streamStatements of %q padded by %d: %q, want %q", tail, pad, got, want)
+			}
+		}
+	}
+}
+
+func TestStreamStatementsError(t *testing.T) {
+	wantErr := fmt.Errorf("stop")
+	calls := 0
+	err := streamStatements(strings.NewReader("SELECT 1; SELECT 2;"), func(statement) error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr || calls != 1 {
+		t.Errorf("streamStatements returned %v after %d calls, want %v after 1 call", err, calls, wantErr)
+	}
+}
+
+func TestStreamSumNormalized(t *testing.T) {
+	body := bom + "CREATE TABLE a (id int);  \r\n\r\nINSERT INTO a VALUES (1);\r\n"
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(iotest.HalfReader(strings.NewReader(body))), nil
+	}
+	for _, c := range []ChecksumConfig{{}, {Normalize: true}, {Algorithm: checksumXXHash, Normalize: true}} {
+		sums := newChecksummer(c, Normalization{StripBOM: true, LineEndings: true, TrimTrailingWhitespace: true})
+		want, err := sums.Sum(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := streamSum(open, sums); err != nil || got != want {
+			t.Errorf("streamSum by %s = %s, %v, want %s", sums.Scheme(), got, err, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// targetEnv selects one of targets of multi-target config by its address, i.e. "db1.example.com:5432/app".
+// The first target is used by default.
+const targetEnv = "MIGRATOR_TARGET"
+
+// Address returns address of the database without credentials, i.e. "db.example.com:5432/app".
+func (c DatabaseConfig) Address() string {
+	return fmt.Sprintf("%s:%d/%s", c.Host, c.Port, c.Name)
+}
+
+// Inherit returns settings with fields not set taken from defaults. Password and password file replace each other.
+func (c DatabaseConfig) Inherit(defaults DatabaseConfig) DatabaseConfig {
+	if c.Password != "" || c.PasswordFile != "" {
+		defaults.Password, defaults.PasswordFile = "", ""
+	}
+	v, d := reflect.ValueOf(&c).Elem(), reflect.ValueOf(defaults)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			v.Field(i).Set(d.Field(i))
+		}
+	}
+	return c
+}
+
+// params returns connection parameters of URL. Timeouts are sent as run-time parameters of the session.
+func (c DatabaseConfig) params() url.Values {
+	params := url.Values{}
+	if c.SSLMode != "" {
+		params.Set("sslmode", c.SSLMode)
+	}
+	if c.ConnectTimeout > 0 {
+		params.Set("connect_timeout", strconv.Itoa(int(c.ConnectTimeout.Seconds())))
+	}
+	if c.StatementTimeout > 0 {
+		params.Set("statement_timeout", strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10))
+	}
+	if c.LockTimeout > 0 {
+		params.Set("lock_timeout", strconv.FormatInt(c.LockTimeout.Milliseconds(), 10))
+	}
+	return params
+}
+
+// selectTarget applies defaults to database and targets, the target selected by environment becomes database
+// of the config.
+func selectTarget(config *Config) error {
+	if len(config.Targets) == 0 {
+		config.Database = config.Database.Inherit(config.Defaults)
+		return nil
+	}
+	if config.Database != (DatabaseConfig{}) {
+		return fmt.Errorf("database and targets can't be configured together, common settings of targets belong to defaults")
+	}
+	for i := range config.Targets {
+		config.Targets[i] = config.Targets[i].Inherit(config.Defaults)
+	}
+	config.Database = config.Targets[0]
+	selected := os.Getenv(targetEnv)
+	if selected == "" {
+		return nil
+	}
+	for _, t := range config.Targets {
+		if t.Address() == selected {
+			config.Database = t
+			return nil
+		}
+	}
+	return fmt.Errorf("target %s given by %s is not configured", selected, targetEnv)
+}
+
+// requireTarget refuses to connect to the first one of several targets, as commands other than
+// up, check and status act on a single database.
+func requireTarget(config Config) error {
+	if len(config.Targets) > 1 && os.Getenv(targetEnv) == "" {
+		return fmt.Errorf("config has %d targets, select one of them by %s, i.e. %s=%s", len(config.Targets),
+			targetEnv, targetEnv, config.Targets[0].Address())
+	}
+	return nil
+}
+
+// multiTarget reports whether the command has to run over every target of config, as none is selected
+// by environment.
+func multiTarget(config Config) bool {
+	return len(config.Targets) > 0 && os.Getenv(targetEnv) == ""
+}
+
+// targetArgs returns flags given to the command except ones served by this run only.
+func targetArgs(flags *flag.FlagSet, except ...string) []string {
+	var args []string
+	flags.Visit(func(f *flag.Flag) {
+		for _, name := range except {
+			if f.Name == name {
+				return
+			}
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// runTargets runs the command for targets one by one, each one by a separate run of migrator itself,
+// so they don't share connections, locks and reports. After a target has failed others are processed only
+// with next-target or continue failure policy. Run report of each target is written next to configured one
+// with number of the target, i.e. report-2.json. Exit code of pending migrations is returned if any target
+// has them.
+func runTargets(path string, config Config, command string, args []string) {
+	var failed []string
+	pending := false
+	for i, t := range config.Targets {
+		if len(failed) > 0 && config.FailurePolicy != failureNextTarget && config.FailurePolicy != failureContinue {
+			logrus.Warnf("target %s is skipped", t.Address())
+			continue
+		}
+		logrus.Infof("running %s for %s", command, t.Address())
+		cmd := exec.Command(os.Args[0], append([]string{command}, args...)...)
+		cmd.Env = append(os.Environ(), "MIGRATOR_CONFIG="+path, targetEnv+"="+t.Address())
+		if output := config.Report.Output; output != "" {
+			ext := filepath.Ext(output)
+			cmd.Env = append(cmd.Env, fmt.Sprintf("MIGRATOR_REPORT=%s-%d%s", strings.TrimSuffix(output, ext), i+1, ext))
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+		case errors.As(err, &exitErr) && (exitErr.ExitCode() == exitPending || exitErr.ExitCode() == exitOutsideWindow):
+			pending = true
+		default:
+			failed = append(failed, t.Address())
+			logrus.WithError(err).Errorf("%s has failed for %s", command, t.Address())
+		}
+	}
+	if len(failed) > 0 {
+		logrus.Fatalf("%s has failed for %s", command, strings.Join(failed, ", "))
+	}
+	if pending {
+		os.Exit(exitPending)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateExt is extension of migration files rendered by text/template before execution.
+const templateExt = ".tmpl"
+
+// templateData is available inside of migration templates as dot.
+type templateData struct {
+	Vars   map[string]string // variables defined in config
+	Name   string
+	Module string
+	Track  string
+}
+
+var templateFuncs = template.FuncMap{
+	// env returns value of environment variable
+	"env": os.Getenv,
+	// default returns given value if piped one is empty: {{ env "PARTITIONS" | default "8" }}
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || fmt.Sprint(value) == "" {
+			return def
+		}
+		return value
+	},
+	// quote returns SQL string literal
+	"quote": func(value interface{}) string {
+		return "'" + strings.Replace(fmt.Sprint(value), "'", "''", -1) + "'"
+	},
+	// ident returns quoted SQL identifier
+	"ident": func(value interface{}) string {
+		return `"` + strings.Replace(fmt.Sprint(value), `"`, `""`, -1) + `"`
+	},
+	// seq returns numbers from 0 to n-1 to generate repeated DDL: {{ range seq .Vars.PARTITIONS }}
+	"seq": func(n interface{}) ([]int, error) {
+		count, err := strconv.Atoi(fmt.Sprint(n))
+		if err != nil {
+			return nil, err
+		}
+		result := make([]int, count)
+		for i := range result {
+			result[i] = i
+		}
+		return result, nil
+	},
+}
+
+// renderTemplate executes migration template with config-defined values.
+// Missing keys are errors to not execute half-rendered SQL.
+func renderTemplate(body string, data templateData) (string, error) {
+	if data.Vars == nil {
+		data.Vars = make(map[string]string)
+	}
+	tmpl, err := template.New(data.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", err
+	}
+	var result strings.Builder
+	if err := tmpl.Execute(&result, data); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
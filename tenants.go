@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Tenants configures schema-per-tenant mode, in which up applies migrations to each tenant schema in turn
+// and tracks applied migrations per tenant in the shared tracking table.
+type Tenants struct {
+	// Schemas of tenants in order of applying
+	Schemas []string `yaml:"schemas" binding:"dive,required"`
+	// Query returning names of tenant schemas in addition to listed ones,
+	// i.e. SELECT nspname FROM pg_namespace WHERE nspname LIKE 'tenant\_%' ORDER BY 1
+	Query string `yaml:"query"`
+}
+
+// Enabled reports whether migrations are applied per tenant.
+func (t Tenants) Enabled() bool {
+	return len(t.Schemas) > 0 || t.Query != ""
+}
+
+// tenantScoper is implemented by stores able to track migrations of tenants separately.
+type tenantScoper interface {
+	// ForTenant returns store of migrations applied to the tenant schema, sharing the lock of this one
+	ForTenant(tenant string) VersionStore
+}
+
+// listTenants returns configured tenant schemas followed by ones returned by the query, without duplicates.
+func listTenants(db *gorm.DB, t Tenants) ([]string, error) {
+	tenants := append([]string(nil), t.Schemas...)
+	if t.Query != "" {
+		var found []string
+		if err := db.Raw(t.Query).Scan(&found).Error; err != nil {
+			return nil, fmt.Errorf("can't list tenants: %w", err)
+		}
+		tenants = append(tenants, found...)
+	}
+	seen := make(map[string]bool, len(tenants))
+	result := tenants[:0]
+	for _, tenant := range tenants {
+		if !seen[tenant] {
+			seen[tenant] = true
+			result = append(result, tenant)
+		}
+	}
+	return result, nil
+}
+
+// tenantScopes returns scopes of tenant schemas in order of applying, each one tracked by its own rows of the store.
+func tenantScopes(db *gorm.DB, store VersionStore, config Config) ([]upScope, error) {
+	scoper, ok := store.(tenantScoper)
+	if !ok {
+		return nil, fmt.Errorf("version store doesn't support tenants")
+	}
+	tenants, err := listTenants(db, config.Tenants)
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("no tenant schemas are found")
+	}
+	scopes := make([]upScope, len(tenants))
+	for i, tenant := range tenants {
+		scopes[i] = upScope{tenant: tenant, config: config, store: scoper.ForTenant(tenant)}
+		scopes[i].config.Schema = tenant
+	}
+	return scopes, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// trackingSchemaVersion is version of tracking table schema of this migrator, it is kept in comment of the table.
+// It has to be increased with each change of Migration model, tables of lower versions are upgraded on connect.
+const trackingSchemaVersion = 3
+
+// trackingSchemaPrefix starts comment of tracking table keeping its schema version, i.e. "migrator schema 1".
+const trackingSchemaPrefix = "migrator schema "
+
+// trackingSchemaLockKey identifies transaction advisory lock serializing upgrades of tracking table by concurrent runs.
+const trackingSchemaLockKey = advisoryLockKey + 1
+
+// upgradeTrackingTable creates tracking table or adds columns missed in tables created by older versions.
+// Table of the current version is left as is, so it is cheap to call on each connect. Table upgraded by a newer
+// migrator is refused as its rows may be recorded in a way this one doesn't understand.
+func upgradeTrackingTable(db *gorm.DB) error {
+	version, err := trackingTableVersion(db)
+	if err != nil || version == trackingSchemaVersion {
+		return err
+	}
+	if version > trackingSchemaVersion {
+		return fmt.Errorf("tracking table has schema version %d of a newer migrator, this one supports only %d, update migrator",
+			version, trackingSchemaVersion)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", trackingSchemaLockKey).Error; err != nil {
+			return fmt.Errorf("can't lock tracking table upgrade: %w", err)
+		}
+		// Another run may have upgraded the table while the lock was waited for
+		if version, err = trackingTableVersion(tx); err != nil || version >= trackingSchemaVersion {
+			return err
+		}
+		existed := tx.Migrator().HasTable(&Migration{})
+		var added []string
+		if existed {
+			stmt := &gorm.Statement{DB: tx}
+			if err := stmt.Parse(&Migration{}); err != nil {
+				return err
+			}
+			for _, field := range stmt.Schema.Fields {
+				if field.DBName != "" && !tx.Migrator().HasColumn(&Migration{}, field.DBName) {
+					added = append(added, field.DBName)
+				}
+			}
+		}
+		if err := tx.AutoMigrate(&Migration{}); err != nil {
+			return fmt.Errorf("can't upgrade tracking table: %w", err)
+		}
+		if slices.Contains(added, "size") {
+			// Sizes of rows recorded by older versions are known from their plain bodies
+			if err := tx.Exec("UPDATE migrations SET size = octet_length(body) WHERE body_encoding = '' AND body IS NOT NULL").Error; err != nil {
+				return fmt.Errorf("can't fill sizes of applied migrations: %w", err)
+			}
+		}
+		if err := tx.Exec(fmt.Sprintf("COMMENT ON TABLE migrations IS '%s%d'", trackingSchemaPrefix, trackingSchemaVersion)).Error; err != nil {
+			return fmt.Errorf("can't set tracking table schema version: %w", err)
+		}
+		switch {
+		case !existed:
+			logrus.Debugf("tracking table is created with schema version %d", trackingSchemaVersion)
+		case len(added) > 0:
+			logrus.WithField("columns", strings.Join(added, ", ")).
+				Infof("tracking table is upgraded from schema version %d to %d", version, trackingSchemaVersion)
+		default:
+			logrus.Debugf("tracking table schema version is set to %d", trackingSchemaVersion)
+		}
+		return nil
+	})
+}
+
+// trackingTableVersion returns schema version of tracking table, zero if it is missed or created by older versions.
+func trackingTableVersion(db *gorm.DB) (int, error) {
+	var comment string
+	if err := db.Raw("SELECT coalesce(obj_description(to_regclass('migrations'), 'pg_class'), '')").Scan(&comment).Error; err != nil {
+		return 0, fmt.Errorf("can't get tracking table schema version: %w", err)
+	}
+	if !strings.HasPrefix(comment, trackingSchemaPrefix) {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(comment, trackingSchemaPrefix))
+	if err != nil {
+		return 0, fmt.Errorf("invalid tracking table schema version %q", comment)
+	}
+	return version, nil
+}
+
+// checkMigratorVersion refuses binary older than required by config and binary of an older major version than
+// recorded migrations of the database, as tracking data of newer versions may be changed incompatibly.
+// Builds without release version are not checked.
+func checkMigratorVersion(db *gorm.DB, config Config) error {
+	current, ok := parseMigratorVersion(version)
+	if !ok {
+		logrus.Debugf("migrator version %s is not checked", version)
+		return nil
+	}
+	if config.MinMigratorVersion != "" {
+		required, _ := parseMigratorVersion(config.MinMigratorVersion)
+		if compareVersions(current, required) < 0 {
+			return fmt.Errorf("migrator %s is older than %s required by config, update migrator", version, config.MinMigratorVersion)
+		}
+	}
+	var recorded []string
+	if err := db.Model(&Migration{}).Distinct("migrator_version").Where("migrator_version <> ''").
+		Pluck("migrator_version", &recorded).Error; err != nil {
+		return fmt.Errorf("can't get versions of migrator applied migrations: %w", err)
+	}
+	for _, r := range recorded {
+		if v, ok := parseMigratorVersion(r); ok && v[0] > current[0] {
+			return fmt.Errorf("database is managed by migrator %s, this one %s is of an older major version, update migrator", r, version)
+		}
+	}
+	return nil
+}
+
+// parseMigratorVersion parses release version like v1.2.3 into its numeric parts, pre-release and build
+// suffixes are ignored. False is returned for builds without version like "dev".
+func parseMigratorVersion(s string) ([]int64, bool) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	var v []int64
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		v = append(v, n)
+	}
+	return v, true
+}
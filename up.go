@@ -0,0 +1,504 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// upOptions are flags of up command.
+type upOptions struct {
+	*cliOptions
+	Tracks []string
+	From   string
+	Shadow bool
+	Canary bool
+	Test   bool
+	// All pending migrations are applied in one transaction, so failure leaves the database as it was
+	SingleTransaction bool
+	Rollback          bool
+	Check             bool
+	LockImpact        bool
+	Explain           bool
+	// Approved plan, the run is refused if the database or migrations have changed since planning
+	Plan *plan
+	// Replicas not holding the lock wait for the leader and only verify the schema
+	WaitForLeader time.Duration
+	// Commit migrations are applied from, HEAD of git checkout by default
+	GitSHA string
+}
+
+// runUp applies pending migrations.
+func runUp(args []string) {
+	flags := flag.NewFlagSet("up", flag.ExitOnError)
+	o := upOptions{Tracks: allTracks}
+	healthAddr := flags.String("health-addr", "", "serve readiness endpoint on the address, i.e. :8080, until termination")
+	track := flags.String("track", "", "apply migrations of the only track: schema or data (all tracks by default)")
+	schema := flags.String("schema", "", "default search_path of migrations, i.e. billing,public (overrides config)")
+	overlay := flags.String("overlay", "", "directory with hotfix migrations added to embedded ones (overrides config)")
+	flags.StringVar(&o.From, "from", "", "read migrations only from the given source, i.e. oci://registry/app/migrations:1.4.0")
+	flags.StringVar(&o.From, "bundle", "", "read migrations only from the bundle written by bundle create, i.e. migrations-1.4.0.tgz")
+	flags.BoolVar(&o.Shadow, "shadow", false, "apply pending migrations to a temporary clone of the database first")
+	flags.BoolVar(&o.Canary, "canary", false, "time pending migrations on a temporary clone with sampled data first and apply them only within canary budgets")
+	flags.BoolVar(&o.Test, "test", false, "apply pending migrations in a single transaction and roll it back")
+	flags.BoolVar(&o.SingleTransaction, "single-transaction", false, "apply all pending migrations in one transaction")
+	flags.DurationVar(&o.WaitForLeader, "wait-for-leader", 0,
+		"if another replica applies migrations, wait up to the timeout for it and only verify the schema")
+	flags.BoolVar(&o.Check, "check", false, fmt.Sprintf("only verify applied migrations, exit with code %d if there are pending ones", exitPending))
+	flags.StringVar(&o.GitSHA, "git-sha", "", "commit SHA to record for applied migrations (HEAD of git checkout by default)")
+	flags.BoolVar(&o.LockImpact, "lock-impact", false,
+		"only print locks pending migrations take and current activity on the tables")
+	flags.BoolVar(&o.Explain, "explain", false, "only print rows UPDATE and DELETE statements of pending migrations are estimated to change")
+	flags.BoolVar(&o.Rollback, "rollback-on-failure", false, "roll back migrations applied in the run by their down files when one fails")
+	o.cliOptions = addCommonFlags(flags)
+	_ = flags.Parse(args)
+	if *track != "" {
+		if *track != trackSchema && *track != trackData {
+			logrus.Fatalf("unknown track %q, available values: %s, %s", *track, trackSchema, trackData)
+		}
+		o.Tracks = []string{*track}
+	}
+
+	path := configPath()
+	config := initConfig(path)
+	if *schema != "" {
+		config.Schema = *schema
+	}
+	if *overlay != "" {
+		config.Overlay = *overlay
+	}
+	o.Setup()
+	var health *healthServer
+	if *healthAddr != "" {
+		health = startHealthServer(*healthAddr, config)
+	}
+	if multiTarget(config) {
+		// Readiness is served by this run only
+		runTargets(path, config, "up", targetArgs(flags, "health-addr"))
+	} else {
+		up(config, o)
+	}
+	if health != nil {
+		health.Complete()
+	}
+}
+
+// up applies pending migrations to the configured database, in tenants mode to each tenant schema in turn
+// within the same run.
+func up(config Config, o upOptions) {
+	started := time.Now()
+	report := newReport(config, "up", started)
+	if config.Audit.Webhook.URL != "" {
+		events = multiEvents{events, newWebhookEvents(config)}
+	}
+	run := newEventsRun()
+
+	db := connectDB(config)
+	pgVersion, err := serverVersion(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	report.SetServerVersion(pgVersion)
+	if err := checkMinServerVersion(config, pgVersion); err != nil {
+		logrus.Fatal(err)
+	}
+
+	store := newVersionStore(db)
+	leader := true
+	switch {
+	case o.Check:
+		// Check only reads tracking rows, so CI gates don't wait for migrations being applied by another run
+	case o.WaitForLeader > 0:
+		var err error
+		if leader, err = lockOrFollow(store, o.WaitForLeader); err != nil {
+			logrus.Fatal(err)
+		}
+	default:
+		if _, err := store.Lock(true); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	defer unlock(store)
+
+	u := &upRun{config: config, o: o, db: db, store: store, version: pgVersion, leader: leader,
+		loader: newLoader(config, o.From), report: report, started: started}
+	scopes := []upScope{{config: config, store: store}}
+	if config.Tenants.Enabled() {
+		if scopes, err = tenantScopes(db, store, config); err != nil {
+			logrus.Fatal(err)
+		}
+		if o.Plan != nil {
+			logrus.Fatal("plans are not supported in tenants mode")
+		}
+	}
+	var names []string
+	for i := range scopes {
+		scopes[i].pending = u.readPending(scopes[i])
+		for _, m := range scopes[i].pending {
+			names = append(names, m.Path())
+		}
+	}
+	events.OnStart(names)
+
+	status, code := statusUpToDate, 0
+	for _, s := range scopes {
+		if s.tenant != "" {
+			logrus.Infof("migrations of tenant %s", s.tenant)
+			report.SetTenant(s.tenant)
+		}
+		scopeStatus, scopeCode := u.apply(s)
+		if scopeStatus != statusUpToDate && status != statusPending {
+			status = scopeStatus
+		}
+		if scopeCode != 0 {
+			code = scopeCode
+		}
+		// Tenants are checked all, but none is started outside of maintenance window
+		if code == exitOutsideWindow {
+			break
+		}
+	}
+	report.Finish(status)
+	run.Finish(status)
+	if code != 0 {
+		unlock(store)
+		os.Exit(code)
+	}
+}
+
+// upRun is state of up command shared by tenants.
+type upRun struct {
+	config  Config
+	o       upOptions
+	db      *gorm.DB
+	store   VersionStore // store holding the lock
+	version int
+	leader  bool
+	loader  *loader
+	report  *runReport
+	started time.Time
+	// Database is backed up once before the first migration of the run
+	backedUp bool
+}
+
+// upScope is the database or a tenant schema of it migrations are applied to.
+type upScope struct {
+	tenant  string
+	config  Config // config with search_path of the tenant
+	store   VersionStore
+	pending []migrationFile
+}
+
+// readPending returns pending migrations of the scope, verified against the plan if it is given.
+func (u *upRun) readPending(s upScope) []migrationFile {
+	var applied []Migration
+	if u.o.Plan != nil {
+		var err error
+		if applied, err = s.store.List(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	pending := readPending(u.db, s.store, u.loader, s.config, u.o.Tracks)
+	if u.o.Plan != nil {
+		if err := u.o.Plan.Verify(s.config, applied, pending); err != nil {
+			logrus.WithError(err).Fatal("database doesn't match the plan, make a new one")
+		}
+	}
+	return pending
+}
+
+// apply applies pending migrations of the scope and returns status of the run and exit code when
+// migrations are left pending.
+func (u *upRun) apply(s upScope) (string, int) {
+	config, o, db, pending := s.config, u.o, u.db, s.pending
+	if o.LockImpact {
+		if err := printLockImpact(db, pending); err != nil {
+			logrus.Fatal(err)
+		}
+		return statusPending, 0
+	}
+	if o.Explain {
+		if err := printExplain(db, &runner{schema: config.Schema}, pending); err != nil {
+			logrus.Fatal(err)
+		}
+		return statusPending, 0
+	}
+	if o.Check && len(pending) > 0 {
+		if !o.Quiet {
+			fmt.Printf("Found %d pending migrations.\n", len(pending))
+		}
+		return statusPending, exitPending
+	}
+	// Rows of squashed migrations are seen as baselines by verification, they are rewritten under the lock
+	if u.leader && !o.Test && !o.Check {
+		if err := rewriteSquashedApplied(db, s.store, u.loader, config, o.Tracks); err != nil {
+			logrus.WithError(err).Fatal("can't rewrite squashed migrations")
+		}
+	}
+	if !u.leader && len(pending) > 0 {
+		logrus.Fatalf("leader has finished, but %d migrations expected by this binary are still pending", len(pending))
+	}
+	if len(pending) == 0 {
+		if !o.Check || !o.Quiet {
+			fmt.Println("Found no one new migration, your database is up to date.")
+		}
+		return statusUpToDate, 0
+	}
+	if !o.Test {
+		open, err := awaitWindow(config.Window)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if !open {
+			fmt.Printf("Found %d pending migrations, they are not applied outside of maintenance window.\n", len(pending))
+			return statusPending, exitOutsideWindow
+		}
+	}
+
+	all, err := s.store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := checkRequirements(all, pending); err != nil {
+		logrus.Fatal(err)
+	}
+	replicas, err := openReplicas(config.Replicas)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := checkReplicationLag(replicas, config.Replicas); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := checkDiskSpace(db, config.Disk, pending, o.Test || o.SingleTransaction); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if o.Shadow {
+		if err := shadowRun(db, config, u.version, pending); err != nil {
+			logrus.WithError(err).Fatal("shadow run has failed, the database is left untouched")
+		}
+		logrus.Info("shadow run has succeeded")
+	}
+	if o.Canary {
+		if err := canaryRun(db, config, u.version, pending); err != nil {
+			logrus.WithError(err).Fatal("canary run has failed, the database is left untouched")
+		}
+		logrus.Info("canary run has stayed within budgets")
+	}
+
+	// In test mode everything is done in a single transaction, which is rolled back at the end,
+	// so backup is not needed. Transaction is aborted on failure, as connection is closed on exit.
+	conn := db
+	if o.Test || o.SingleTransaction {
+		if conn = db.Begin(); conn.Error != nil {
+			logrus.WithError(conn.Error).Fatal("can't begin transaction")
+		}
+	}
+	if !o.Test && config.Backup.Output != "" && !u.backedUp {
+		location, err := backup(config, u.started)
+		if err != nil {
+			logrus.WithError(err).Fatal("can't backup database, migrations are not applied")
+		}
+		logrus.Infof("database is backed up to %s", redactURL(location))
+		u.backedUp = true
+	}
+
+	if err := runHooks(conn, "beforeRun", config.Hooks.BeforeRun, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// Next migrations expected as new and will be incremental applied now
+	p := newProgress(os.Stdout, len(pending), o.Quiet)
+	r := &runner{
+		db:        conn,
+		store:     s.store,
+		progress:  p,
+		verbose:   o.Verbose || logrus.IsLevelEnabled(logrus.DebugLevel),
+		version:   u.version,
+		skipList:  newSkipList(config.Skip),
+		env:       config.Environment,
+		schema:    config.Schema,
+		dial:      func() (*gorm.DB, error) { return dial(config) },
+		vcs:       newVCSInfo(config, o.GitSHA),
+		retry:     config.Retry,
+		reconnect: func() error { return reacquire(db, u.store, config) },
+	}
+	skipped := make(map[string]string)
+	failed := newFailures(config.FailurePolicy)
+	for i, m := range pending {
+		// Pauses inside of a transaction would only hold its locks longer
+		if i > 0 && conn == db {
+			if err := pace(db, replicas, config.Pacing); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		p.Start(m.Path())
+		if m, err = m.loaded(); err != nil {
+			p.Fail()
+			logrus.Fatal(err)
+		}
+		if reason := failed.Blocked(m); reason != "" {
+			u.report.Block(m, reason)
+			skipped[m.Path()] = reason
+			p.Skip(reason)
+			continue
+		}
+		if err := runHooks(conn, "beforeMigration", config.Hooks.BeforeMigration, &m); err != nil {
+			p.Fail()
+			logrus.Fatal(err)
+		}
+		thresholds, err := slowThresholdsOf(config.Slow, m)
+		if err != nil {
+			p.Fail()
+			logrus.Fatal(err)
+		}
+		stopWatch := watchSlow(thresholds, m, config.Hooks.Notify)
+		migrationStarted := time.Now()
+		reason, err := r.Run(m)
+		stopWatch()
+		u.report.Add(m, migrationStarted, reason, err)
+		log := logrus.WithFields(logrus.Fields{"migration": m.Path(), "duration": time.Since(migrationStarted)})
+		if s.tenant != "" {
+			log = log.WithField("tenant", s.tenant)
+		}
+		if err != nil {
+			p.Fail()
+			if failed.Add(m) {
+				log.WithError(err).Errorf("can't apply migration %s, run goes on by %s policy", m.Path(), failed.policy)
+				continue
+			}
+			if o.Rollback {
+				if err := rollbackRun(r, u.loader, pending[:i], skipped, u.report); err != nil {
+					logrus.WithError(err).Error("can't roll back the run")
+				}
+			}
+			log.WithError(err).Fatalf("can't apply migration %s", m.Path())
+		}
+		if reason != "" {
+			log = log.WithField("skipReason", reason)
+		}
+		log.Debug("migration is done")
+		events.OnMigrationApplied(m.Path(), reason, time.Since(migrationStarted))
+		if err := runHooks(conn, "afterMigration", config.Hooks.AfterMigration, &m); err != nil {
+			p.Fail()
+			logrus.Fatal(err)
+		}
+		if reason != "" {
+			skipped[m.Path()] = reason
+			p.Skip(reason)
+			continue
+		}
+		p.Done()
+	}
+
+	if err := runHooks(conn, "afterRun", config.Hooks.AfterRun, nil); err != nil {
+		logrus.Fatal(err)
+	}
+	if len(failed.failed) > 0 {
+		logrus.Fatalf("%d migrations have failed: %s", len(failed.failed), strings.Join(failed.failed, ", "))
+	}
+	if err := verifyPermissions(conn, config.Permissions); err != nil {
+		logrus.Fatal(err)
+	}
+	status, verb := statusApplied, "applied"
+	switch {
+	case o.Test:
+		if err := conn.Rollback().Error; err != nil {
+			logrus.WithError(err).Fatal("can't roll back test transaction")
+		}
+		status, verb = statusTested, "tested"
+		fmt.Println("Test run is over, all changes are rolled back.")
+	case o.SingleTransaction:
+		if err := conn.Commit().Error; err != nil {
+			logrus.WithError(err).Fatal("can't commit transaction")
+		}
+	}
+	if !o.Test {
+		if err := waitForReplicas(db, replicas, config.Replicas); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if o.Quiet {
+		fmt.Printf("Has %s %d migrations (%d skipped) in %.1fs\n",
+			verb, len(pending)-len(skipped), len(skipped), time.Since(u.started).Seconds())
+		return status, 0
+	}
+	fmt.Printf("Has %s migrations:\n", verb)
+	for _, m := range pending {
+		if reason, ok := skipped[m.Path()]; ok {
+			fmt.Printf(" -  %s (skipped: %s)\n", m.Path(), reason)
+			continue
+		}
+		fmt.Println(" - ", m.Path())
+	}
+	return status, 0
+}
+
+// newLoader returns loader of migrations from the only source given by -from flag or from embedded and configured ones.
+func newLoader(config Config, from string) *loader {
+	var sources []source
+	if from != "" {
+		src, err := openSource(from)
+		if err != nil {
+			logrus.WithError(err).Fatalf("can't open migrations source %s", redactURL(from))
+		}
+		sources = []source{src}
+	} else {
+		var err error
+		if sources, err = initSources(config); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	verifier, err := newSignatureVerifier(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	l := &loader{sources: sources, verifier: verifier, variables: config.Variables, warnGaps: config.Naming.WarnGaps,
+		streamThreshold: config.StreamThreshold, checksums: newChecksummer(config.Checksum, config.Normalization)}
+	if config.Naming.Pattern != "" {
+		if l.pattern, err = regexp.Compile(config.Naming.Pattern); err != nil {
+			logrus.WithError(err).Fatal("invalid naming pattern")
+		}
+	}
+	return l
+}
+
+// readPending verifies applied migrations of the tracks against files and returns pending ones in order of applying.
+func readPending(db *gorm.DB, store VersionStore, l *loader, config Config, tracks []string) []migrationFile {
+	all, err := store.List()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	var pending []migrationFile
+	for _, track := range tracks {
+		// Modules are applied in order they are declared in config
+		for _, module := range append([]string{moduleDefault}, config.Modules...) {
+			// Bodies are read lazily, so only one applied migration at a time is held in memory
+			files, err := l.List(module, track)
+			if err == nil && len(files) > 0 {
+				err = l.Load(&files[0])
+			}
+			if err != nil {
+				logrus.WithError(err).Fatal("can't read migrations")
+			}
+			applied := appliedOf(all, module, track)
+			if applied, _, err = squashedApplied(files, applied); err != nil {
+				logrus.WithError(err).Fatal("can't read squashed migrations")
+			}
+			if err := verifyAppliedLazily(db, l, store, applied, files, config); err != nil {
+				logrus.WithError(err).Fatal("can't read migrations")
+			}
+
+			// Trim from box migrations whose already applied, their bodies are read when they are applied
+			pending = append(pending, files[len(applied):]...)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runValidate reads all migrations the way up does without connecting to the database: names are checked
+// against naming convention and for duplicate versions, signatures are verified, templates and includes are rendered.
+func runValidate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	from := flags.String("from", "", "read migrations only from the given source")
+	opts := addCommonFlags(flags)
+	_ = flags.Parse(args)
+
+	config := initConfig(configPath())
+	opts.Setup()
+	l := newLoader(config, *from)
+	count := 0
+	for _, track := range allTracks {
+		for _, module := range append([]string{moduleDefault}, config.Modules...) {
+			files, err := l.Read(module, track)
+			if err != nil {
+				annotate("error", "", 0, err.Error())
+				logrus.WithError(err).Fatal("invalid migrations")
+			}
+			count += len(files)
+		}
+	}
+	fmt.Printf("All %d migrations are valid.\n", count)
+}
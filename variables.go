@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// variablePattern matches ${NAME} placeholders, $${NAME} is an escaped placeholder.
+var variablePattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// substituteVariables replaces ${NAME} placeholders by values of config variables or environment variables,
+// config takes precedence. Undefined variable is an error to not execute half-rendered SQL.
+func substituteVariables(body string, variables map[string]string) (string, error) {
+	var undefined []string
+	result := variablePattern.ReplaceAllStringFunc(body, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		name := variablePattern.FindStringSubmatch(match)[1]
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		undefined = append(undefined, name)
+		return match
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("undefined variables: %s", strings.Join(undefined, ", "))
+	}
+	return result, nil
+}
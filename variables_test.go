@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestSubstituteVariables(t *testing.T) {
+	t.Setenv("MIGRATOR_TEST_SCHEMA", "env_schema")
+	t.Setenv("MIGRATOR_TEST_OWNER", "env_owner")
+	variables := map[string]string{"MIGRATOR_TEST_OWNER": "app", "tablespace": "fast", "EMPTY": ""}
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{name: "config", body: "ALTER TABLE a OWNER TO ${MIGRATOR_TEST_OWNER};", want: "ALTER TABLE a OWNER TO app;"},
+		{name: "environment", body: "CREATE SCHEMA ${MIGRATOR_TEST_SCHEMA};", want: "CREATE SCHEMA env_schema;"},
+		{name: "empty value", body: "SELECT '${EMPTY}';", want: "SELECT '';"},
+		{name: "several", body: "${tablespace} ${tablespace}", want: "fast fast"},
+		{name: "escaped", body: "SELECT '$${tablespace}';", want: "SELECT '${tablespace}';"},
+		{name: "dollar quoting", body: "DO $$ BEGIN END $$; SELECT $1;", want: "DO $$ BEGIN END $$; SELECT $1;"},
+		{name: "not a name", body: "SELECT '${1abc}', '${}', '$tablespace';", want: "SELECT '${1abc}', '${}', '$tablespace';"},
+		{name: "undefined", body: "SELECT ${MIGRATOR_TEST_UNDEFINED}, ${tablespace};", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := substituteVariables(tt.body, variables)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("substituteVariables(%q) = %q, want error", tt.body, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("substituteVariables(%q) = %q, %v, want %q", tt.body, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// vcsInfo finds git metadata of migrations when migrator is run from a checkout, so tracking table tells
+// which commit migrations were applied from and who has written them.
+type vcsInfo struct {
+	dirs []string // local directories migration files may be found in
+	sha  string   // commit given by flag, HEAD of checkout is used if empty
+
+	once sync.Once
+}
+
+// newVCSInfo returns info looking for files in migrations dir of the checkout and local sources of config.
+func newVCSInfo(config Config, sha string) *vcsInfo {
+	v := &vcsInfo{dirs: []string{migrationsDirName}, sha: sha}
+	for _, location := range config.Sources {
+		if !strings.Contains(location, "://") {
+			v.dirs = append(v.dirs, location)
+		}
+	}
+	return v
+}
+
+// SHA returns commit migrations are applied from, empty if it is unknown.
+func (v *vcsInfo) SHA() string {
+	v.once.Do(func() {
+		if v.sha == "" {
+			v.sha = git("rev-parse", "HEAD")
+		}
+	})
+	return v.sha
+}
+
+// Author returns author of the commit which has added migration file, empty if it is unknown.
+func (v *vcsInfo) Author(m migrationFile) string {
+	for _, dir := range v.dirs {
+		file := filepath.Join(dir, filepath.FromSlash(m.Path()))
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		lines := strings.Split(git("log", "--diff-filter=A", "--follow", "--format=%an <%ae>", "--", file), "\n")
+		// Log is ordered from the newest commit, the file is added by the last one
+		return lines[len(lines)-1]
+	}
+	return ""
+}
+
+// AppliedBody returns body of applied migration recorded without it from history of its file: revision of commit
+// it was applied from or any earlier revision with the same checksum. False is returned if it isn't found.
+func (v *vcsInfo) AppliedBody(m Migration, f migrationFile) (string, bool) {
+	for _, dir := range v.dirs {
+		file := filepath.Join(dir, filepath.FromSlash(f.Path()))
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		revisions := strings.Fields(git("log", "--format=%H", "--", file))
+		if m.GitSHA != "" {
+			revisions = append([]string{m.GitSHA}, revisions...)
+		}
+		for _, rev := range revisions {
+			// Path relative to current directory is resolved by git as "./"
+			body, err := exec.Command("git", "show", rev+":./"+filepath.ToSlash(file)).Output()
+			if err != nil {
+				continue
+			}
+			if strings.HasSuffix(f.Name, gzipExt) {
+				if body, err = decompress(body); err != nil {
+					continue
+				}
+			}
+			if ok, _ := matchesApplied(m, migrationFile{Body: string(body), checksums: f.checksums}); ok {
+				return string(body), true
+			}
+		}
+	}
+	return "", false
+}
+
+// git returns trimmed output of git command, empty if it has failed, i.e. outside of a checkout.
+func git(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Naming configures checks of migration file names.
+type Naming struct {
+	// Regular expression every migration file name must match, i.e. ^\d{4}_[a-z0-9_]+\.sql$
+	Pattern string `yaml:"pattern"`
+	// Warn when versions of sequentially numbered files skip numbers, i.e. 0003 follows 0001
+	WarnGaps bool `yaml:"warnGaps"`
+}
+
+// checkVersions fails if files of module track have the same numeric version prefix,
+// which is a classic merge accident leading to confusing order. Files without version prefix are not checked.
+func checkVersions(files []migrationFile, warnGaps bool) error {
+	seen := make(map[int64]migrationFile)
+	var prev *migrationFile
+	var prevVersion int64
+	for i := range files {
+		v, ok := fileVersion(files[i].Name)
+		if !ok {
+			continue
+		}
+		if other, ok := seen[v]; ok {
+			return fmt.Errorf("migrations %s and %s have the same version %d, renumber one of them", other.Path(), files[i].Path(), v)
+		}
+		seen[v] = files[i]
+		if warnGaps && prev != nil && v > prevVersion+1 {
+			logrus.Warnf("versions of migrations are not sequential: %s follows %s", files[i].Path(), prev.Path())
+		}
+		prev, prevVersion = &files[i], v
+	}
+	return nil
+}
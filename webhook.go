@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Webhook receives audit events of runs as JSON documents posted one by one.
+type Webhook struct {
+	URL string `yaml:"url" binding:"omitempty,url"`
+	// Body is signed by HMAC-SHA256 with the secret in X-Migrator-Signature header, i.e. "sha256=<hex>"
+	Secret string `yaml:"secret"`
+}
+
+// Types of audit events.
+const (
+	eventRunStarted          = "run.started"
+	eventMigrationApplied    = "migration.applied"
+	eventMigrationSkipped    = "migration.skipped"
+	eventMigrationRolledBack = "migration.rolled-back"
+	eventChecksumMismatch    = "checksum.mismatch"
+	eventRunFinished         = "run.finished"
+)
+
+// webhookEvent is JSON document posted to webhook.
+type webhookEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Database  string    `json:"database"` // host:port/name
+	Actor     string    `json:"actor"`
+	Host      string    `json:"host"`
+	Version   string    `json:"version"` // version of migrator binary
+	Migration string    `json:"migration,omitempty"`
+	Pending   []string  `json:"pending,omitempty"`
+	// Set for applied and skipped migrations
+	DurationMs int64  `json:"durationMs,omitempty"`
+	SkipReason string `json:"skipReason,omitempty"`
+	// Set for finished run
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// webhookEvents posts events to webhook. Failed deliveries are logged, they don't fail the run.
+type webhookEvents struct {
+	config   Webhook
+	database string
+	actor    string
+	host     string
+	client   *http.Client
+}
+
+func newWebhookEvents(config Config) *webhookEvents {
+	host, _ := os.Hostname()
+	return &webhookEvents{
+		config:   config.Audit.Webhook,
+		database: config.Target(),
+		actor:    actor(),
+		host:     host,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *webhookEvents) OnStart(pending []string) {
+	w.post(webhookEvent{Type: eventRunStarted, Pending: pending})
+}
+
+func (w *webhookEvents) OnMigrationApplied(name string, skipReason string, duration time.Duration) {
+	e := webhookEvent{Type: eventMigrationApplied, Migration: name, DurationMs: duration.Milliseconds()}
+	if skipReason != "" {
+		e.Type, e.SkipReason = eventMigrationSkipped, skipReason
+	}
+	w.post(e)
+}
+
+func (w *webhookEvents) OnMigrationRolledBack(name string) {
+	w.post(webhookEvent{Type: eventMigrationRolledBack, Migration: name})
+}
+
+func (w *webhookEvents) OnChecksumMismatch(name string) {
+	w.post(webhookEvent{Type: eventChecksumMismatch, Migration: name})
+}
+
+func (w *webhookEvents) OnFinish(status string, err error) {
+	e := webhookEvent{Type: eventRunFinished, Status: status}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	w.post(e)
+}
+
+func (w *webhookEvents) post(e webhookEvent) {
+	e.Time, e.Database, e.Actor, e.Host, e.Version = time.Now().UTC(), w.database, w.actor, w.host, version
+	if err := w.send(e); err != nil {
+		logrus.WithError(err).Errorf("can't deliver %s event to audit webhook", e.Type)
+	}
+}
+
+func (w *webhookEvents) send(e webhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.config.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Migrator-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook has responded with %s", resp.Status)
+	}
+	return nil
+}
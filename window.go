@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// exitOutsideWindow is exit code of up refusing to apply pending migrations outside of maintenance window.
+	exitOutsideWindow = 4
+	windowOutsideExit = "exit"
+)
+
+// MaintenanceWindow restricts when pending migrations may be applied.
+type MaintenanceWindow struct {
+	// Cron expressions of minutes migrations may start in, i.e. "* 2-4 * * 1-5" for 02:00-04:59 on weekdays
+	Schedules []string `yaml:"schedules"`
+	// Time zone of schedules like Europe/Berlin, local one by default
+	Timezone string `yaml:"timezone"`
+	// Outside of the window up waits (default) for it to open or exits with exitOutsideWindow code
+	Outside string `yaml:"outside" binding:"omitempty,oneof=wait exit"`
+	// Longest wait for the window to open, unlimited by default
+	MaxWait time.Duration `yaml:"maxWait"`
+}
+
+// window is parsed maintenance window.
+type window struct {
+	schedules []schedule
+	location  *time.Location
+}
+
+func parseMaintenanceWindow(c MaintenanceWindow) (window, error) {
+	w := window{location: time.Local}
+	if c.Timezone != "" {
+		var err error
+		if w.location, err = time.LoadLocation(c.Timezone); err != nil {
+			return w, fmt.Errorf("invalid time zone of maintenance window: %w", err)
+		}
+	}
+	for _, expr := range c.Schedules {
+		s, err := parseSchedule(expr)
+		if err != nil {
+			return w, err
+		}
+		w.schedules = append(w.schedules, s)
+	}
+	return w, nil
+}
+
+// Open reports whether the window is open at t.
+func (w window) Open(t time.Time) bool {
+	t = t.In(w.location)
+	for _, s := range w.schedules {
+		if s.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the window opens after t, zero if it never does.
+func (w window) Next(t time.Time) time.Time {
+	t = t.In(w.location)
+	var next time.Time
+	for _, s := range w.schedules {
+		if n := s.Next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// awaitWindow returns when maintenance window is open, waiting for it by policy. False is returned
+// when pending migrations must not be applied now.
+func awaitWindow(c MaintenanceWindow) (bool, error) {
+	if len(c.Schedules) == 0 {
+		return true, nil
+	}
+	w, err := parseMaintenanceWindow(c)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if w.Open(now) {
+		return true, nil
+	}
+	next := w.Next(now)
+	switch {
+	case next.IsZero():
+		return false, fmt.Errorf("maintenance window never opens")
+	case c.Outside == windowOutsideExit:
+		logrus.Warnf("outside of maintenance window, it opens at %s", next.Format(time.RFC3339))
+		return false, nil
+	case c.MaxWait > 0 && next.Sub(now) > c.MaxWait:
+		logrus.Warnf("maintenance window opens at %s, later than in %s", next.Format(time.RFC3339), c.MaxWait)
+		return false, nil
+	}
+	logrus.Infof("waiting for maintenance window opening at %s", next.Format(time.RFC3339))
+	time.Sleep(time.Until(next))
+	return true, nil
+}